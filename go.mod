@@ -2,8 +2,9 @@ module velox.eparker.dev
 
 go 1.23.0
 
+require github.com/llir/llvm v0.3.6
+
 require (
-	github.com/llir/llvm v0.3.6 // indirect
 	github.com/mewmew/float v0.0.0-20201204173432-505706aa38fa // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/mod v0.4.2 // indirect
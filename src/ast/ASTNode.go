@@ -1,11 +1,91 @@
 package ast
 
-import "fmt"
+import (
+	"fmt"
+
+	"velox.eparker.dev/src/tokenizer"
+)
 
 type ASTNode struct {
-	Type     ASTNodeType
-	Children []*ASTNode
-	Name     string
+	Type         ASTNodeType
+	Children     []*ASTNode
+	Name         string
+	Line, Column int
+	File         string
+	Expansion    *tokenizer.Expansion // Macro the node was expanded from, if any
+	Modifiers    []string             // Qualifiers written before a declaration, e.g. "const"
+	ResolvedType string               // Filled in by semantic analysis
+	// Set by semantic analysis on a declaration whose ::prev is read, so the builder keeps its previous value
+	TracksPrevious bool
+	// Set by semantic analysis on a variable or parameter written after its declaration, directly or through
+	// a ref parameter, a pointer or a by-reference capture
+	Written bool
+	// Type parameters of a generic function or class, identifiers with their constraint as only child
+	TypeParameters []*ASTNode
+	// Type arguments written at a call of a generic function, `max<float>(1, 2)`
+	TypeArguments []*ASTNode
+}
+
+func (node *ASTNode) HasModifier(modifier string) bool {
+	for _, m := range node.Modifiers {
+		if m == modifier {
+			return true
+		}
+	}
+
+	return false
+}
+
+// At records the source position of token on the node
+func (node *ASTNode) At(token tokenizer.Token) *ASTNode {
+	node.Line = token.Line
+	node.Column = token.Column
+	node.File = token.File
+	node.Expansion = token.Expansion
+	return node
+}
+
+// IsGeneric reports whether node declares a generic function or class, a template for its instantiations
+func (node *ASTNode) IsGeneric() bool {
+	return len(node.TypeParameters) > 0
+}
+
+// Clone copies node and everything below it, so an instantiation of a generic can be analyzed on its own
+func (node *ASTNode) Clone() *ASTNode {
+	if node == nil {
+		return nil
+	}
+
+	copied := *node
+	copied.Children = cloneAll(node.Children)
+	copied.TypeParameters = cloneAll(node.TypeParameters)
+	copied.TypeArguments = cloneAll(node.TypeArguments)
+	copied.Modifiers = append([]string(nil), node.Modifiers...)
+
+	return &copied
+}
+
+func cloneAll(nodes []*ASTNode) []*ASTNode {
+	if nodes == nil {
+		return nil
+	}
+
+	copies := make([]*ASTNode, len(nodes))
+	for i, node := range nodes {
+		copies[i] = node.Clone()
+	}
+
+	return copies
+}
+
+// IsPrototype reports whether a function declaration has no body
+func (node *ASTNode) IsPrototype() bool {
+	return node.Type == FunctionDeclaration && len(node.Children) < 3
+}
+
+// IsSuper reports whether node is the receiver `super`
+func (node *ASTNode) IsSuper() bool {
+	return node.Type == Identifier && node.Name == "super"
 }
 
 func (node *ASTNode) String() string { // Tree representation
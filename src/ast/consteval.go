@@ -0,0 +1,322 @@
+package ast
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ConstantValue is the result of evaluating an expression at compile time
+type ConstantValue struct {
+	Type  string // "int", "char", "bool", "float" or "string"
+	Int   int64
+	Float float64
+	Str   string
+}
+
+type ConstantLookup func(name string) (ConstantValue, bool)
+
+func (c ConstantValue) IsFloat() bool {
+	return c.Type == "float"
+}
+
+func (c ConstantValue) AsFloat() float64 {
+	if c.IsFloat() {
+		return c.Float
+	}
+
+	return float64(c.Int)
+}
+
+// Convert applies the same conversion the builder would emit at runtime
+func (c ConstantValue) Convert(target string) ConstantValue {
+	if c.Type == "string" || target == "string" {
+		return c
+	}
+
+	if target == "float" {
+		return ConstantValue{Type: "float", Float: c.AsFloat()}
+	}
+
+	v := c.Int
+	if c.IsFloat() {
+		v = int64(c.Float)
+	}
+
+	switch target {
+	case "bool":
+		v &= 1
+	case "char":
+		v = int64(int8(v))
+	default:
+		v = int64(int32(v))
+	}
+
+	return ConstantValue{Type: target, Int: v}
+}
+
+func (c ConstantValue) String() string {
+	switch c.Type {
+	case "float":
+		return strconv.FormatFloat(c.Float, 'g', -1, 64)
+	case "string":
+		return strconv.Quote(c.Str)
+	case "char":
+		return strconv.QuoteRune(rune(c.Int))
+	}
+
+	return strconv.FormatInt(c.Int, 10)
+}
+
+// EvaluateConstant folds node into a single value. Identifiers are resolved through lookup,
+// anything that cannot be known at compile time is an error
+func EvaluateConstant(node *ASTNode, lookup ConstantLookup) (ConstantValue, error) {
+	switch node.Type {
+	case Literal:
+		switch LiteralType(node.Name) {
+		case "string":
+			str, err := ParseStringLiteral(node.Name)
+			return ConstantValue{Type: "string", Str: str}, err
+		case "char":
+			char, err := ParseCharLiteral(node.Name)
+			return ConstantValue{Type: "char", Int: int64(char)}, err
+		case "bool":
+			switch node.Name {
+			case "true":
+				return ConstantValue{Type: "bool", Int: 1}, nil
+			case "false":
+				return ConstantValue{Type: "bool", Int: 0}, nil
+			}
+
+			return ConstantValue{}, fmt.Errorf("'%s' is only decided at runtime", node.Name)
+		case "null":
+			return ConstantValue{Type: "null"}, nil
+		}
+
+		if v, err := strconv.ParseInt(node.Name, 10, 64); err == nil {
+			return ConstantValue{Type: "int", Int: v}.Convert("int"), nil
+		}
+
+		if v, err := strconv.ParseFloat(node.Name, 64); err == nil {
+			return ConstantValue{Type: "float", Float: v}, nil
+		}
+
+		return ConstantValue{}, fmt.Errorf("unsupported literal %s", node.Name)
+	case Identifier:
+		if lookup != nil {
+			if v, ok := lookup(node.Name); ok {
+				return v, nil
+			}
+		}
+
+		return ConstantValue{}, fmt.Errorf("'%s' is not a constant", node.Name)
+	case CastExpression:
+		operand, err := EvaluateConstant(node.Children[0], lookup)
+		if err != nil {
+			return ConstantValue{}, err
+		}
+
+		return operand.Convert(node.Name), nil
+	case BinaryExpression:
+		return evaluateBinary(node, lookup)
+	case UnaryExpression:
+		operand, err := EvaluateConstant(node.Children[0], lookup)
+		if err != nil {
+			return ConstantValue{}, err
+		}
+
+		if operand.Type == "string" {
+			return ConstantValue{}, fmt.Errorf("operator '%s' cannot be applied to a string", node.Name)
+		}
+
+		switch node.Name {
+		case "-":
+			if operand.IsFloat() {
+				return ConstantValue{Type: "float", Float: -operand.Float}, nil
+			}
+
+			return ConstantValue{Type: "int", Int: -operand.Int}.Convert("int"), nil
+		case "!":
+			return compareConstants("==", operand.AsFloat(), 0), nil
+		}
+
+		return ConstantValue{}, fmt.Errorf("'%s' is not allowed in a constant expression", node.Name)
+	}
+
+	return ConstantValue{}, fmt.Errorf("%s is only evaluated at runtime", describeExpression(node))
+}
+
+// describeExpression spells an expression that is only evaluated at runtime the way it is written
+func describeExpression(node *ASTNode) string {
+	switch node.Type {
+	case FunctionCall:
+		return fmt.Sprintf("a call of '%s'", node.Name)
+	case MethodCall:
+		return fmt.Sprintf("a call of method '%s'", node.Name)
+	case CallExpression:
+		return "a call through a function value"
+	case NewExpression:
+		return fmt.Sprintf("'New %s'", node.Name)
+	case Lambda:
+		return "a lambda"
+	case MemberAccess:
+		return fmt.Sprintf("field '%s'", node.Name)
+	case ArrayAccess:
+		return "an array element"
+	case ArrayInitializer:
+		return "an array literal"
+	case StructLiteral:
+		return "a struct literal"
+	case UnionLiteral:
+		return "a union value"
+	case MatchExpression:
+		return "a 'match' expression"
+	case PostfixExpression:
+		return fmt.Sprintf("'%s'", node.Name)
+	case MacroExpansion:
+		return fmt.Sprintf("'::%s'", node.Name)
+	}
+
+	return "this expression"
+}
+
+func evaluateBinary(node *ASTNode, lookup ConstantLookup) (ConstantValue, error) {
+	left, err := EvaluateConstant(node.Children[0], lookup)
+	if err != nil {
+		return ConstantValue{}, err
+	}
+
+	right, err := EvaluateConstant(node.Children[1], lookup)
+	if err != nil {
+		return ConstantValue{}, err
+	}
+
+	if left.Type == "string" || right.Type == "string" {
+		return ConstantValue{}, fmt.Errorf("operator '%s' cannot be applied to strings", node.Name)
+	}
+
+	switch node.Name {
+	case "&&":
+		return compareConstants("!=", boolConstant(left.AsFloat() != 0 && right.AsFloat() != 0), 0), nil
+	case "||":
+		return compareConstants("!=", boolConstant(left.AsFloat() != 0 || right.AsFloat() != 0), 0), nil
+	}
+
+	// Usual arithmetic conversions, matching the builder
+	if left.IsFloat() || right.IsFloat() {
+		l, r := left.AsFloat(), right.AsFloat()
+
+		switch node.Name {
+		case "+":
+			return ConstantValue{Type: "float", Float: l + r}, nil
+		case "-":
+			return ConstantValue{Type: "float", Float: l - r}, nil
+		case "*":
+			return ConstantValue{Type: "float", Float: l * r}, nil
+		case "/":
+			return ConstantValue{Type: "float", Float: l / r}, nil
+		case "%":
+			return ConstantValue{Type: "float", Float: math.Mod(l, r)}, nil
+		case "==", "!=", "<", "<=", ">", ">=":
+			return compareConstants(node.Name, l, r), nil
+		}
+
+		return ConstantValue{}, fmt.Errorf("unsupported operator '%s' in constant expression", node.Name)
+	}
+
+	l, r := left.Convert("int").Int, right.Convert("int").Int
+
+	switch node.Name {
+	case "+":
+		return ConstantValue{Type: "int", Int: l + r}.Convert("int"), nil
+	case "-":
+		return ConstantValue{Type: "int", Int: l - r}.Convert("int"), nil
+	case "*":
+		return ConstantValue{Type: "int", Int: l * r}.Convert("int"), nil
+	case "/", "%":
+		if r == 0 {
+			return ConstantValue{}, fmt.Errorf("division by zero in constant expression")
+		}
+
+		if node.Name == "/" {
+			return ConstantValue{Type: "int", Int: l / r}.Convert("int"), nil
+		}
+
+		return ConstantValue{Type: "int", Int: l % r}.Convert("int"), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		return compareConstants(node.Name, float64(l), float64(r)), nil
+	}
+
+	return ConstantValue{}, fmt.Errorf("unsupported operator '%s' in constant expression", node.Name)
+}
+
+func compareConstants(operator string, l, r float64) ConstantValue {
+	var result bool
+
+	switch operator {
+	case "==":
+		result = l == r
+	case "!=":
+		result = l != r
+	case "<":
+		result = l < r
+	case "<=":
+		result = l <= r
+	case ">":
+		result = l > r
+	case ">=":
+		result = l >= r
+	}
+
+	if result {
+		return ConstantValue{Type: "bool", Int: 1}
+	}
+
+	return ConstantValue{Type: "bool", Int: 0}
+}
+
+func boolConstant(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// LiteralType classifies the text of a literal token
+func LiteralType(literal string) string {
+	switch {
+	case literal == "true" || literal == "false" || literal == "maybe":
+		return "bool"
+	case literal == "null":
+		return "null"
+	case strings.HasPrefix(literal, "\""):
+		return "string"
+	case strings.HasPrefix(literal, "'"):
+		return "char"
+	case strings.ContainsAny(literal, ".eE"):
+		return "float"
+	}
+
+	return "int"
+}
+
+// ParseStringLiteral strips the quotes of a string literal and resolves its escapes
+func ParseStringLiteral(literal string) (string, error) {
+	str, err := strconv.Unquote(literal)
+	if err != nil {
+		return "", fmt.Errorf("invalid string literal %s", literal)
+	}
+
+	return str, nil
+}
+
+func ParseCharLiteral(literal string) (byte, error) {
+	char, _, tail, err := strconv.UnquoteChar(literal[1:len(literal)-1], '\'')
+	if err != nil || tail != "" || char > 0xFF {
+		return 0, fmt.Errorf("invalid character literal %s", literal)
+	}
+
+	return byte(char), nil
+}
@@ -3,6 +3,7 @@ package ast
 import (
 	"fmt"
 	"os"
+	"slices"
 
 	"velox.eparker.dev/src/tokenizer"
 )
@@ -34,6 +35,22 @@ const (
 	Literal
 	Identifier
 	FunctionCall
+	CastExpression
+	ImportDeclaration
+	Lambda
+	CaptureList
+	StructDeclaration
+	StructLiteral
+	EnumDeclaration
+	SwitchStatement
+	MatchExpression
+	Case
+	UnionDeclaration
+	UnionLiteral
+	NewExpression
+	MethodCall
+	InterfaceDeclaration
+	CallExpression
 )
 
 var ASTNodeTypeNames map[ASTNodeType]string = map[ASTNodeType]string{
@@ -61,6 +78,22 @@ var ASTNodeTypeNames map[ASTNodeType]string = map[ASTNodeType]string{
 	Literal:                "Literal",
 	Identifier:             "Identifier",
 	FunctionCall:           "FunctionCall",
+	CastExpression:         "CastExpression",
+	ImportDeclaration:      "ImportDeclaration",
+	Lambda:                 "Lambda",
+	CaptureList:            "CaptureList",
+	StructDeclaration:      "StructDeclaration",
+	StructLiteral:          "StructLiteral",
+	EnumDeclaration:        "EnumDeclaration",
+	SwitchStatement:        "SwitchStatement",
+	MatchExpression:        "MatchExpression",
+	Case:                   "Case",
+	UnionDeclaration:       "UnionDeclaration",
+	UnionLiteral:           "UnionLiteral",
+	NewExpression:          "NewExpression",
+	MethodCall:             "MethodCall",
+	InterfaceDeclaration:   "InterfaceDeclaration",
+	CallExpression:         "CallExpression",
 }
 
 type Parser struct {
@@ -68,6 +101,9 @@ type Parser struct {
 	current     int
 	debugMode   bool
 	prattParser *PrattParser
+	reversed    map[string]bool // Files ending with `oops`, whose statements are written last to first
+	// Blocks whose written order is checked once the file is parsed, when the names bound around them are known
+	orders map[*ASTNode]blockOrder
 }
 
 func NewParser(tokens []tokenizer.Token, debugMode bool) *Parser {
@@ -76,6 +112,8 @@ func NewParser(tokens []tokenizer.Token, debugMode bool) *Parser {
 		debugMode:   debugMode,
 		current:     0,
 		prattParser: NewPrattParser(tokens),
+		orders:      make(map[*ASTNode]blockOrder),
+		reversed:    make(map[string]bool),
 	}
 
 	out.prattParser.parser = out
@@ -86,6 +124,20 @@ func NewParser(tokens []tokenizer.Token, debugMode bool) *Parser {
 func (p *Parser) Parse() *ASTNode {
 	program := &ASTNode{Type: Program, Children: []*ASTNode{}, Name: "Program"}
 
+	// A trailing `oops` switches its file to reversed order, so an included header keeps its own order.
+	// Only the statements inside blocks are reversed, top-level declarations and directives are read as written
+	tokens := p.tokens[:0:0]
+	for i, token := range p.tokens {
+		if token.Type == tokenizer.Keyword && token.Value == "oops" && (i == len(p.tokens)-1 || p.tokens[i+1].File != token.File) {
+			p.reversed[token.File] = true
+			continue
+		}
+
+		tokens = append(tokens, token)
+	}
+	p.tokens = tokens
+	p.prattParser.tokens = tokens
+
 	for p.current < len(p.tokens) {
 		token := p.Peek()
 
@@ -94,23 +146,58 @@ func (p *Parser) Parse() *ASTNode {
 			program.Children = append(program.Children, p.ParsePreprocessorDirective())
 		case tokenizer.Keyword:
 			switch token.Value {
-			case "int", "float", "char", "void":
-				program.Children = append(program.Children, p.ParseFunctionDeclaration())
+			case "int", "float", "char", "bool", "void":
+				// `type name(` or `type name<` starts a function, anything else is a global variable
+				if p.startsFunction() {
+					program.Children = append(program.Children, p.ParseFunctionDeclaration())
+				} else {
+					program.Children = append(program.Children, p.ParseVariableDeclaration())
+				}
+			case "const", "weak":
+				program.Children = append(program.Children, p.ParseVariableDeclaration())
+			case "struct":
+				program.Children = append(program.Children, p.ParseStructDeclaration())
+			case "enum":
+				program.Children = append(program.Children, p.ParseEnumDeclaration())
+			case "union":
+				program.Children = append(program.Children, p.ParseUnionDeclaration())
+			case "class":
+				program.Children = append(program.Children, p.ParseClassDeclaration())
+			case "interface":
+				program.Children = append(program.Children, p.ParseInterfaceDeclaration())
+			case "import":
+				program.Children = append(program.Children, p.ParseImport())
+			case "oops":
+				p.Error("'oops' can only be the last word of a file", token)
 			default:
 				p.UnexpectedError(token)
 			}
+		case tokenizer.Identifier:
+			// Struct types start declarations the same way, `Point origin;` or `Point make(int x)`
+			if !p.isDeclaration() {
+				p.UnexpectedError(token)
+			} else if p.startsFunction() {
+				program.Children = append(program.Children, p.ParseFunctionDeclaration())
+			} else {
+				program.Children = append(program.Children, p.ParseVariableDeclaration())
+			}
 		default:
 			p.UnexpectedError(token)
 		}
 	}
 
+	p.checkOrders(program, nil)
+
 	return program
 }
 
 func (p *Parser) Error(format string, tokens ...tokenizer.Token) {
 	if len(tokens) > 0 {
-		token := tokens[0]
-		fmt.Printf("Line %d, Column %d: %s\n", token.Line, token.Column, format)
+		fmt.Printf("%s: %s\n", tokens[0].Position(), format)
+
+		for _, note := range tokens[0].Expansion.Notes() {
+			fmt.Println(note)
+		}
 	} else {
 		fmt.Println(format)
 	}
@@ -146,6 +233,14 @@ func (p *Parser) PeekNext() tokenizer.Token {
 	return p.tokens[p.current+1]
 }
 
+func (p *Parser) PeekAt(offset int) tokenizer.Token {
+	if p.current+offset >= len(p.tokens) {
+		return tokenizer.Token{}
+	}
+
+	return p.tokens[p.current+offset]
+}
+
 func (p *Parser) Consume() tokenizer.Token {
 	token := p.Peek()
 	p.current++
@@ -182,23 +277,57 @@ func (p *Parser) ExpectValue(tokenType tokenizer.TokenType, value string) tokeni
 	return token
 }
 
+// ParsePreprocessorDirective parses `#define NAME [expression]` and `#undef NAME`. A directive ends
+// at the end of its line or at the next directive: { name, value expression if any }
 func (p *Parser) ParsePreprocessorDirective() *ASTNode {
-	node := &ASTNode{Type: PreprocessorDirective}
+	directive := p.Expect(tokenizer.Preprocessor)
+	node := (&ASTNode{Type: PreprocessorDirective, Name: directive.Value}).At(directive)
 
-	node.Name = p.Expect(tokenizer.Preprocessor).Value
 	child := p.Expect(tokenizer.Identifier)
-	node.Children = append(node.Children, &ASTNode{Type: Identifier, Name: child.Value})
-	if p.Match(tokenizer.Number) {
-		child := p.Expect(tokenizer.Number)
-		node.Children = append(node.Children, &ASTNode{Type: Literal, Name: child.Value})
-	} else if p.Match(tokenizer.String) {
-		child := p.Expect(tokenizer.String)
-		node.Children = append(node.Children, &ASTNode{Type: Literal, Name: child.Value})
+	node.Children = append(node.Children, (&ASTNode{Type: Identifier, Name: child.Value}).At(child))
+
+	var valueTokens []tokenizer.Token
+	for p.current < len(p.tokens) && p.Peek().Line == directive.Line && p.Peek().File == directive.File && !p.Match(tokenizer.Preprocessor) {
+		valueTokens = append(valueTokens, p.Consume())
+	}
+
+	switch directive.Value {
+	case "#define":
+		if len(valueTokens) > 0 {
+			node.Children = append(node.Children, ParseTokenExpression(valueTokens, p.debugMode))
+		}
+	case "#undef":
+		if len(valueTokens) > 0 {
+			p.UnexpectedError(valueTokens[0])
+		}
+	default:
+		p.Error(fmt.Sprintf("Unknown preprocessor directive %s", directive.Value), directive)
 	}
 
 	return node
 }
 
+// ParseImport parses `import name;`. The loader fills in the module's declarations as children
+func (p *Parser) ParseImport() *ASTNode {
+	keyword := p.ExpectValue(tokenizer.Keyword, "import")
+	name := p.Expect(tokenizer.Identifier)
+	p.ExpectValue(tokenizer.Punctuation, ";")
+
+	return (&ASTNode{Type: ImportDeclaration, Name: name.Value}).At(keyword)
+}
+
+// ParseTokenExpression parses a standalone token run, all of which must belong to one expression
+func ParseTokenExpression(tokens []tokenizer.Token, debugMode bool) *ASTNode {
+	sub := NewParser(tokens, debugMode)
+	expr := sub.ParseExpression()
+
+	if sub.current < len(sub.tokens) {
+		sub.UnexpectedError(sub.tokens[sub.current])
+	}
+
+	return expr
+}
+
 func (p *Parser) ParseExpression() *ASTNode {
 	return p.prattParser.Parse()
 }
@@ -218,11 +347,11 @@ func (p *Parser) ParseBinaryExpression(precedence int) *ASTNode {
 
 		right := p.ParseBinaryExpression(opPrecedence + 1)
 
-		left = &ASTNode{
+		left = (&ASTNode{
 			Type:     BinaryExpression,
 			Name:     op.Value,
 			Children: []*ASTNode{left, right},
-		}
+		}).At(op)
 	}
 
 	return left
@@ -232,11 +361,11 @@ func (p *Parser) ParseUnary() *ASTNode {
 	if p.Match(tokenizer.Operator) {
 		op := p.Consume()
 		fmt.Println(op.String())
-		return &ASTNode{
+		return (&ASTNode{
 			Type:     UnaryExpression,
 			Name:     op.Value,
 			Children: []*ASTNode{p.ParseUnary()},
-		}
+		}).At(op)
 	}
 
 	return p.ParsePrimary()
@@ -244,9 +373,11 @@ func (p *Parser) ParseUnary() *ASTNode {
 
 func (p *Parser) ParsePrimary() *ASTNode {
 	if p.Match(tokenizer.Number) {
-		return &ASTNode{Type: Literal, Name: p.Consume().Value}
+		token := p.Consume()
+		return (&ASTNode{Type: Literal, Name: token.Value}).At(token)
 	} else if p.Match(tokenizer.Identifier) {
-		return &ASTNode{Type: Identifier, Name: p.Consume().Value}
+		token := p.Consume()
+		return (&ASTNode{Type: Identifier, Name: token.Value}).At(token)
 	} else if p.MatchValue(tokenizer.Punctuation, "(") {
 		p.Consume() // consume '('
 		node := p.ParseExpression()
@@ -258,15 +389,135 @@ func (p *Parser) ParsePrimary() *ASTNode {
 	}
 }
 
+// startsFunction reports whether the declaration at the current position is a function, whose name is
+// followed by its parameters or, for a generic function, its type parameters
+func (p *Parser) startsFunction() bool {
+	next := p.PeekAt(p.typeLength() + 1)
+	return next.Type == tokenizer.Punctuation && next.Value == "(" || next.Type == tokenizer.Operator && next.Value == "<"
+}
+
+// ParseType parses a type name. Each parenthesized list after it makes a function type, so
+// `int(int, int)` is a function taking two ints and returning an int, and `[]` an array type. Struct
+// types are named by an identifier, or `module.Name` for one declared in an imported module, and
+// instances of generic classes by their type arguments, `List<int>`
+func (p *Parser) ParseType() *ASTNode {
+	start := p.Consume()
+	node := (&ASTNode{Type: Identifier, Name: start.Value}).At(start)
+
+	switch start.Type {
+	case tokenizer.Keyword:
+	case tokenizer.Identifier:
+		if p.MatchValue(tokenizer.Punctuation, ".") {
+			p.Consume()
+			node.Name += "." + p.Expect(tokenizer.Identifier).Value
+		}
+
+		if typeArgumentsLength(p.tokens, p.current) > 0 {
+			var args []string
+			for _, arg := range p.parseTypeArguments() {
+				args = append(args, arg.Name)
+			}
+
+			node.Name = GenericType(node.Name, args)
+		}
+	default:
+		p.ExpectedError("type", start)
+	}
+
+	for {
+		// `int*` points to an int, `int(int)*` to a function value
+		if p.MatchValue(tokenizer.Operator, "*") {
+			p.Consume()
+			node.Name = PointerType(node.Name)
+			continue
+		}
+
+		if p.MatchValue(tokenizer.Punctuation, "[") && p.PeekNext().Value == "]" {
+			p.Consume()
+			p.Consume()
+			node.Name = ArrayType(node.Name)
+			continue
+		}
+
+		if !p.MatchValue(tokenizer.Punctuation, "(") {
+			break
+		}
+
+		p.Consume()
+
+		var params []string
+		for !p.MatchValue(tokenizer.Punctuation, ")") {
+			params = append(params, p.ParseType().Name)
+
+			if p.MatchValue(tokenizer.Punctuation, ",") {
+				p.Consume()
+			} else if !p.MatchValue(tokenizer.Punctuation, ")") {
+				p.ExpectedError("',' or ')'", p.Peek())
+			}
+		}
+		p.ExpectValue(tokenizer.Punctuation, ")")
+
+		node.Name = FunctionType(node.Name, params)
+	}
+
+	return node
+}
+
+// typeLength counts the tokens of the type name at the current position without consuming them
+func (p *Parser) typeLength() int {
+	length := 1
+	if p.Match(tokenizer.Identifier) && p.PeekAt(1).Value == "." && p.PeekAt(2).Type == tokenizer.Identifier {
+		length = 3
+	}
+
+	if p.Match(tokenizer.Identifier) {
+		length += typeArgumentsLength(p.tokens, p.current+length)
+	}
+
+	for {
+		if next := p.PeekAt(length); next.Type == tokenizer.Operator && next.Value == "*" {
+			length++
+			continue
+		}
+
+		if p.PeekAt(length).Value == "[" && p.PeekAt(length+1).Value == "]" {
+			length += 2
+			continue
+		}
+
+		if p.PeekAt(length).Type != tokenizer.Punctuation || p.PeekAt(length).Value != "(" {
+			break
+		}
+
+		for depth := 0; p.current+length < len(p.tokens); {
+			switch p.PeekAt(length).Value {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+
+			length++
+			if depth == 0 {
+				break
+			}
+		}
+	}
+
+	return length
+}
+
 func (p *Parser) ParseFunctionDeclaration() *ASTNode {
-	returnType := p.Expect(tokenizer.Keyword) // Expect return type (e.g., "int")
-	name := p.Expect(tokenizer.Identifier)    // Expect function name
-	node := &ASTNode{
-		Type: FunctionDeclaration,
-		Name: name.Value,
-		Children: []*ASTNode{
-			{Type: Identifier, Name: returnType.Value}, // Store return type
-		},
+	returnType := p.ParseType()            // Expect return type (e.g., "int")
+	name := p.Expect(tokenizer.Identifier) // Expect function name
+	node := (&ASTNode{
+		Type:     FunctionDeclaration,
+		Name:     name.Value,
+		Children: []*ASTNode{returnType},
+	}).At(name)
+
+	if p.MatchValue(tokenizer.Operator, "<") {
+		node.TypeParameters = p.parseTypeParameters()
 	}
 
 	p.ExpectValue(tokenizer.Punctuation, "(")
@@ -274,6 +525,12 @@ func (p *Parser) ParseFunctionDeclaration() *ASTNode {
 	node.Children = append(node.Children, params)
 	p.ExpectValue(tokenizer.Punctuation, ")")
 
+	// A prototype ends with ';' and has no body: { return type, parameters }
+	if p.MatchValue(tokenizer.Punctuation, ";") {
+		p.Consume()
+		return node
+	}
+
 	// Parse function body
 	body := p.ParseBlock()
 	node.Children = append(node.Children, body)
@@ -282,19 +539,25 @@ func (p *Parser) ParseFunctionDeclaration() *ASTNode {
 }
 
 func (p *Parser) ParseParameters() *ASTNode {
-	params := &ASTNode{Type: Parameters, Name: "Parameters"}
+	params := (&ASTNode{Type: Parameters, Name: "Parameters"}).At(p.Peek())
 
 	for !p.MatchValue(tokenizer.Punctuation, ")") {
-		if p.Match(tokenizer.Keyword) {
-			paramType := p.Consume()
-			paramName := p.Expect(tokenizer.Identifier)
-			param := &ASTNode{
-				Type: VariableDeclaration,
-				Name: paramName.Value,
-				Children: []*ASTNode{
-					{Type: Identifier, Name: paramType.Value},
-				},
+		if p.Match(tokenizer.Keyword) || p.Match(tokenizer.Identifier) {
+			modifiers := p.ParseModifiers()
+			start := p.Peek()
+			param := (&ASTNode{
+				Type:      VariableDeclaration,
+				Children:  []*ASTNode{p.ParseType()},
+				Modifiers: modifiers,
+			}).At(start)
+
+			// Names are optional so prototypes can be written as `int f(int, int);`
+			if p.Match(tokenizer.Identifier) {
+				paramName := p.Consume()
+				param.Name = paramName.Value
+				param.At(paramName)
 			}
+
 			params.Children = append(params.Children, param)
 
 			if p.MatchValue(tokenizer.Punctuation, ",") {
@@ -313,64 +576,171 @@ func (p *Parser) ParseParameters() *ASTNode {
 }
 
 func (p *Parser) ParseBlock() *ASTNode {
-	node := &ASTNode{Type: Block, Name: "Block"}
+	open := p.Peek()
+	node := (&ASTNode{Type: Block, Name: "Block"}).At(open)
 
 	p.ExpectValue(tokenizer.Punctuation, "{")
 
+	var origins []*tokenizer.Expansion
 	for !p.MatchValue(tokenizer.Punctuation, "}") {
 		if p.current >= len(p.tokens) {
 			p.Error("Unexpected end of input while parsing block")
 			return node
 		}
+		start := p.current
 		stmt := p.ParseStatement()
 		if stmt != nil {
 			node.Children = append(node.Children, stmt)
+			origins = append(origins, origin(p.tokens[start:p.current]))
 		}
 	}
 
 	p.ExpectValue(tokenizer.Punctuation, "}")
 
+	units := orderUnits(node.Children, origins)
+	p.orders[node] = blockOrder{units: units, open: open}
+
+	if p.reversed[open.File] {
+		node.Children = reverseUnits(units)
+	}
+
 	return node
 }
 
 var assignmentOperators = []string{"=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<=", ">>="}
 
 func (p *Parser) ParseStatement() *ASTNode {
+	// A #define or #undef inside a body takes effect from where it is written, as at the top level
+	if p.Match(tokenizer.Preprocessor) {
+		return p.ParsePreprocessorDirective()
+	}
+
 	if p.Match(tokenizer.Keyword) {
 		switch p.Peek().Value {
 		case "return":
 			return p.ParseReturnStatement()
-		case "int", "float", "char":
+		case "int", "float", "char", "bool", "void", "const", "ref", "weak":
 			return p.ParseVariableDeclaration()
+		case "unsafe":
+			return p.ParseUnsafeBlock()
+		case "delete":
+			return p.ParseDelete()
 		case "if":
 			return p.ParseConditional()
 		case "while":
 			return p.ParseWhileStatement()
+		case "switch":
+			return p.ParseSwitchStatement()
 		case "continue", "break":
 			return p.ParseControlFlow()
+		case "super":
+			// `super.method();` is evaluated for its side effect
+			node := p.ParseExpression()
+			p.ExpectValue(tokenizer.Punctuation, ";")
+			return node
+		case "oops":
+			p.Error("'oops' can only be the last word of a file", p.Peek())
 		default:
 			p.UnexpectedError(p.Peek())
 		}
 	}
 
+	if p.isDeclaration() {
+		return p.ParseVariableDeclaration()
+	}
+
+	if p.MatchValue(tokenizer.Operator, "*") {
+		return p.ParseIndirectAssignment()
+	}
+
 	if p.Match(tokenizer.Identifier) && p.PeekNext().Value == "(" && p.PeekNext().Type == tokenizer.Punctuation {
 		return p.ParseFunctionCall()
 	}
 
 	if p.Match(tokenizer.Identifier) {
+		target := p.fieldPathLength()
 		for _, op := range assignmentOperators {
-			if p.PeekNext().Value == op {
+			if p.PeekAt(target).Value == op {
 				return p.ParseAssignment()
 			}
 		}
+
+		// Elements are written like pointers, `list[i] = 1` changes the array rather than the variable
+		if p.PeekAt(target).Value == "[" {
+			return p.ParseIndirectAssignment()
+		}
+	}
+
+	// `x++;`, `++x;`, `module.function();`, `swap<int>(a, b);` and friends are evaluated for their side effect
+	if isIncrement(p.Peek()) || (p.Match(tokenizer.Identifier) && (isIncrement(p.PeekNext()) || p.PeekNext().Value == "." || typeArgumentsLength(p.tokens, p.current+1) > 0)) {
+		node := p.ParseExpression()
+		p.ExpectValue(tokenizer.Punctuation, ";")
+		return node
 	}
 
 	p.UnexpectedError(p.Peek())
 	return nil
 }
 
+// isDeclaration reports whether the statement at the current position declares a variable of a struct
+// type, which like any declaration is a type followed by a name
+func (p *Parser) isDeclaration() bool {
+	return p.Match(tokenizer.Identifier) && p.PeekAt(p.typeLength()).Type == tokenizer.Identifier
+}
+
+// fieldPathLength counts the tokens of `name.field.field` at the current position
+func (p *Parser) fieldPathLength() int {
+	length := 1
+	for p.PeekAt(length).Value == "." && p.PeekAt(length+1).Type == tokenizer.Identifier {
+		length += 2
+	}
+
+	return length
+}
+
+func isIncrement(token tokenizer.Token) bool {
+	return token.Type == tokenizer.Operator && (token.Value == "++" || token.Value == "--")
+}
+
+var declarationModifiers = []string{"const", "ref", "weak"}
+
+// methodModifiers may precede a method of a class
+var methodModifiers = []string{"virtual", "override"}
+
+// ParseModifiers consumes the qualifiers that may precede a declaration's type
+func (p *Parser) ParseModifiers() []string {
+	return p.parseKeywords(declarationModifiers)
+}
+
+// parseKeywords consumes any of the keywords in allowed, in any order
+func (p *Parser) parseKeywords(allowed []string) []string {
+	var modifiers []string
+
+	for p.Match(tokenizer.Keyword) {
+		matched := false
+
+		for _, modifier := range allowed {
+			if p.Peek().Value == modifier {
+				modifiers = append(modifiers, p.Consume().Value)
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			break
+		}
+	}
+
+	return modifiers
+}
+
 func (p *Parser) ParseVariableDeclaration() *ASTNode {
-	vType := p.Expect(tokenizer.Keyword) // Expect type (e.g., "int", "float", "char")
+	modifiers := p.ParseModifiers()
+	typeNode := p.ParseType()              // Expect type (e.g., "int", "float", "char")
+	name := p.Expect(tokenizer.Identifier) // Expect variable name
+
+	// `int list[]` is the C spelling of `int[] list`
 	arrayType := false
 	if p.MatchValue(tokenizer.Punctuation, "[") {
 		p.Consume()                               // Consume "["
@@ -378,12 +748,9 @@ func (p *Parser) ParseVariableDeclaration() *ASTNode {
 		arrayType = true
 	}
 
-	name := p.Expect(tokenizer.Identifier) // Expect variable name
-
-	node := &ASTNode{Type: VariableDeclaration, Name: name.Value}
-	typeNode := &ASTNode{Type: Identifier, Name: vType.Value}
+	node := (&ASTNode{Type: VariableDeclaration, Name: name.Value, Modifiers: modifiers}).At(name)
 	if arrayType {
-		typeNode.Name += "[]" // Mark as an array type
+		typeNode.Name = ArrayType(typeNode.Name)
 	}
 	node.Children = append(node.Children, typeNode)
 
@@ -401,7 +768,7 @@ func (p *Parser) ParseVariableDeclaration() *ASTNode {
 }
 
 func (p *Parser) ParseArrayInitializer() *ASTNode {
-	node := &ASTNode{Type: ArrayInitializer, Name: "ArrayInitializer"}
+	node := (&ASTNode{Type: ArrayInitializer, Name: "ArrayInitializer"}).At(p.Peek())
 
 	p.ExpectValue(tokenizer.Punctuation, "{")
 	for !p.MatchValue(tokenizer.Punctuation, "}") {
@@ -419,20 +786,37 @@ func (p *Parser) ParseArrayInitializer() *ASTNode {
 }
 
 func (p *Parser) ParseReturnStatement() *ASTNode {
-	p.Expect(tokenizer.Keyword) // Consume "return"
-	node := &ASTNode{Type: ReturnStatement, Name: "return"}
-	node.Children = append(node.Children, p.ParseExpression())
+	keyword := p.Expect(tokenizer.Keyword) // Consume "return"
+	node := (&ASTNode{Type: ReturnStatement, Name: "return"}).At(keyword)
+
+	// A bare `return;` carries no value
+	if !p.MatchValue(tokenizer.Punctuation, ";") {
+		node.Children = append(node.Children, p.ParseExpression())
+	}
+
 	p.ExpectValue(tokenizer.Punctuation, ";")
 	return node
 }
 
 func (p *Parser) ParseFunctionCall() *ASTNode {
 	name := p.Expect(tokenizer.Identifier)
-	node := &ASTNode{Type: FunctionCall, Name: name.Value}
+	node := p.parseCallArguments((&ASTNode{Type: FunctionCall, Name: name.Value}).At(name))
+
+	// `pick(1)(3, 3);` calls the function the first call returns
+	for p.MatchValue(tokenizer.Punctuation, "(") {
+		node = p.parseCallArguments((&ASTNode{Type: CallExpression, Children: []*ASTNode{node}}).At(p.Peek()))
+	}
 
+	p.ExpectValue(tokenizer.Punctuation, ";")
+
+	return node
+}
+
+// parseCallArguments parses `(a, b, ...)` and appends the arguments to the children of call
+func (p *Parser) parseCallArguments(call *ASTNode) *ASTNode {
 	p.ExpectValue(tokenizer.Punctuation, "(")
 	for !p.MatchValue(tokenizer.Punctuation, ")") {
-		node.Children = append(node.Children, p.ParseExpression())
+		call.Children = append(call.Children, p.ParseExpression())
 
 		if p.MatchValue(tokenizer.Punctuation, ",") {
 			p.Consume()
@@ -441,14 +825,13 @@ func (p *Parser) ParseFunctionCall() *ASTNode {
 		}
 	}
 	p.ExpectValue(tokenizer.Punctuation, ")")
-	p.ExpectValue(tokenizer.Punctuation, ";")
 
-	return node
+	return call
 }
 
 // { type: conditional, children: [condition, block, recursive conditional for else if or else ]}
 func (p *Parser) ParseConditional() *ASTNode {
-	node := &ASTNode{Type: Statement, Name: "if"}
+	node := (&ASTNode{Type: Statement, Name: "if"}).At(p.Peek())
 
 	p.ExpectValue(tokenizer.Keyword, "if")
 	p.ExpectValue(tokenizer.Punctuation, "(")
@@ -470,21 +853,81 @@ func (p *Parser) ParseConditional() *ASTNode {
 	return node
 }
 
+// ParseAssignment parses `name op value;`: { operator, value }. Assigning to a field, `p.x = 1`, adds
+// the field access as a third child, the node keeps the variable's name
 func (p *Parser) ParseAssignment() *ASTNode {
 	name := p.Expect(tokenizer.Identifier)
-	node := &ASTNode{Type: Assignment, Name: name.Value}
+	node := (&ASTNode{Type: Assignment, Name: name.Value}).At(name)
+
+	target := (&ASTNode{Type: Identifier, Name: name.Value}).At(name)
+	for p.MatchValue(tokenizer.Punctuation, ".") {
+		dot := p.Consume()
+		member := p.Expect(tokenizer.Identifier)
+		target = (&ASTNode{Type: MemberAccess, Name: member.Value, Children: []*ASTNode{target}}).At(dot)
+	}
 
 	op := p.Expect(tokenizer.Operator)
-	node.Children = append(node.Children, &ASTNode{Type: Identifier, Name: op.Value})
+	node.Children = append(node.Children, (&ASTNode{Type: Identifier, Name: op.Value}).At(op))
 	node.Children = append(node.Children, p.ParseExpression())
 
+	if target.Type == MemberAccess {
+		node.Children = append(node.Children, target)
+	}
+
 	p.ExpectValue(tokenizer.Punctuation, ";")
 
 	return node
 }
 
+// ParseIndirectAssignment parses a write through a pointer, `*p = 1;`: { operator, value, target }. The
+// name is empty since no variable is assigned. Without an assignment operator, as in `*p++;`, the
+// statement is evaluated for its side effect
+func (p *Parser) ParseIndirectAssignment() *ASTNode {
+	start := p.Peek()
+	target := p.prattParser.ParseTarget()
+
+	isAssignment := false
+	for _, op := range assignmentOperators {
+		isAssignment = isAssignment || p.MatchValue(tokenizer.Operator, op)
+	}
+
+	if !isAssignment {
+		p.ExpectValue(tokenizer.Punctuation, ";")
+		return target
+	}
+
+	node := (&ASTNode{Type: Assignment}).At(start)
+	op := p.Consume()
+	node.Children = append(node.Children, (&ASTNode{Type: Identifier, Name: op.Value}).At(op))
+	node.Children = append(node.Children, p.ParseExpression(), target)
+	p.ExpectValue(tokenizer.Punctuation, ";")
+
+	return node
+}
+
+// ParseDelete parses `delete value;`, which frees a class instance or an array: { value }
+func (p *Parser) ParseDelete() *ASTNode {
+	node := (&ASTNode{Type: Statement, Name: "delete"}).At(p.Peek())
+
+	p.ExpectValue(tokenizer.Keyword, "delete")
+	node.Children = append(node.Children, p.ParseExpression())
+	p.ExpectValue(tokenizer.Punctuation, ";")
+
+	return node
+}
+
+// ParseUnsafeBlock parses `unsafe { ... }`, a block whose statements may do pointer arithmetic: { block }
+func (p *Parser) ParseUnsafeBlock() *ASTNode {
+	node := (&ASTNode{Type: Statement, Name: "unsafe"}).At(p.Peek())
+
+	p.ExpectValue(tokenizer.Keyword, "unsafe")
+	node.Children = append(node.Children, p.ParseBlock())
+
+	return node
+}
+
 func (p *Parser) ParseWhileStatement() *ASTNode {
-	node := &ASTNode{Type: WhileStatement, Name: "while"}
+	node := (&ASTNode{Type: WhileStatement, Name: "while"}).At(p.Peek())
 
 	p.ExpectValue(tokenizer.Keyword, "while")
 	p.ExpectValue(tokenizer.Punctuation, "(")
@@ -497,8 +940,411 @@ func (p *Parser) ParseWhileStatement() *ASTNode {
 }
 
 func (p *Parser) ParseControlFlow() *ASTNode {
-	node := &ASTNode{Type: Statement, Name: p.Peek().Value}
+	node := (&ASTNode{Type: Statement, Name: p.Peek().Value}).At(p.Peek())
 	p.Consume()
 	p.ExpectValue(tokenizer.Punctuation, ";")
 	return node
 }
+
+// ParseStructDeclaration parses `struct Name : Printable { int x, y; float z; int get() { ... } }`, the
+// interfaces and the trailing ';' are optional: { interfaces..., members... }, the interfaces being
+// identifiers
+func (p *Parser) ParseStructDeclaration() *ASTNode {
+	p.ExpectValue(tokenizer.Keyword, "struct")
+	name := p.Expect(tokenizer.Identifier)
+	node := (&ASTNode{Type: StructDeclaration, Name: name.Value}).At(name)
+	node.Children = p.parseSupertypes()
+
+	p.ExpectValue(tokenizer.Punctuation, "{")
+	for !p.MatchValue(tokenizer.Punctuation, "}") {
+		if p.current >= len(p.tokens) {
+			p.Error("Unexpected end of input while parsing struct", name)
+		}
+
+		node.Children = append(node.Children, p.parseMember(false)...)
+	}
+	p.ExpectValue(tokenizer.Punctuation, "}")
+
+	if p.MatchValue(tokenizer.Punctuation, ";") {
+		p.Consume()
+	}
+
+	return node
+}
+
+// ParseClassDeclaration parses `class Name<T> : Base, Printable { int x = 0, y; New(int x) { ... } virtual int get() { ... } }`,
+// the type parameters, the base, the interfaces and the trailing ';' are optional. Fields are declarations that may have a
+// constant initializer, the constructor is named `New` and methods may be marked virtual or override:
+// { supertypes..., members... }, the base and the interfaces being identifiers
+func (p *Parser) ParseClassDeclaration() *ASTNode {
+	p.ExpectValue(tokenizer.Keyword, "class")
+	name := p.Expect(tokenizer.Identifier)
+	node := (&ASTNode{Type: ClassDeclaration, Name: name.Value}).At(name)
+	if p.MatchValue(tokenizer.Operator, "<") {
+		node.TypeParameters = p.parseTypeParameters()
+	}
+
+	node.Children = p.parseSupertypes()
+
+	p.ExpectValue(tokenizer.Punctuation, "{")
+	for !p.MatchValue(tokenizer.Punctuation, "}") {
+		if p.current >= len(p.tokens) {
+			p.Error("Unexpected end of input while parsing class", name)
+			return node
+		}
+
+		if p.MatchValue(tokenizer.Keyword, "New") {
+			node.Children = append(node.Children, p.ParseConstructor())
+			continue
+		}
+
+		node.Children = append(node.Children, p.parseMember(true)...)
+	}
+	p.ExpectValue(tokenizer.Punctuation, "}")
+
+	if p.MatchValue(tokenizer.Punctuation, ";") {
+		p.Consume()
+	}
+
+	return node
+}
+
+// parseMember parses a member of a struct or class body: a method, or the fields of one `type a, b;`
+// declaration. In a class, methods may be marked virtual or override and fields may have modifiers and
+// a constant initializer
+func (p *Parser) parseMember(class bool) []*ASTNode {
+	start := p.Peek()
+
+	var markers []string
+	if class {
+		markers = p.parseKeywords(methodModifiers)
+	}
+
+	// `type name(` starts a method, anything else declares fields
+	if next := p.PeekAt(p.typeLength() + 1); next.Type == tokenizer.Punctuation && next.Value == "(" {
+		method := p.ParseFunctionDeclaration()
+		method.Modifiers = markers
+		return []*ASTNode{method}
+	}
+
+	if len(markers) > 0 {
+		p.Error(fmt.Sprintf("Only methods can be %s", markers[0]), start)
+	}
+
+	var modifiers []string
+	if class {
+		modifiers = p.ParseModifiers()
+	}
+
+	typeStart := p.Peek()
+	fieldType := p.ParseType()
+
+	var fields []*ASTNode
+	for {
+		field := p.Expect(tokenizer.Identifier)
+		child := (&ASTNode{
+			Type:      VariableDeclaration,
+			Name:      field.Value,
+			Children:  []*ASTNode{(&ASTNode{Type: Identifier, Name: fieldType.Name}).At(typeStart)},
+			Modifiers: modifiers,
+		}).At(field)
+
+		if class && p.MatchValue(tokenizer.Operator, "=") {
+			p.Consume()
+			child.Children = append(child.Children, p.ParseExpression())
+		}
+
+		fields = append(fields, child)
+
+		if !p.MatchValue(tokenizer.Punctuation, ",") {
+			break
+		}
+		p.Consume()
+	}
+
+	p.ExpectValue(tokenizer.Punctuation, ";")
+	return fields
+}
+
+// parseTypeParameters parses `<T, U : Printable>` after the name of a generic function or class: the
+// parameters are identifiers, with the interface constraining them as only child
+func (p *Parser) parseTypeParameters() []*ASTNode {
+	p.ExpectValue(tokenizer.Operator, "<")
+
+	var params []*ASTNode
+	for {
+		name := p.Expect(tokenizer.Identifier)
+		param := (&ASTNode{Type: Identifier, Name: name.Value}).At(name)
+
+		if p.MatchValue(tokenizer.Operator, ":") {
+			p.Consume()
+			param.Children = []*ASTNode{p.ParseType()}
+		}
+
+		params = append(params, param)
+		if !p.MatchValue(tokenizer.Punctuation, ",") {
+			break
+		}
+		p.Consume()
+	}
+
+	p.closeAngle()
+	return params
+}
+
+// parseTypeArguments parses `<int, List<char>>` after the name of a generic
+func (p *Parser) parseTypeArguments() []*ASTNode {
+	p.ExpectValue(tokenizer.Operator, "<")
+
+	var args []*ASTNode
+	for {
+		args = append(args, p.ParseType())
+		if !p.MatchValue(tokenizer.Punctuation, ",") {
+			break
+		}
+		p.Consume()
+	}
+
+	p.closeAngle()
+	return args
+}
+
+// closeAngle consumes the `>` ending a list of type parameters or arguments. The tokenizer reads `>>`
+// as a shift, which here closes two nested lists, so it is split in two
+func (p *Parser) closeAngle() {
+	if p.MatchValue(tokenizer.Operator, ">>") {
+		first, second := p.Peek(), p.Peek()
+		first.Value, second.Value = ">", ">"
+		second.Column++
+
+		p.tokens[p.current] = first
+		p.tokens = slices.Insert(p.tokens, p.current+1, second)
+		p.prattParser.tokens = p.tokens
+	}
+
+	p.ExpectValue(tokenizer.Operator, ">")
+}
+
+// typeArgumentsLength counts the tokens of the type arguments starting at tokens[start], `<int, T[]>`,
+// or returns 0 when no such list starts there. Only type names may appear between the brackets, which
+// tells `max<int>(a, b)` apart from comparisons
+func typeArgumentsLength(tokens []tokenizer.Token, start int) int {
+	if start >= len(tokens) || tokens[start].Type != tokenizer.Operator || tokens[start].Value != "<" {
+		return 0
+	}
+
+	depth := 0
+	for i := start; i < len(tokens); i++ {
+		token := tokens[i]
+
+		switch {
+		case token.Type == tokenizer.Operator && token.Value == "<":
+			depth++
+		case token.Type == tokenizer.Operator && token.Value == ">":
+			depth--
+		case token.Type == tokenizer.Operator && token.Value == ">>":
+			depth -= 2
+		case token.Type == tokenizer.Identifier, token.Type == tokenizer.Operator && token.Value == "*":
+		case token.Type == tokenizer.Keyword && slices.Contains([]string{"int", "float", "char", "bool", "void"}, token.Value):
+		case token.Type == tokenizer.Punctuation && slices.Contains([]string{".", ",", "[", "]", "(", ")"}, token.Value):
+		default:
+			return 0
+		}
+
+		// A `>>` may close this list and the one around it
+		if depth <= 0 {
+			return i - start + 1
+		}
+	}
+
+	return 0
+}
+
+// parseSupertypes parses the `: Base, Printable` of a class or struct, a list of type names
+func (p *Parser) parseSupertypes() []*ASTNode {
+	if !p.MatchValue(tokenizer.Operator, ":") {
+		return nil
+	}
+	p.Consume()
+
+	supertypes := []*ASTNode{p.ParseType()}
+	for p.MatchValue(tokenizer.Punctuation, ",") {
+		p.Consume()
+		supertypes = append(supertypes, p.ParseType())
+	}
+
+	return supertypes
+}
+
+// ParseInterfaceDeclaration parses `interface Name { int get(); void set(int x); }`, the trailing ';' is
+// optional: { methods... }, every method being a prototype
+func (p *Parser) ParseInterfaceDeclaration() *ASTNode {
+	p.ExpectValue(tokenizer.Keyword, "interface")
+	name := p.Expect(tokenizer.Identifier)
+	node := (&ASTNode{Type: InterfaceDeclaration, Name: name.Value}).At(name)
+
+	p.ExpectValue(tokenizer.Punctuation, "{")
+	for !p.MatchValue(tokenizer.Punctuation, "}") {
+		if p.current >= len(p.tokens) {
+			p.Error("Unexpected end of input while parsing interface", name)
+			return node
+		}
+
+		method := p.ParseFunctionDeclaration()
+		if !method.IsPrototype() {
+			p.Error(fmt.Sprintf("Method '%s' of interface '%s' cannot have a body", method.Name, name.Value), name)
+		}
+
+		node.Children = append(node.Children, method)
+	}
+	p.ExpectValue(tokenizer.Punctuation, "}")
+
+	if p.MatchValue(tokenizer.Punctuation, ";") {
+		p.Consume()
+	}
+
+	return node
+}
+
+// ParseConstructor parses `New(params) { ... }` in a class: { parameters, body }
+func (p *Parser) ParseConstructor() *ASTNode {
+	keyword := p.Expect(tokenizer.Keyword)
+	node := (&ASTNode{Type: ConstructorDeclaration, Name: keyword.Value}).At(keyword)
+
+	p.ExpectValue(tokenizer.Punctuation, "(")
+	node.Children = append(node.Children, p.ParseParameters())
+	p.ExpectValue(tokenizer.Punctuation, ")")
+	node.Children = append(node.Children, p.ParseBlock())
+
+	return node
+}
+
+// ParseEnumDeclaration parses `enum Name : char { A, B = 10, C }`. The backing type defaults to int and
+// variants without a value follow the one before them: { backing type, variants... }
+func (p *Parser) ParseEnumDeclaration() *ASTNode {
+	p.ExpectValue(tokenizer.Keyword, "enum")
+	name := p.Expect(tokenizer.Identifier)
+	node := (&ASTNode{Type: EnumDeclaration, Name: name.Value}).At(name)
+
+	backing := (&ASTNode{Type: Identifier, Name: "int"}).At(name)
+	if p.MatchValue(tokenizer.Operator, ":") {
+		p.Consume()
+		backing = p.ParseType()
+	}
+	node.Children = append(node.Children, backing)
+
+	p.ExpectValue(tokenizer.Punctuation, "{")
+	for !p.MatchValue(tokenizer.Punctuation, "}") {
+		if p.current >= len(p.tokens) {
+			p.Error("Unexpected end of input while parsing enum", name)
+			return node
+		}
+
+		variant := p.Expect(tokenizer.Identifier)
+		child := (&ASTNode{Type: Identifier, Name: variant.Value}).At(variant)
+
+		if p.MatchValue(tokenizer.Operator, "=") {
+			p.Consume()
+			child.Children = append(child.Children, p.ParseExpression())
+		}
+
+		node.Children = append(node.Children, child)
+
+		if p.MatchValue(tokenizer.Punctuation, ",") {
+			p.Consume()
+		} else if !p.MatchValue(tokenizer.Punctuation, "}") {
+			p.ExpectedError("',' or '}'", p.Peek())
+		}
+	}
+	p.ExpectValue(tokenizer.Punctuation, "}")
+
+	if p.MatchValue(tokenizer.Punctuation, ";") {
+		p.Consume()
+	}
+
+	return node
+}
+
+// ParseUnionDeclaration parses `union Name { A(int x, float y), B }`: { variants... }. Every variant is an
+// identifier whose children are the declarations of its fields
+func (p *Parser) ParseUnionDeclaration() *ASTNode {
+	p.ExpectValue(tokenizer.Keyword, "union")
+	name := p.Expect(tokenizer.Identifier)
+	node := (&ASTNode{Type: UnionDeclaration, Name: name.Value}).At(name)
+
+	p.ExpectValue(tokenizer.Punctuation, "{")
+	for !p.MatchValue(tokenizer.Punctuation, "}") {
+		if p.current >= len(p.tokens) {
+			p.Error("Unexpected end of input while parsing union", name)
+			return node
+		}
+
+		variant := p.Expect(tokenizer.Identifier)
+		child := (&ASTNode{Type: Identifier, Name: variant.Value}).At(variant)
+
+		if p.MatchValue(tokenizer.Punctuation, "(") {
+			p.Consume()
+			child.Children = p.ParseParameters().Children
+			p.ExpectValue(tokenizer.Punctuation, ")")
+		}
+
+		node.Children = append(node.Children, child)
+
+		if p.MatchValue(tokenizer.Punctuation, ",") {
+			p.Consume()
+		} else if !p.MatchValue(tokenizer.Punctuation, "}") {
+			p.ExpectedError("',' or '}'", p.Peek())
+		}
+	}
+	p.ExpectValue(tokenizer.Punctuation, "}")
+
+	if p.MatchValue(tokenizer.Punctuation, ";") {
+		p.Consume()
+	}
+
+	return node
+}
+
+// ParseSwitchStatement parses `switch (value) { case A, B: { ... } default: { ... } }`. Cases do not
+// fall through: { value, cases... }, where every case is { body, labels... } and the default has no labels
+func (p *Parser) ParseSwitchStatement() *ASTNode {
+	node := (&ASTNode{Type: SwitchStatement, Name: "switch"}).At(p.Peek())
+
+	p.ExpectValue(tokenizer.Keyword, "switch")
+	p.ExpectValue(tokenizer.Punctuation, "(")
+	node.Children = append(node.Children, p.ParseExpression())
+	p.ExpectValue(tokenizer.Punctuation, ")")
+
+	p.ExpectValue(tokenizer.Punctuation, "{")
+	for !p.MatchValue(tokenizer.Punctuation, "}") {
+		if p.current >= len(p.tokens) {
+			p.Error("Unexpected end of input while parsing switch")
+			return node
+		}
+
+		keyword := p.Peek()
+		if keyword.Type != tokenizer.Keyword || (keyword.Value != "case" && keyword.Value != "default") {
+			p.ExpectedError("'case' or 'default'", keyword)
+		}
+		p.Consume()
+
+		c := (&ASTNode{Type: Case, Name: keyword.Value}).At(keyword)
+		var labels []*ASTNode
+
+		for keyword.Value == "case" {
+			labels = append(labels, p.ParseExpression())
+
+			if !p.MatchValue(tokenizer.Punctuation, ",") {
+				break
+			}
+			p.Consume()
+		}
+
+		p.ExpectValue(tokenizer.Operator, ":")
+		c.Children = append(append(c.Children, p.ParseBlock()), labels...)
+		node.Children = append(node.Children, c)
+	}
+	p.ExpectValue(tokenizer.Punctuation, "}")
+
+	return node
+}
@@ -0,0 +1,192 @@
+package ast
+
+import (
+	"maps"
+
+	"velox.eparker.dev/src/tokenizer"
+)
+
+// Statement orders a block can be written in. A file that ends with `oops` has every block reversed
+const (
+	NormalOrder   = "normal"
+	ReversedOrder = "reversed"
+
+	// mixedOrder is the guess for a block whose declarations disagree on its order
+	mixedOrder = "mixed"
+)
+
+// orderUnits splits the statements of a block into the pieces that are reordered. A function-like macro
+// that expands to several statements is written once, so its statements form a single piece. origins
+// holds the outermost expansion each statement's tokens came from, or nil
+func orderUnits(statements []*ASTNode, origins []*tokenizer.Expansion) [][]*ASTNode {
+	var units [][]*ASTNode
+
+	for i, statement := range statements {
+		if i > 0 && origins[i] != nil && origins[i] == origins[i-1] {
+			units[len(units)-1] = append(units[len(units)-1], statement)
+			continue
+		}
+
+		units = append(units, []*ASTNode{statement})
+	}
+
+	return units
+}
+
+// origin returns the outermost expansion that any of tokens came from
+func origin(tokens []tokenizer.Token) *tokenizer.Expansion {
+	for _, token := range tokens {
+		expansion := token.Expansion
+		for expansion != nil && expansion.Parent != nil {
+			expansion = expansion.Parent
+		}
+
+		if expansion != nil {
+			return expansion
+		}
+	}
+
+	return nil
+}
+
+// reverseUnits puts the statements of a reversed block back in running order
+func reverseUnits(units [][]*ASTNode) []*ASTNode {
+	var reversed []*ASTNode
+
+	for i := len(units) - 1; i >= 0; i-- {
+		reversed = append(reversed, units[i]...)
+	}
+
+	return reversed
+}
+
+// blockOrder is a parsed block whose written order is still to be checked
+type blockOrder struct {
+	units [][]*ASTNode
+	open  tokenizer.Token
+}
+
+// checkOrders checks the written order of every block below node against the file the block is
+// written in. bound holds the names declared in the scopes enclosing node: a declaration shadowing one
+// of them says nothing about the order, since the uses around it may read the outer variable. A block
+// written in both orders is an error in any file, as is a block of a file ending with `oops` written
+// top to bottom. Reversed blocks of normal files are left to the analyzer, which reports the variables
+// they use before declaring
+func (p *Parser) checkOrders(node *ASTNode, bound map[string]bool) {
+	if check, ok := p.orders[node]; ok {
+		switch order := writtenOrder(check.units, bound); {
+		case order == mixedOrder:
+			p.Error("This block declares some variables before their uses and others after, so it is written partly top to bottom and partly in reverse", check.open)
+		case order == NormalOrder && p.reversed[check.open.File]:
+			p.Error("This block is written top to bottom, but the file ends with 'oops', so every block must be written in reverse", check.open)
+		}
+	}
+
+	// The declarations of node and the parameters beside a body are bound anywhere inside node
+	var names []string
+	for _, child := range node.Children {
+		declarations := []*ASTNode{child}
+		if child.Type == Parameters {
+			declarations = child.Children
+		}
+
+		for _, declaration := range declarations {
+			if declaration.Type == VariableDeclaration && declaration.Name != "" {
+				names = append(names, declaration.Name)
+			}
+		}
+	}
+
+	inner := bound
+	if len(names) > 0 {
+		inner = make(map[string]bool, len(bound)+len(names))
+		maps.Copy(inner, bound)
+		for _, name := range names {
+			inner[name] = true
+		}
+	}
+
+	for _, child := range node.Children {
+		p.checkOrders(child, inner)
+	}
+}
+
+// writtenOrder guesses which order the statements of a block were written in from the two things
+// that cannot move: a variable is declared before it is used, and a return, break or continue ends a
+// block. Declarations of a name in bound are skipped. Since code may follow a return, the block's ends
+// only decide when its declarations give no hint. It returns "" when the block gives no hint and
+// mixedOrder when its declarations disagree
+func writtenOrder(units [][]*ASTNode, bound map[string]bool) string {
+	order := ""
+
+	for i, unit := range units {
+		for _, statement := range unit {
+			if statement.Type != VariableDeclaration || bound[statement.Name] {
+				continue
+			}
+
+			for j, other := range units {
+				if i == j || !referencesAny(other, statement.Name) {
+					continue
+				}
+
+				hint := NormalOrder
+				if j < i {
+					hint = ReversedOrder
+				}
+
+				if order != "" && order != hint {
+					return mixedOrder
+				}
+				order = hint
+			}
+		}
+	}
+
+	if order != "" || len(units) < 2 {
+		return order
+	}
+
+	switch first, last := isTerminator(units[0][0]), isTerminator(units[len(units)-1][len(units[len(units)-1])-1]); {
+	case last && !first:
+		return NormalOrder
+	case first && !last:
+		return ReversedOrder
+	}
+
+	return ""
+}
+
+func isTerminator(node *ASTNode) bool {
+	return node.Type == ReturnStatement || (node.Type == Statement && (node.Name == "break" || node.Name == "continue"))
+}
+
+// references reports whether node reads or writes the variable name anywhere inside it
+func references(node *ASTNode, name string) bool {
+	if (node.Type == Identifier || node.Type == Assignment) && node.Name == name {
+		return true
+	}
+
+	// A nested declaration of the same name starts a different variable
+	if node.Type == VariableDeclaration && node.Name == name {
+		return false
+	}
+
+	for _, child := range node.Children {
+		if references(child, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func referencesAny(nodes []*ASTNode, name string) bool {
+	for _, node := range nodes {
+		if references(node, name) {
+			return true
+		}
+	}
+
+	return false
+}
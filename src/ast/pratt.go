@@ -28,20 +28,227 @@ func NewPrattParser(tokens []tokenizer.Token) *PrattParser {
 }
 
 func (p *PrattParser) registerParseFns() {
-	p.prefixParseFns[tokenizer.Number] = p.parseNumberLiteral
+	p.prefixParseFns[tokenizer.Number] = p.parseLiteral
+	p.prefixParseFns[tokenizer.String] = p.parseLiteral
 	p.prefixParseFns[tokenizer.Identifier] = p.parseIdentifier
 	p.prefixParseFns[tokenizer.Punctuation] = p.parseGroupedExpression
+	p.prefixParseFns[tokenizer.Operator] = p.parsePrefixExpression
+	p.prefixParseFns[tokenizer.Keyword] = p.parseKeywordExpression
 
 	p.infixParseFns[tokenizer.Operator] = p.parseInfixExpression
+	p.infixParseFns[tokenizer.Keyword] = p.parseAsExpression
+	p.infixParseFns[tokenizer.Punctuation] = p.parsePostfixPunctuation
+	p.infixParseFns[tokenizer.Macro] = p.parseMacroExpansion
+}
+
+// Assignments bind loosest
+const assignmentPrecedence = 1
+
+// castPrecedence binds casts tighter than any binary operator, so `(float)a / b` only casts `a`
+const castPrecedence = 9
+
+// Prefix and postfix operators bind tighter than everything else
+const unaryPrecedence = 10
+
+// Member access binds tightest, so `-math.PI` negates the member
+const memberPrecedence = 11
+
+func isCastType(token tokenizer.Token) bool {
+	if token.Type != tokenizer.Keyword {
+		return false
+	}
+
+	switch token.Value {
+	case "int", "float", "char", "bool":
+		return true
+	}
+
+	return false
 }
 
 func (p *PrattParser) parseGroupedExpression() *ASTNode {
-	p.consumeToken() // consume '('
+	if p.peekToken().Value == "{" {
+		return p.parseStructLiteral((&ASTNode{Type: StructLiteral}).At(p.peekToken()))
+	}
+
+	if p.peekToken().Value == "[" || p.isLambda() {
+		return p.parseLambda()
+	}
+
+	open := p.consumeToken() // consume '('
+
+	// `(type)expr` is a cast, anything else is a parenthesized expression
+	if isCastType(p.peekToken()) || p.isNamedCast() {
+		targetType := p.parseCastType()
+		p.expectToken(tokenizer.Punctuation, ")")
+		return (&ASTNode{
+			Type:     CastExpression,
+			Name:     targetType,
+			Children: []*ASTNode{p.parseExpression(castPrecedence)},
+		}).At(open)
+	}
+
 	exp := p.parseExpression(0)
 	p.expectToken(tokenizer.Punctuation, ")") // consume ')'
 	return exp
 }
 
+// isNamedCast reports whether `(Name)` or `(module.Name)` at the current token casts to an enum, or with
+// a `*` to a pointer. A parenthesized name is only a cast when an operand follows, so `(a) - b` stays a
+// subtraction, while `(Name*)` is never an expression. Whether `(Name)(x)` casts or calls a value
+// depends on what Name resolves to, so it is parsed as a call and left to the analyzer
+func (p *PrattParser) isNamedCast() bool {
+	length := 1
+	if p.peekAt(1).Value == "." && p.peekAt(2).Type == tokenizer.Identifier {
+		length = 3
+	}
+
+	pointer := false
+	for p.peekAt(length).Type == tokenizer.Operator && p.peekAt(length).Value == "*" {
+		length++
+		pointer = true
+	}
+
+	if p.peekToken().Type != tokenizer.Identifier || p.peekAt(length).Value != ")" || p.peekAt(length).Type != tokenizer.Punctuation {
+		return false
+	}
+
+	next := p.peekAt(length + 1)
+	return pointer || next.Type == tokenizer.Number || next.Type == tokenizer.Identifier
+}
+
+// parseCastType consumes the type of a cast, a builtin type or an enum name, possibly as a pointer. The
+// class of `New` may be an instance of a generic, `List<int>`
+func (p *PrattParser) parseCastType() string {
+	name := p.consumeToken()
+	typeName := name.Value
+
+	switch {
+	case name.Type == tokenizer.Keyword:
+	case name.Type != tokenizer.Identifier:
+		p.parser.ExpectedError("type", name)
+	case p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == ".":
+		p.consumeToken()
+
+		member := p.consumeToken()
+		if member.Type != tokenizer.Identifier {
+			p.parser.ExpectedError("type name after '.'", member)
+		}
+
+		typeName += "." + member.Value
+	}
+
+	if name.Type == tokenizer.Identifier && typeArgumentsLength(p.tokens, p.current) > 0 {
+		var args []string
+		for _, arg := range p.typeArguments() {
+			args = append(args, arg.Name)
+		}
+
+		typeName = GenericType(typeName, args)
+	}
+
+	// A `*` followed by an operand multiplies, as in `x as int * 2`
+	for p.peekToken().Type == tokenizer.Operator && p.peekToken().Value == "*" && !startsOperand(p.peekAt(1)) {
+		p.consumeToken()
+		typeName = PointerType(typeName)
+	}
+
+	return typeName
+}
+
+func startsOperand(token tokenizer.Token) bool {
+	switch token.Type {
+	case tokenizer.Number, tokenizer.String, tokenizer.Identifier:
+		return true
+	case tokenizer.Punctuation:
+		return token.Value == "("
+	}
+
+	return false
+}
+
+// isLambda reports whether the parentheses at the current token are followed by `=>`
+func (p *PrattParser) isLambda() bool {
+	depth := 0
+
+	for i := p.current; i < len(p.tokens); i++ {
+		switch p.tokens[i].Value {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+
+		if depth == 0 {
+			next := i + 1
+			return next < len(p.tokens) && p.tokens[next].Type == tokenizer.Operator && p.tokens[next].Value == "=>"
+		}
+	}
+
+	return false
+}
+
+// parseLambda parses `[captures](params) => body`. The capture list is optional and names the variables
+// captured by reference with `&`, the body is an expression or a block
+func (p *PrattParser) parseLambda() *ASTNode {
+	start := p.peekToken()
+	captures := (&ASTNode{Type: CaptureList, Name: "CaptureList"}).At(start)
+
+	if start.Value == "[" {
+		p.consumeToken()
+
+		for p.peekToken().Value != "]" {
+			byReference := false
+			if p.peekToken().Type == tokenizer.Operator && p.peekToken().Value == "&" {
+				p.consumeToken()
+				byReference = true
+			}
+
+			name := p.consumeToken()
+			if name.Type != tokenizer.Identifier {
+				p.parser.ExpectedError("captured variable name", name)
+			}
+
+			capture := (&ASTNode{Type: Identifier, Name: name.Value}).At(name)
+			if byReference {
+				capture.Modifiers = []string{"ref"}
+			}
+			captures.Children = append(captures.Children, capture)
+
+			if p.peekToken().Value == "," {
+				p.consumeToken()
+			} else if p.peekToken().Value != "]" {
+				p.parser.ExpectedError("',' or ']'", p.peekToken())
+			}
+		}
+
+		p.expectToken(tokenizer.Punctuation, "]")
+	}
+
+	// Parameters and block bodies belong to the statement parser, which shares the token position
+	p.expectToken(tokenizer.Punctuation, "(")
+	p.parser.current = p.current
+	params := p.parser.ParseParameters()
+	p.current = p.parser.current
+	p.expectToken(tokenizer.Punctuation, ")")
+	p.expectToken(tokenizer.Operator, "=>")
+
+	var body *ASTNode
+	if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "{" {
+		p.parser.current = p.current
+		body = p.parser.ParseBlock()
+		p.current = p.parser.current
+	} else {
+		body = p.parseExpression(0)
+	}
+
+	return (&ASTNode{
+		Type:     Lambda,
+		Name:     "lambda",
+		Children: []*ASTNode{params, body, captures},
+	}).At(start)
+}
+
 func (p *PrattParser) peekToken() tokenizer.Token {
 	if p.current >= len(p.tokens) {
 		return tokenizer.Token{Type: tokenizer.Invalid, Value: ""}
@@ -49,6 +256,13 @@ func (p *PrattParser) peekToken() tokenizer.Token {
 	return p.tokens[p.current]
 }
 
+func (p *PrattParser) peekAt(offset int) tokenizer.Token {
+	if p.current+offset >= len(p.tokens) {
+		return tokenizer.Token{Type: tokenizer.Invalid, Value: ""}
+	}
+	return p.tokens[p.current+offset]
+}
+
 func (p *PrattParser) consumeToken() tokenizer.Token {
 	token := p.peekToken()
 	p.current++
@@ -73,6 +287,8 @@ func getPrecedence(token tokenizer.Token) int {
 	switch token.Type {
 	case tokenizer.Operator:
 		switch token.Value {
+		case "++", "--":
+			return unaryPrecedence
 		case "**":
 			return 8
 		case "*", "/", "%":
@@ -88,8 +304,18 @@ func getPrecedence(token tokenizer.Token) int {
 		case "||":
 			return 2
 		case "=", "+=", "-=", "*=", "/=":
-			return 1
+			return assignmentPrecedence
 		}
+	case tokenizer.Keyword:
+		if token.Value == "as" {
+			return castPrecedence
+		}
+	case tokenizer.Punctuation:
+		if token.Value == "." || token.Value == "[" || token.Value == "(" {
+			return memberPrecedence
+		}
+	case tokenizer.Macro:
+		return memberPrecedence
 	}
 	return 0
 }
@@ -101,6 +327,14 @@ func (p *PrattParser) Parse() *ASTNode {
 	return expr
 }
 
+// ParseTarget parses the target of an assignment, stopping before the assignment operator
+func (p *PrattParser) ParseTarget() *ASTNode {
+	p.current = p.parser.current
+	expr := p.parseExpression(assignmentPrecedence)
+	p.parser.current = p.current
+	return expr
+}
+
 func (p *PrattParser) parseExpression(precedence int) *ASTNode {
 	prefix := p.prefixParseFns[p.peekToken().Type]
 	if prefix == nil {
@@ -121,9 +355,108 @@ func (p *PrattParser) parseExpression(precedence int) *ASTNode {
 	return leftExp
 }
 
-func (p *PrattParser) parseNumberLiteral() *ASTNode {
+func (p *PrattParser) parseLiteral() *ASTNode {
+	token := p.consumeToken()
+	return (&ASTNode{Type: Literal, Name: token.Value}).At(token)
+}
+
+// parseKeywordExpression parses the expressions that start with a keyword
+func (p *PrattParser) parseKeywordExpression() *ASTNode {
+	switch p.peekToken().Value {
+	case "match":
+		return p.parseMatch()
+	case "New":
+		return p.parseNew()
+	case "super":
+		// `super.method(args)` calls the base class's method on `this`, like a call of a dotted name
+		token := p.consumeToken()
+		return (&ASTNode{Type: Identifier, Name: token.Value}).At(token)
+	}
+
+	return p.parseKeywordLiteral()
+}
+
+// parseMatch parses `match (value) { A, B => x, default => y }`: { value, arms... }. Every arm is
+// { result, labels... } like the cases of a switch, and the default arm has no labels
+func (p *PrattParser) parseMatch() *ASTNode {
+	keyword := p.consumeToken()
+	node := (&ASTNode{Type: MatchExpression, Name: "match"}).At(keyword)
+
+	p.expectToken(tokenizer.Punctuation, "(")
+	node.Children = append(node.Children, p.parseExpression(0))
+	p.expectToken(tokenizer.Punctuation, ")")
+
+	p.expectToken(tokenizer.Punctuation, "{")
+	for p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != "}" {
+		if p.peekToken().Type == tokenizer.Invalid {
+			p.parser.Error("Unexpected end of input while parsing match", keyword)
+			return node
+		}
+
+		arm := (&ASTNode{Type: Case, Name: "case"}).At(p.peekToken())
+		var labels []*ASTNode
+
+		if p.peekToken().Type == tokenizer.Keyword && p.peekToken().Value == "default" {
+			p.consumeToken()
+			arm.Name = "default"
+		} else {
+			for {
+				labels = append(labels, p.parseExpression(0))
+
+				if p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != "," {
+					break
+				}
+				p.consumeToken()
+			}
+		}
+
+		p.expectToken(tokenizer.Operator, "=>")
+		arm.Children = append([]*ASTNode{p.parseExpression(0)}, labels...)
+		node.Children = append(node.Children, arm)
+
+		if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "," {
+			p.consumeToken()
+		} else if p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != "}" {
+			p.parser.ExpectedError("',' or '}'", p.peekToken())
+		}
+	}
+
+	p.expectToken(tokenizer.Punctuation, "}")
+	return node
+}
+
+// parseNew parses `New Name(args)`, which allocates a class instance and runs its constructor, or
+// `New int[length]`, which allocates an array: { args... } or { length }, named by the allocated type
+func (p *PrattParser) parseNew() *ASTNode {
+	keyword := p.consumeToken()
+	node := (&ASTNode{Type: NewExpression, Name: p.parseCastType()}).At(keyword)
+
+	if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "[" {
+		p.consumeToken()
+		node.Name = ArrayType(node.Name)
+		node.Children = append(node.Children, p.parseExpression(0))
+		p.expectToken(tokenizer.Punctuation, "]")
+		return node
+	}
+
+	if p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != "(" {
+		p.parser.ExpectedError("'(' or '['", p.peekToken())
+	}
+
+	return p.parseArguments(node)
+}
+
+// parseKeywordLiteral parses `true`, `false` and `maybe`
+func (p *PrattParser) parseKeywordLiteral() *ASTNode {
 	token := p.consumeToken()
-	return &ASTNode{Type: Literal, Name: token.Value}
+
+	switch token.Value {
+	case "true", "false", "maybe", "null":
+	default:
+		p.parser.UnexpectedError(token)
+	}
+
+	return (&ASTNode{Type: Literal, Name: token.Value}).At(token)
 }
 
 func (p *PrattParser) parseIdentifier() *ASTNode {
@@ -131,28 +464,221 @@ func (p *PrattParser) parseIdentifier() *ASTNode {
 
 	// Check if the identifier is a function call
 	if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "(" {
-		p.consumeToken() // consume '('
-		node := &ASTNode{Type: FunctionCall, Name: token.Value}
-		for p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != ")" {
-			node.Children = append(node.Children, p.parseExpression(0))
-			if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "," {
-				p.consumeToken() // consume ','
+		return p.parseArguments((&ASTNode{Type: FunctionCall, Name: token.Value}).At(token))
+	}
+
+	// `max<int>(a, b)` gives the type arguments of a generic function
+	if p.startsGenericCall() {
+		call := (&ASTNode{Type: FunctionCall, Name: token.Value, TypeArguments: p.typeArguments()}).At(token)
+		return p.parseArguments(call)
+	}
+
+	if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "{" {
+		return p.parseStructLiteral((&ASTNode{Type: StructLiteral, Name: token.Value}).At(token))
+	}
+
+	return (&ASTNode{Type: Identifier, Name: token.Value}).At(token)
+}
+
+// startsGenericCall reports whether type arguments and then a parenthesis follow, which tells a call of
+// a generic function apart from a comparison
+func (p *PrattParser) startsGenericCall() bool {
+	length := typeArgumentsLength(p.tokens, p.current)
+	next := p.peekAt(length)
+	return length > 0 && next.Type == tokenizer.Punctuation && next.Value == "("
+}
+
+// typeArguments parses `<int, T[]>`, with the statement parser, which shares the token position
+func (p *PrattParser) typeArguments() []*ASTNode {
+	p.parser.current = p.current
+	args := p.parser.parseTypeArguments()
+	p.current = p.parser.current
+
+	return args
+}
+
+// parseArguments parses `(a, b, ...)` into the children of call
+func (p *PrattParser) parseArguments(call *ASTNode) *ASTNode {
+	p.consumeToken() // consume '('
+	for p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != ")" {
+		call.Children = append(call.Children, p.parseExpression(0))
+		if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "," {
+			p.consumeToken() // consume ','
+		}
+	}
+	p.expectToken(tokenizer.Punctuation, ")") // consume ')'
+	return call
+}
+
+// parsePostfixPunctuation parses the punctuation that continues an operand, `.`, `[` and `(`
+func (p *PrattParser) parsePostfixPunctuation(left *ASTNode) *ASTNode {
+	switch p.peekToken().Value {
+	case "[":
+		return p.parseIndex(left)
+	case "(":
+		// Calling any other value, `pick(1)(3, 3)` or `handlers[i](event)`: { callee, args... }
+		return p.parseArguments((&ASTNode{Type: CallExpression, Children: []*ASTNode{left}}).At(p.peekToken()))
+	}
+
+	return p.parseMemberAccess(left)
+}
+
+// parseIndex parses `left[index]`: { array, index }
+func (p *PrattParser) parseIndex(left *ASTNode) *ASTNode {
+	open := p.consumeToken() // consume '['
+	node := (&ASTNode{Type: ArrayAccess, Name: "[]", Children: []*ASTNode{left, p.parseExpression(0)}}).At(open)
+	p.expectToken(tokenizer.Punctuation, "]")
+
+	return node
+}
+
+// parseMemberAccess parses `left.name`. Calling a member of a dotted name, as in `math.sqrt(x)` or
+// `geo.Shape.Circle(r)`, is a call to the qualified name, which semantic analysis resolves, possibly to a
+// method of a variable
+func (p *PrattParser) parseMemberAccess(left *ASTNode) *ASTNode {
+	dot := p.consumeToken() // consume '.'
+
+	// `super.New(args)` runs the constructor of the base class
+	member := p.consumeToken()
+	if member.Type != tokenizer.Identifier && (left.Name != "super" || member.Value != "New") {
+		p.parser.ExpectedError("member name after '.'", member)
+	}
+
+	if name, ok := dottedName(left); ok && p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "(" {
+		return p.parseArguments((&ASTNode{Type: FunctionCall, Name: name + "." + member.Value}).At(member))
+	}
+
+	if name, ok := dottedName(left); ok && p.startsGenericCall() {
+		call := (&ASTNode{Type: FunctionCall, Name: name + "." + member.Value, TypeArguments: p.typeArguments()}).At(member)
+		return p.parseArguments(call)
+	}
+
+	// Any other value is the receiver of a method: { receiver, args... }
+	if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "(" {
+		return p.parseArguments((&ASTNode{Type: MethodCall, Name: member.Value, Children: []*ASTNode{left}}).At(member))
+	}
+
+	if left.Type == Identifier && p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "{" {
+		return p.parseStructLiteral((&ASTNode{Type: StructLiteral, Name: left.Name + "." + member.Value}).At(member))
+	}
+
+	return (&ASTNode{
+		Type:     MemberAccess,
+		Name:     member.Value,
+		Children: []*ASTNode{left},
+	}).At(dot)
+}
+
+// dottedName spells `a.b.c` when node is nothing but names
+func dottedName(node *ASTNode) (string, bool) {
+	switch node.Type {
+	case Identifier:
+		return node.Name, true
+	case MemberAccess:
+		if base, ok := dottedName(node.Children[0]); ok {
+			return base + "." + node.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// parseStructLiteral parses `{1, 2}` or `{.x = 1, .y = 2}` into the children of literal. Named values
+// become assignments to the field. A literal without a type name takes the type its value is stored as
+func (p *PrattParser) parseStructLiteral(literal *ASTNode) *ASTNode {
+	p.consumeToken() // consume '{'
+
+	for p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != "}" {
+		if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "." {
+			p.consumeToken()
+
+			field := p.consumeToken()
+			if field.Type != tokenizer.Identifier {
+				p.parser.ExpectedError("field name after '.'", field)
 			}
+
+			p.expectToken(tokenizer.Operator, "=")
+			literal.Children = append(literal.Children, (&ASTNode{
+				Type:     Assignment,
+				Name:     field.Value,
+				Children: []*ASTNode{(&ASTNode{Type: Identifier, Name: "="}).At(field), p.parseExpression(0)},
+			}).At(field))
+		} else {
+			literal.Children = append(literal.Children, p.parseExpression(0))
 		}
-		p.expectToken(tokenizer.Punctuation, ")") // consume ')'
-		return node
+
+		if p.peekToken().Type == tokenizer.Punctuation && p.peekToken().Value == "," {
+			p.consumeToken()
+		} else if p.peekToken().Type != tokenizer.Punctuation || p.peekToken().Value != "}" {
+			p.parser.ExpectedError("',' or '}'", p.peekToken())
+		}
+	}
+
+	p.expectToken(tokenizer.Punctuation, "}")
+	return literal
+}
+
+func (p *PrattParser) parsePrefixExpression() *ASTNode {
+	token := p.consumeToken()
+
+	switch token.Value {
+	case "++", "--", "-", "!", "&", "*":
+	default:
+		p.parser.UnexpectedError(token)
 	}
 
-	return &ASTNode{Type: Identifier, Name: token.Value}
+	return (&ASTNode{
+		Type:     UnaryExpression,
+		Name:     token.Value,
+		Children: []*ASTNode{p.parseExpression(unaryPrecedence)},
+	}).At(token)
 }
 
 func (p *PrattParser) parseInfixExpression(left *ASTNode) *ASTNode {
 	token := p.consumeToken()
+
+	if token.Value == "++" || token.Value == "--" {
+		return (&ASTNode{
+			Type:     PostfixExpression,
+			Name:     token.Value,
+			Children: []*ASTNode{left},
+		}).At(token)
+	}
 	precedence := getPrecedence(token)
 	right := p.parseExpression(precedence)
-	return &ASTNode{
+	return (&ASTNode{
 		Type:     BinaryExpression,
 		Name:     token.Value,
 		Children: []*ASTNode{left, right},
+	}).At(token)
+}
+
+// parseMacroExpansion parses a postfix macro such as `x::prev`. Which macros exist is up to semantic analysis
+func (p *PrattParser) parseMacroExpansion(left *ASTNode) *ASTNode {
+	p.consumeToken() // consume '::'
+
+	name := p.consumeToken()
+	if name.Type != tokenizer.Identifier {
+		p.parser.ExpectedError("macro name after '::'", name)
 	}
+
+	return (&ASTNode{
+		Type:     MacroExpansion,
+		Name:     name.Value,
+		Children: []*ASTNode{left},
+	}).At(name)
+}
+
+func (p *PrattParser) parseAsExpression(left *ASTNode) *ASTNode {
+	keyword := p.consumeToken() // consume 'as'
+
+	if !isCastType(p.peekToken()) && p.peekToken().Type != tokenizer.Identifier {
+		p.parser.ExpectedError("type after 'as'", p.peekToken())
+	}
+
+	return (&ASTNode{
+		Type:     CastExpression,
+		Name:     p.parseCastType(),
+		Children: []*ASTNode{left},
+	}).At(keyword)
 }
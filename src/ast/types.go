@@ -0,0 +1,108 @@
+package ast
+
+import "strings"
+
+// FunctionType spells the type of a function value, `int(int,char)`. Type names are compared as
+// strings, so this is the only spelling
+func FunctionType(ret string, params []string) string {
+	return ret + "(" + strings.Join(params, ",") + ")"
+}
+
+// PointerType spells the type of a pointer to values of type name, `int*`
+func PointerType(name string) string {
+	return name + "*"
+}
+
+// Pointee returns the type a pointer type points to
+func Pointee(name string) (string, bool) {
+	return strings.CutSuffix(name, "*")
+}
+
+// ArrayType spells the type of an array of values of type name, `int[]`
+func ArrayType(name string) string {
+	return name + "[]"
+}
+
+// Element returns the type of the elements of an array type
+func Element(name string) (string, bool) {
+	return strings.CutSuffix(name, "[]")
+}
+
+// GenericType spells the instantiation of the generic name with the type arguments args, `List<int>`
+func GenericType(name string, args []string) string {
+	return name + "<" + strings.Join(args, ",") + ">"
+}
+
+// ParseGenericType splits an instantiation of a generic into its name and type arguments
+func ParseGenericType(name string) (generic string, args []string, ok bool) {
+	if !strings.HasSuffix(name, ">") {
+		return "", nil, false
+	}
+
+	depth := 0
+	for i := len(name) - 1; i >= 0; i-- {
+		switch name[i] {
+		case '>':
+			depth++
+		case '<':
+			depth--
+		}
+
+		if depth == 0 {
+			return name[:i], splitTypes(name[i+1 : len(name)-1]), i > 0
+		}
+	}
+
+	return "", nil, false
+}
+
+// ParseFunctionType splits a function type into its return and parameter types. The last
+// parenthesized group holds the parameters, so `int(int)(char)` takes a char and returns an `int(int)`
+func ParseFunctionType(name string) (ret string, params []string, ok bool) {
+	if !strings.HasSuffix(name, ")") {
+		return "", nil, false
+	}
+
+	depth := 0
+	for i := len(name) - 1; i >= 0; i-- {
+		switch name[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+		}
+
+		if depth == 0 {
+			return name[:i], splitTypes(name[i+1 : len(name)-1]), i > 0
+		}
+	}
+
+	return "", nil, false
+}
+
+// splitTypes splits a comma separated type list, leaving the commas of nested function and generic
+// types alone
+func splitTypes(list string) []string {
+	var types []string
+	depth, start := 0, 0
+
+	for i, c := range list {
+		switch c {
+		case '(', '<':
+			depth++
+		case ')', '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				types = append(types, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if list != "" {
+		types = append(types, list[start:])
+	}
+
+	return types
+}
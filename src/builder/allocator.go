@@ -0,0 +1,235 @@
+package builder
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// The allocator puts a header in front of every block it hands out, which records the size of the
+// block, its reference counts and how to release what it refers to, and keeps what follows 16-byte aligned
+const allocationHeader = 32
+
+var bytePointer = types.NewPointer(types.I8)
+
+// dropType is the signature of the functions releasing the references held by a block before it is freed
+var dropType = types.NewPointer(types.NewFunc(types.Void, bytePointer))
+
+// headerType lays out the header: the size, the strong and weak reference counts and the drop function
+var headerType = types.NewStruct(types.I64, types.I32, types.I32, dropType)
+
+const (
+	headerSize = iota
+	headerStrong
+	headerWeak
+	headerDrop
+)
+
+// SetDebug makes programs report the allocations still live when they exit
+func (b *Builder) SetDebug(enabled bool) *Builder {
+	b.debug = enabled
+	return b
+}
+
+// allocationCounters returns the globals counting the live allocations and their bytes
+func (b *Builder) allocationCounters() (*ir.Global, *ir.Global) {
+	if b.liveAllocations == nil {
+		b.liveAllocations = b.module.NewGlobalDef("__velox_live_allocations", constant.NewInt(types.I64, 0))
+		b.liveBytes = b.module.NewGlobalDef("__velox_live_bytes", constant.NewInt(types.I64, 0))
+	}
+
+	return b.liveAllocations, b.liveBytes
+}
+
+// allocFunction returns the runtime's allocator, which hands out zeroed memory holding one reference and
+// stops the program when there is none left. In debug builds the first allocation registers the leak report
+func (b *Builder) allocFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_alloc"]; ok {
+		return fn
+	}
+
+	size := ir.NewParam("size", types.I64)
+	drop := ir.NewParam("drop", dropType)
+	fn := b.module.NewFunc("__velox_alloc", bytePointer, size, drop)
+	b.functions["__velox_alloc"] = fn
+
+	entry := fn.NewBlock("entry")
+	failed := fn.NewBlock("failed")
+	allocated := fn.NewBlock("allocated")
+
+	calloc := b.externalFunction("calloc", bytePointer, types.I64, types.I64)
+	block := entry.NewCall(calloc, constant.NewInt(types.I64, 1), entry.NewAdd(size, constant.NewInt(types.I64, allocationHeader)))
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, block, constant.NewNull(bytePointer)), failed, allocated)
+
+	b.runtimeError(failed, "Out of memory allocating %lld byte(s)\n", size)
+
+	header := allocated.NewBitCast(block, types.NewPointer(headerType))
+	allocated.NewStore(size, headerField(allocated, header, headerSize))
+	allocated.NewStore(constant.NewInt(types.I32, 1), headerField(allocated, header, headerStrong))
+	allocated.NewStore(drop, headerField(allocated, header, headerDrop))
+	b.count(allocated, size, false)
+
+	if b.debug {
+		watch := fn.NewBlock("watch")
+		done := fn.NewBlock("done")
+
+		watched := b.module.NewGlobalDef("__velox_watching", constant.False)
+		allocated.NewCondBr(allocated.NewLoad(types.I1, watched), done, watch)
+
+		atexit := b.externalFunction("atexit", types.I32, types.NewPointer(types.NewFunc(types.Void)))
+		watch.NewCall(atexit, b.reportFunction())
+		watch.NewStore(constant.True, watched)
+		watch.NewBr(done)
+
+		allocated = done
+	}
+
+	allocated.NewRet(allocated.NewGetElementPtr(types.I8, block, constant.NewInt(types.I64, allocationHeader)))
+	return fn
+}
+
+// freeFunction returns the runtime's counterpart to the allocator. Only releases free memory, once both
+// reference counts are zero
+func (b *Builder) freeFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_free"]; ok {
+		return fn
+	}
+
+	memory := ir.NewParam("memory", bytePointer)
+	fn := b.module.NewFunc("__velox_free", types.Void, memory)
+	b.functions["__velox_free"] = fn
+
+	entry := fn.NewBlock("entry")
+	block := entry.NewGetElementPtr(types.I8, memory, constant.NewInt(types.I64, -allocationHeader))
+	size := entry.NewLoad(types.I64, headerField(entry, entry.NewBitCast(block, types.NewPointer(headerType)), headerSize))
+	b.count(entry, size, true)
+	entry.NewCall(b.externalFunction("free", types.Void, bytePointer), block)
+	entry.NewRet(nil)
+
+	return fn
+}
+
+// headerOf returns the header in front of memory handed out by the allocator
+func headerOf(block *ir.Block, memory value.Value) value.Value {
+	start := block.NewGetElementPtr(types.I8, memory, constant.NewInt(types.I64, -allocationHeader))
+	return block.NewBitCast(start, types.NewPointer(headerType))
+}
+
+func headerField(block *ir.Block, header value.Value, field int64) value.Value {
+	return block.NewGetElementPtr(headerType, header, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, field))
+}
+
+// count adds an allocation of size bytes to the live counters, or takes one away
+func (b *Builder) count(block *ir.Block, size value.Value, release bool) {
+	allocations, bytes := b.allocationCounters()
+
+	one := value.Value(constant.NewInt(types.I64, 1))
+	if release {
+		one = constant.NewInt(types.I64, -1)
+		size = block.NewSub(constant.NewInt(types.I64, 0), size)
+	}
+
+	block.NewStore(block.NewAdd(block.NewLoad(types.I64, allocations), one), allocations)
+	block.NewStore(block.NewAdd(block.NewLoad(types.I64, bytes), size), bytes)
+}
+
+// reportFunction returns the exit handler of debug builds, which lists the allocations never freed. Its
+// body is generated by finishReport once every global is known
+func (b *Builder) reportFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_report"]; ok {
+		return fn
+	}
+
+	fn := b.module.NewFunc("__velox_report", types.Void)
+	b.functions["__velox_report"] = fn
+	return fn
+}
+
+// finishReport generates the exit handler if an allocation registers it. Globals are released first, so
+// only what nothing refers to anymore, such as a cycle of instances, is reported
+func (b *Builder) finishReport() {
+	fn, ok := b.functions["__velox_report"]
+	if !ok {
+		return
+	}
+
+	entry := fn.NewBlock("entry")
+	leaked := fn.NewBlock("leaked")
+	done := fn.NewBlock("done")
+
+	for _, global := range b.countedGlobals {
+		held := entry.NewLoad(global.ContentType, global)
+		entry.NewStore(nullOf(global.ContentType), global)
+		entry.NewCall(b.releaseFunction(), b.memoryOf(entry, held))
+	}
+
+	allocations, bytes := b.allocationCounters()
+	live := entry.NewLoad(types.I64, allocations)
+	entry.NewCondBr(entry.NewICmp(enum.IPredNE, live, constant.NewInt(types.I64, 0)), leaked, done)
+
+	message := b.stringConstant("velox: %lld allocation(s) of %lld byte(s) still live at exit\n")
+	leaked.NewCall(b.fprintfFunction(), b.standardError(leaked), message, live, leaked.NewLoad(types.I64, bytes))
+	leaked.NewBr(done)
+
+	done.NewRet(nil)
+}
+
+// boundsFunction returns the runtime's handler for an index outside of an array, which stops the program
+func (b *Builder) boundsFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_out_of_bounds"]; ok {
+		return fn
+	}
+
+	index := ir.NewParam("index", types.I64)
+	length := ir.NewParam("length", types.I64)
+	fn := b.module.NewFunc("__velox_out_of_bounds", types.Void, index, length)
+	b.functions["__velox_out_of_bounds"] = fn
+
+	b.runtimeError(fn.NewBlock("entry"), "Index %lld is out of bounds for an array of length %lld\n", index, length)
+	return fn
+}
+
+// lengthFunction returns the runtime's handler for allocating an array of a negative or too large
+// length, which stops the program
+func (b *Builder) lengthFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_bad_length"]; ok {
+		return fn
+	}
+
+	length := ir.NewParam("length", types.I64)
+	fn := b.module.NewFunc("__velox_bad_length", types.Void, length)
+	b.functions["__velox_bad_length"] = fn
+
+	b.runtimeError(fn.NewBlock("entry"), "Cannot allocate an array of length %lld\n", length)
+	return fn
+}
+
+// runtimeError ends block by printing message to stderr and exiting with status 1
+func (b *Builder) runtimeError(block *ir.Block, message string, args ...value.Value) {
+	args = append([]value.Value{b.standardError(block), b.stringConstant(message)}, args...)
+	block.NewCall(b.fprintfFunction(), args...)
+	block.NewCall(b.externalFunction("exit", types.Void, types.I32), constant.NewInt(types.I32, 1))
+	block.NewUnreachable()
+}
+
+func (b *Builder) fprintfFunction() *ir.Func {
+	fn := b.externalFunction("fprintf", types.I32, bytePointer, bytePointer)
+	fn.Sig.Variadic = true
+	return fn
+}
+
+// standardError loads the C library's stderr stream, which Windows only exposes through a function
+func (b *Builder) standardError(block *ir.Block) value.Value {
+	if b.target == Windows {
+		return block.NewCall(b.externalFunction("__acrt_iob_func", bytePointer, types.I32), constant.NewInt(types.I32, 2))
+	}
+
+	if b.stderr == nil {
+		b.stderr = b.module.NewGlobal("stderr", bytePointer)
+		b.stderr.Linkage = enum.LinkageExternal
+	}
+
+	return block.NewLoad(bytePointer, b.stderr)
+}
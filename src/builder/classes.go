@@ -0,0 +1,358 @@
+package builder
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// classType is a declared class. Instances are a named LLVM struct on the heap, values of the class are
+// pointers to one
+type classType struct {
+	typ     *types.StructType
+	fields  []string
+	weak    map[string]bool // Fields holding a weak reference
+	node    *ast.ASTNode
+	base    *classType
+	laidOut bool
+	slots   []string   // Virtual methods in the order of their vtable entries, those of the base first
+	vtable  *ir.Global // Implementations of the slots, nil when the hierarchy has no virtual methods
+}
+
+// declareClasses adds every class type to the module, so fields and signatures can refer to classes
+// declared after them. The fields are laid out by layoutClasses once every struct exists
+func (b *Builder) declareClasses(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type == ast.ClassDeclaration {
+			b.classes[child.Name] = &classType{typ: types.NewStruct(), weak: make(map[string]bool), node: child}
+			b.module.NewTypeDef(child.Name, b.classes[child.Name].typ)
+		}
+	}
+
+	for _, child := range declarations {
+		if child.Type == ast.ClassDeclaration && len(child.Children) > 0 && child.Children[0].Type == ast.Identifier {
+			b.classes[child.Name].base = b.classes[child.Children[0].Name]
+		}
+	}
+}
+
+func (b *Builder) layoutClasses(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type == ast.ClassDeclaration {
+			b.layoutClass(b.classes[child.Name])
+		}
+	}
+}
+
+// layoutClass places the fields of c after those of its base, so an instance of c is also one of the
+// base. The root of a hierarchy with virtual methods starts with a pointer to the vtable
+func (b *Builder) layoutClass(c *classType) {
+	if c.laidOut {
+		return
+	}
+	c.laidOut = true
+
+	if c.base != nil {
+		b.layoutClass(c.base)
+		c.typ.Fields = append(c.typ.Fields, c.base.typ.Fields...)
+		c.fields = append(c.fields, c.base.fields...)
+		c.slots = append(c.slots, c.base.slots...)
+		maps.Copy(c.weak, c.base.weak)
+	} else if b.hasVirtuals(c) {
+		c.typ.Fields = append(c.typ.Fields, types.NewPointer(bytePointer))
+		c.fields = append(c.fields, "")
+	}
+
+	for _, field := range c.node.Children {
+		if field.Type == ast.VariableDeclaration {
+			c.typ.Fields = append(c.typ.Fields, b.getTypeFromName(field.Children[0].Name))
+			c.fields = append(c.fields, field.Name)
+			c.weak[field.Name] = field.HasModifier("weak")
+		}
+	}
+
+	for _, method := range methods(c.node) {
+		if method.HasModifier("virtual") {
+			c.slots = append(c.slots, strings.TrimPrefix(method.Name, c.node.Name+"."))
+		}
+	}
+
+	if len(c.fields) > 0 && c.fields[0] == "" {
+		c.vtable = b.module.NewGlobal("__velox_vtable."+c.node.Name, types.NewArray(uint64(len(c.slots)), bytePointer))
+		c.vtable.Immutable = true
+	}
+}
+
+// hasVirtuals reports whether any class in the hierarchy rooted at root declares a virtual method
+func (b *Builder) hasVirtuals(root *classType) bool {
+	for _, c := range b.classes {
+		top := c
+		for top.base != nil {
+			top = top.base
+		}
+
+		if top != root {
+			continue
+		}
+
+		for _, method := range methods(c.node) {
+			if method.HasModifier("virtual") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// defineVtables fills the vtable of every class with the implementations of its virtual methods, its
+// own overrides or those it inherits. Entries are stored as i8* and cast back when called
+func (b *Builder) defineVtables(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		c := b.classes[child.Name]
+		if child.Type != ast.ClassDeclaration || c.vtable == nil {
+			continue
+		}
+
+		t := c.vtable.ContentType.(*types.ArrayType)
+		if len(c.slots) == 0 {
+			c.vtable.Init = constant.NewZeroInitializer(t)
+			continue
+		}
+
+		entries := make([]constant.Constant, len(c.slots))
+		for i, name := range c.slots {
+			entries[i] = constant.NewBitCast(b.implementation(c, name), bytePointer)
+		}
+
+		c.vtable.Init = constant.NewArray(t, entries...)
+	}
+}
+
+// implementation finds the function running the method name on instances of c, which is either its
+// own or the one of its nearest base declaring it. The constructor is found the same way
+func (b *Builder) implementation(c *classType, name string) *ir.Func {
+	for class := c; class != nil; class = class.base {
+		if fn, ok := b.functions[class.node.Name+"."+name]; ok {
+			return fn
+		}
+	}
+
+	return nil
+}
+
+// methods lists the functions of a class, its methods and its constructor, or the methods of a struct
+func methods(class *ast.ASTNode) []*ast.ASTNode {
+	var functions []*ast.ASTNode
+	for _, member := range class.Children {
+		if member.Type == ast.FunctionDeclaration {
+			functions = append(functions, member)
+		}
+	}
+
+	return functions
+}
+
+// arrayType lowers `T[]` to a pointer to the length of the array followed by its elements
+func (b *Builder) arrayType(element string) types.Type {
+	return types.NewPointer(types.NewStruct(types.I64, types.NewArray(0, b.getTypeFromName(element))))
+}
+
+// isReferenceNode reports whether node, already analyzed, refers to an instance or an array
+func (b *Builder) isReferenceNode(node *ast.ASTNode) bool {
+	_, isArray := ast.Element(node.ResolvedType)
+	return isArray || b.classes[node.ResolvedType] != nil
+}
+
+// generateNew allocates an instance and runs its constructor, or allocates an array
+func (b *Builder) generateNew(node *ast.ASTNode) value.Value {
+	if _, ok := ast.Element(node.Name); ok {
+		return b.temporary(b.newArray(b.getTypeFromName(node.Name), b.generateExpression(node.Children[0])))
+	}
+
+	c := b.classes[node.Name]
+	size, _ := b.sizeAlign(c.typ)
+	memory := b.currentBlock.NewCall(b.allocFunction(), constant.NewInt(types.I64, int64(size)), b.dropFunction(c))
+	instance := b.currentBlock.NewBitCast(memory, types.NewPointer(c.typ))
+
+	if c.vtable != nil {
+		slot := b.currentBlock.NewGetElementPtr(c.typ, instance, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+		b.currentBlock.NewStore(constant.NewBitCast(c.vtable, c.typ.Fields[0]), slot)
+	}
+
+	// The allocator hands out zeroed memory, so only fields with an initializer are stored, those of
+	// the bases first
+	var hierarchy []*classType
+	for class := c; class != nil; class = class.base {
+		hierarchy = append([]*classType{class}, hierarchy...)
+	}
+
+	for _, class := range hierarchy {
+		for _, field := range class.node.Children {
+			if field.Type != ast.VariableDeclaration || len(field.Children) < 2 {
+				continue
+			}
+
+			index := b.fieldIndex(c.typ, field.Name)
+			slot := b.currentBlock.NewGetElementPtr(c.typ, instance, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(index)))
+			val := b.implicitConvert(b.generateExpression(field.Children[1]), c.typ.Fields[index], fmt.Sprintf("initialization of '%s.%s'", class.node.Name, field.Name))
+			b.currentBlock.NewStore(val, slot)
+		}
+	}
+
+	if constructor := b.implementation(c, "New"); constructor != nil {
+		this := b.implicitConvert(instance, constructor.Params[0].Typ, fmt.Sprintf("the constructor of '%s'", node.Name))
+		args := append([]value.Value{this}, b.generateArguments(constructor, node.Children, 1)...)
+		b.currentBlock.NewCall(constructor, args...)
+	}
+
+	return b.temporary(instance)
+}
+
+// newArray allocates an array of length zeroed elements, t being the type of the array. A negative
+// length, or one whose size does not fit in 64 bits, stops the program
+func (b *Builder) newArray(t types.Type, length value.Value) value.Value {
+	layout := t.(*types.PointerType).ElemType.(*types.StructType)
+	element := layout.Fields[1].(*types.ArrayType).ElemType
+	elementSize, _ := b.sizeAlign(element)
+
+	length = b.convertValue(length, types.I64)
+	limit := int64(math.MaxInt64 - 8)
+	if elementSize > 0 {
+		limit /= int64(elementSize)
+	}
+
+	if known, ok := length.(*constant.Int); !ok || known.X.Sign() < 0 || known.X.Int64() > limit {
+		// Negative lengths wrap around to huge unsigned ones, so one comparison covers both
+		valid := b.currentFunction.NewBlock(fmt.Sprintf("length.ok.%d", len(b.blocks)))
+		invalid := b.currentFunction.NewBlock(fmt.Sprintf("length.fail.%d", len(b.blocks)))
+		b.blocks = append(b.blocks, valid, invalid)
+
+		b.currentBlock.NewCondBr(b.currentBlock.NewICmp(enum.IPredULE, length, constant.NewInt(types.I64, limit)), valid, invalid)
+		invalid.NewCall(b.lengthFunction(), length)
+		invalid.NewUnreachable()
+		b.currentBlock = valid
+	}
+
+	size := b.currentBlock.NewAdd(constant.NewInt(types.I64, 8), b.currentBlock.NewMul(length, constant.NewInt(types.I64, int64(elementSize))))
+	array := b.currentBlock.NewBitCast(b.currentBlock.NewCall(b.allocFunction(), size, b.elementsDrop(element)), t)
+
+	zero := constant.NewInt(types.I32, 0)
+	b.currentBlock.NewStore(length, b.currentBlock.NewGetElementPtr(layout, array, zero, zero))
+
+	return array
+}
+
+// generateArrayInitializer allocates an array holding the values of `{1, 2, 3}`
+func (b *Builder) generateArrayInitializer(node *ast.ASTNode) value.Value {
+	t := b.getTypeFromName(node.Name)
+	array := b.newArray(t, constant.NewInt(types.I64, int64(len(node.Children))))
+	layout := t.(*types.PointerType).ElemType
+	elementType := layout.(*types.StructType).Fields[1].(*types.ArrayType).ElemType
+
+	for i, element := range node.Children {
+		val := b.implicitConvert(b.generateExpression(element), elementType, fmt.Sprintf("element %d of the array", i+1))
+		if b.isCounted(elementType) {
+			val = b.own(val)
+		}
+
+		slot := b.currentBlock.NewGetElementPtr(layout, array, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 1), constant.NewInt(types.I64, int64(i)))
+		b.currentBlock.NewStore(val, slot)
+	}
+
+	return b.temporary(array)
+}
+
+// arrayLength reads the length stored in front of the elements of array
+func (b *Builder) arrayLength(array value.Value) value.Value {
+	layout := array.Type().(*types.PointerType).ElemType
+	zero := constant.NewInt(types.I32, 0)
+
+	return b.currentBlock.NewLoad(types.I64, b.currentBlock.NewGetElementPtr(layout, array, zero, zero))
+}
+
+// elementSlot returns the storage of `list[index]`. An index outside of the array stops the program
+func (b *Builder) elementSlot(node *ast.ASTNode) value.Value {
+	array := b.generateExpression(node.Children[0])
+	index := b.convertValue(b.generateExpression(node.Children[1]), types.I64)
+	length := b.arrayLength(array)
+
+	// Negative indices wrap around to huge unsigned ones, so one comparison covers both ends
+	inside := b.currentFunction.NewBlock(fmt.Sprintf("index.ok.%d", len(b.blocks)))
+	outside := b.currentFunction.NewBlock(fmt.Sprintf("index.fail.%d", len(b.blocks)))
+	b.blocks = append(b.blocks, inside, outside)
+
+	b.currentBlock.NewCondBr(b.currentBlock.NewICmp(enum.IPredULT, index, length), inside, outside)
+	outside.NewCall(b.boundsFunction(), index, length)
+	outside.NewUnreachable()
+
+	b.currentBlock = inside
+	layout := array.Type().(*types.PointerType).ElemType
+	return b.currentBlock.NewGetElementPtr(layout, array, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 1), index)
+}
+
+// generateArrayAccess reads `list[index]`
+func (b *Builder) generateArrayAccess(node *ast.ASTNode) value.Value {
+	slot := b.elementSlot(node)
+	return b.currentBlock.NewLoad(slot.Type().(*types.PointerType).ElemType, slot)
+}
+
+// generateMethodCall calls the function implementing a method with the receiver as `this`. Virtual
+// methods are looked up in the vtable of the instance, except when called through `super`, and methods
+// of interfaces in the method table of the value
+func (b *Builder) generateMethodCall(node *ast.ASTNode) value.Value {
+	if i, ok := b.interfaces[node.Children[0].ResolvedType]; ok {
+		return b.generateInterfaceCall(node, i)
+	}
+
+	fn := b.functions[node.Name]
+	var receiver value.Value
+	if node.Children[0].IsSuper() {
+		this, _ := b.lookupVariable("this")
+		receiver = b.readVariable(this)
+	} else {
+		receiver = b.generateExpression(node.Children[0])
+		b.borrow(node.Children[0], receiver)
+	}
+
+	receiver = b.implicitConvert(receiver, fn.Params[0].Typ, fmt.Sprintf("receiver of '%s'", node.Name))
+	args := append([]value.Value{receiver}, b.generateArguments(fn, node.Children[1:], 1)...)
+
+	var callee value.Value = fn
+	if c := b.classes[node.Children[0].ResolvedType]; !node.Children[0].IsSuper() && c != nil && c.vtable != nil {
+		owner := fn.Params[0].Typ.(*types.PointerType).ElemType.Name()
+		if slot := slices.Index(c.slots, strings.TrimPrefix(node.Name, owner+".")); slot >= 0 {
+			callee = b.virtualCallee(b.currentBlock, receiver, slot, fn)
+		}
+	}
+
+	return b.result(b.currentBlock.NewCall(callee, args...))
+}
+
+// virtualCallee loads the implementation of a virtual method from the vtable instance points to, cast to
+// the type of fn, the implementation known at compile time
+func (b *Builder) virtualCallee(block *ir.Block, instance value.Value, slot int, fn *ir.Func) value.Value {
+	layout := instance.Type().(*types.PointerType).ElemType
+	zero := constant.NewInt(types.I32, 0)
+
+	vtable := block.NewLoad(types.NewPointer(bytePointer), block.NewGetElementPtr(layout, instance, zero, zero))
+	entry := block.NewLoad(bytePointer, block.NewGetElementPtr(bytePointer, vtable, constant.NewInt(types.I64, int64(slot))))
+	return block.NewBitCast(entry, fn.Type())
+}
+
+// generateDelete drops the reference held by a variable, field or element by storing null in its place
+func (b *Builder) generateDelete(node *ast.ASTNode) {
+	target := node.Children[0]
+	slot := b.targetSlot(target)
+
+	b.storeReference(nullOf(slot.Type().(*types.PointerType).ElemType), slot, b.isWeak(target))
+}
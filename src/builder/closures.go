@@ -0,0 +1,208 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// closureType is how function values are stored: the code to run and the environment it runs with.
+// The code takes the environment as a hidden first argument
+func closureType(ret types.Type, params []types.Type) *types.StructType {
+	code := types.NewFunc(ret, append([]types.Type{stringType}, params...)...)
+	return types.NewStruct(types.NewPointer(code), stringType)
+}
+
+// closureSignature is the signature of the code a closure of type t points to
+func closureSignature(t types.Type) (*types.FuncType, bool) {
+	// Declared structs are named, closures never are
+	closure, ok := t.(*types.StructType)
+	if !ok || closure.Name() != "" || len(closure.Fields) != 2 {
+		return nil, false
+	}
+
+	pointer, ok := closure.Fields[0].(*types.PointerType)
+	if !ok {
+		return nil, false
+	}
+
+	sig, ok := pointer.ElemType.(*types.FuncType)
+	return sig, ok
+}
+
+// functionValue wraps a named function in a closure with no environment. The function does not take
+// one, so the closure points to a thunk that drops it
+func (b *Builder) functionValue(fn *ir.Func) constant.Constant {
+	name := fn.Name() + ".closure"
+
+	thunk, ok := b.functions[name]
+	if !ok {
+		params := []*ir.Param{ir.NewParam("env", stringType)}
+		for _, param := range fn.Params {
+			params = append(params, ir.NewParam(param.Name(), param.Typ))
+		}
+
+		thunk = b.module.NewFunc(name, fn.Sig.RetType, params...)
+		b.functions[name] = thunk
+
+		args := make([]value.Value, len(fn.Params))
+		for i, param := range thunk.Params[1:] {
+			args[i] = param
+		}
+
+		entry := thunk.NewBlock("entry")
+		result := entry.NewCall(fn, args...)
+
+		if fn.Sig.RetType.Equal(types.Void) {
+			entry.NewRet(nil)
+		} else {
+			entry.NewRet(result)
+		}
+	}
+
+	paramTypes := make([]types.Type, len(fn.Params))
+	for i, param := range fn.Params {
+		paramTypes[i] = param.Typ
+	}
+
+	return constant.NewStruct(closureType(fn.Sig.RetType, paramTypes), thunk, constant.NewNull(stringType))
+}
+
+// generateLambda emits the lambda's body as a function of its own and returns a closure over it. Sema
+// lists the captures: values are copied into the environment, references store the variable's address
+func (b *Builder) generateLambda(node *ast.ASTNode) value.Value {
+	paramNodes, body, captures := node.Children[0].Children, node.Children[1], node.Children[2].Children
+
+	closure := b.getTypeFromName(node.ResolvedType).(*types.StructType)
+	sig, _ := closureSignature(closure)
+
+	var fields []types.Type
+	var captured []value.Value
+	for _, capture := range captures {
+		binding, ok := b.lookupVariable(capture.Name)
+		if !ok {
+			panic(fmt.Sprintf("Unknown identifier: %s", capture.Name))
+		}
+
+		// A captured reference keeps a count for the environment, given back by its drop function
+		if capture.HasModifier("ref") {
+			captured = append(captured, binding.value)
+		} else {
+			captured = append(captured, b.readVariable(binding))
+			if b.isCounted(captured[len(captured)-1].Type()) {
+				b.retain(captured[len(captured)-1])
+			}
+		}
+
+		fields = append(fields, captured[len(captured)-1].Type())
+	}
+
+	envType := types.NewStruct(fields...)
+	fn := b.lambdaFunction(sig, paramNodes, body, captures, envType)
+	result := constant.NewStruct(closure, fn, constant.NewNull(stringType))
+
+	if len(captures) == 0 {
+		return result
+	}
+
+	// The environment is allocated on the heap, since the closure can outlive the frame creating it. It
+	// is counted like an instance, the new closure holding its first count
+	size, _ := b.sizeAlign(envType)
+	env := b.currentBlock.NewCall(b.allocFunction(), constant.NewInt(types.I64, int64(size)), b.environmentDrop(fn, envType, captures))
+
+	typed := b.currentBlock.NewBitCast(env, types.NewPointer(envType))
+	for i, val := range captured {
+		b.currentBlock.NewStore(val, b.currentBlock.NewGetElementPtr(envType, typed, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i))))
+	}
+
+	return b.temporary(b.currentBlock.NewInsertValue(result, env, 1))
+}
+
+// environmentDrop returns the function releasing the references an environment of fn captured by
+// value, or null when it holds none
+func (b *Builder) environmentDrop(fn *ir.Func, envType *types.StructType, captures []*ast.ASTNode) constant.Constant {
+	var counted []int
+	for i, capture := range captures {
+		if !capture.HasModifier("ref") && b.isCounted(envType.Fields[i]) {
+			counted = append(counted, i)
+		}
+	}
+
+	if len(counted) == 0 {
+		return constant.NewNull(dropType)
+	}
+
+	return b.runtimeFunction("__velox_drop."+fn.Name(), types.Void, func(drop *ir.Func) {
+		entry := drop.NewBlock("entry")
+		env := entry.NewBitCast(drop.Params[0], types.NewPointer(envType))
+
+		for _, i := range counted {
+			slot := entry.NewGetElementPtr(envType, env, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			entry.NewCall(b.releaseFunction(), b.memoryOf(entry, entry.NewLoad(envType.Fields[i], slot)))
+		}
+
+		entry.NewRet(nil)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// lambdaFunction generates the code of a lambda, named after the function it is written in
+func (b *Builder) lambdaFunction(sig *types.FuncType, paramNodes []*ast.ASTNode, body *ast.ASTNode, captures []*ast.ASTNode, envType *types.StructType) *ir.Func {
+	params := []*ir.Param{ir.NewParam("env", stringType)}
+	for i, param := range paramNodes {
+		params = append(params, ir.NewParam(param.Name, sig.Params[i+1]))
+	}
+
+	b.lambdas++
+	fn := b.module.NewFunc(fmt.Sprintf("%s.lambda.%d", b.currentFunction.Name(), b.lambdas), sig.RetType, params...)
+
+	// The enclosing function is resumed once the lambda is done
+	outerFunction, outerBlock, outerScopes := b.currentFunction, b.currentBlock, b.scopes
+	outerSlots, outerEntrySlots, outerLoops, outerTemporaries := b.slotNames, b.entrySlots, b.loops, b.temporaries
+	defer func() {
+		b.currentFunction, b.currentBlock, b.scopes = outerFunction, outerBlock, outerScopes
+		b.slotNames, b.entrySlots, b.loops, b.temporaries = outerSlots, outerEntrySlots, outerLoops, outerTemporaries
+	}()
+
+	entry := b.beginFunction(fn)
+	b.loops, b.temporaries = nil, nil
+
+	if len(captures) > 0 {
+		env := entry.NewBitCast(fn.Params[0], types.NewPointer(envType))
+
+		for i, capture := range captures {
+			field := entry.NewGetElementPtr(envType, env, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			val := entry.NewLoad(envType.Fields[i], field)
+			val.SetName(capture.Name)
+
+			if capture.HasModifier("ref") {
+				b.declareLocal(capture.Name, &Binding{value: val})
+			} else {
+				b.declareLocal(capture.Name, &Binding{value: val, direct: true, isConst: true})
+			}
+		}
+	}
+
+	// Locals of the lambda shadow its captures
+	b.pushScope()
+	b.bindParameters(fn.Params[1:], paramNodes)
+
+	if body.Type == ast.Block {
+		b.generateStatements(body)
+		b.leaveScope()
+	} else {
+		result := b.generateExpression(body)
+
+		if sig.RetType.Equal(types.Void) {
+			b.returnValue(nil)
+		} else {
+			b.returnValue(b.implicitConvert(result, sig.RetType, "return from a lambda"))
+		}
+	}
+
+	b.finishFunction(fn)
+	return fn
+}
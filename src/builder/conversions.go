@@ -0,0 +1,161 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+func typeName(t types.Type) string {
+	switch t {
+	case types.I1:
+		return "bool"
+	case types.I8:
+		return "char"
+	case types.I32:
+		return "int"
+	case types.Double:
+		return "float"
+	case types.Void:
+		return "void"
+	default:
+		return t.String()
+	}
+}
+
+func isIntegerType(t types.Type) bool {
+	_, ok := t.(*types.IntType)
+	return ok
+}
+
+func isFloatType(t types.Type) bool {
+	_, ok := t.(*types.FloatType)
+	return ok
+}
+
+func isNumericType(t types.Type) bool {
+	return isIntegerType(t) || isFloatType(t)
+}
+
+func intBits(t types.Type) uint64 {
+	return t.(*types.IntType).BitSize
+}
+
+// Usual arithmetic conversions: float wins, otherwise integers are promoted to at least int
+func arithmeticType(left, right types.Type) types.Type {
+	if isFloatType(left) || isFloatType(right) {
+		return types.Double
+	}
+
+	if intBits(left) > intBits(types.I32) || intBits(right) > intBits(types.I32) {
+		if intBits(left) > intBits(right) {
+			return left
+		}
+
+		return right
+	}
+
+	return types.I32
+}
+
+// implicitConvert converts val for an assignment, argument or return. Lossy conversions are reported by sema
+func (b *Builder) implicitConvert(val value.Value, target types.Type, context string) value.Value {
+	if val.Type().Equal(target) {
+		return val
+	}
+
+	if b.isInterfaceType(target) {
+		return b.toInterface(val, target.(*types.StructType))
+	}
+
+	if isPointerType(val.Type()) && isPointerType(target) {
+		return b.convertPointer(val, target)
+	}
+
+	if !isNumericType(val.Type()) || !isNumericType(target) {
+		panic(fmt.Sprintf("Cannot convert %s to %s in %s", typeName(val.Type()), typeName(target), context))
+	}
+
+	return b.convertValue(val, target)
+}
+
+// convertValue emits the instruction that converts val to target, folding constants where possible
+func (b *Builder) convertValue(val value.Value, target types.Type) value.Value {
+	from := val.Type()
+
+	if from.Equal(target) {
+		return val
+	}
+
+	switch {
+	// A number becomes a bool by comparing it to zero, truncating would keep only its lowest bit
+	case target.Equal(types.I1) && isIntegerType(from):
+		if c, ok := val.(*constant.Int); ok {
+			return constant.NewBool(c.X.Sign() != 0)
+		}
+
+		return b.currentBlock.NewICmp(enum.IPredNE, val, constant.NewInt(from.(*types.IntType), 0))
+	case target.Equal(types.I1) && isFloatType(from):
+		if c, ok := val.(*constant.Float); ok {
+			return constant.NewBool(c.X.Sign() != 0)
+		}
+
+		return b.currentBlock.NewFCmp(enum.FPredUNE, val, constant.NewFloat(from.(*types.FloatType), 0))
+	case isIntegerType(from) && isIntegerType(target):
+		if c, ok := val.(*constant.Int); ok {
+			return constant.NewInt(target.(*types.IntType), truncateInt(c, target))
+		}
+
+		if intBits(target) < intBits(from) {
+			return b.currentBlock.NewTrunc(val, target)
+		}
+
+		// Booleans are unsigned, everything else is sign extended
+		if from.Equal(types.I1) {
+			return b.currentBlock.NewZExt(val, target)
+		}
+
+		return b.currentBlock.NewSExt(val, target)
+	case isIntegerType(from) && isFloatType(target):
+		if c, ok := val.(*constant.Int); ok {
+			return constant.NewFloat(target.(*types.FloatType), float64(truncateInt(c, from)))
+		}
+
+		if from.Equal(types.I1) {
+			return b.currentBlock.NewUIToFP(val, target)
+		}
+
+		return b.currentBlock.NewSIToFP(val, target)
+	case isFloatType(from) && isIntegerType(target):
+		if c, ok := val.(*constant.Float); ok {
+			f, _ := c.X.Float64()
+			return constant.NewInt(target.(*types.IntType), truncateInt(constant.NewInt(types.I64, int64(f)), target))
+		}
+
+		return b.currentBlock.NewFPToSI(val, target)
+	case isFloatType(from) && isFloatType(target):
+		return b.currentBlock.NewFPExt(val, target)
+	}
+
+	panic(fmt.Sprintf("Cannot convert %s to %s", typeName(from), typeName(target)))
+}
+
+// truncateInt wraps a constant to the width of target the same way `trunc` would at runtime
+func truncateInt(c *constant.Int, target types.Type) int64 {
+	v := c.X.Int64()
+	bits := intBits(target)
+
+	if bits >= 64 {
+		return v
+	}
+
+	if bits == 1 {
+		return v & 1
+	}
+
+	shift := 64 - bits
+	return v << shift >> shift
+}
@@ -0,0 +1,137 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// generateGlobalVariable lowers a top-level declaration to an LLVM global. The initializer is folded
+// at compile time, globals without one start at zero
+func (b *Builder) generateGlobalVariable(node *ast.ASTNode) {
+	name := node.Name
+	varType := b.getTypeFromName(node.Children[0].Name)
+
+	var init constant.Constant
+
+	switch _, isFunction := closureSignature(varType); {
+	case isFunction:
+		// Function values are initialized with a function name, or start out null
+		init = constant.NewZeroInitializer(varType)
+		if len(node.Children) > 1 {
+			init = b.functionValue(b.functions[node.Children[1].Name])
+		}
+	case b.structs[varType.Name()] != nil:
+		init = constant.NewZeroInitializer(varType)
+		if len(node.Children) > 1 {
+			init = b.constantStruct(node.Children[1], varType.(*types.StructType))
+		}
+	case b.unions[varType.Name()] != nil:
+		// Globals start out as the first variant
+		init = constant.NewZeroInitializer(varType)
+		if len(node.Children) > 1 {
+			init = b.constantUnion(node.Children[1])
+		}
+	case len(node.Children) > 1:
+		folded, err := ast.EvaluateConstant(node.Children[1], b.constantLookup)
+		if err != nil {
+			panic(fmt.Sprintf("Initializer of global '%s' is not constant: %v", name, err))
+		}
+
+		init = b.constantFromValue(folded.Convert(typeName(varType)), varType)
+	default:
+		init = b.constantFromValue(ast.ConstantValue{}, varType)
+	}
+
+	global := b.module.NewGlobalDef(name, init)
+	global.Immutable = node.HasModifier("const")
+	b.globalVariables[name] = &Binding{value: global, isConst: global.Immutable, written: true}
+
+	if b.isCounted(varType) {
+		b.countedGlobals = append(b.countedGlobals, global)
+	}
+}
+
+// constantLookup exposes #define constants and const globals to the constant folder
+func (b *Builder) constantLookup(name string) (ast.ConstantValue, bool) {
+	var value constant.Constant = b.globals[name]
+
+	if binding, ok := b.globalVariables[name]; ok && binding.isConst {
+		value = binding.value.(*ir.Global).Init
+	}
+
+	switch c := value.(type) {
+	case *constant.Int:
+		return ast.ConstantValue{Type: typeName(c.Typ), Int: c.X.Int64()}, true
+	case *constant.Float:
+		f, _ := c.X.Float64()
+		return ast.ConstantValue{Type: "float", Float: f}, true
+	case *constant.ExprGetElementPtr:
+		// String constants point into a NUL-terminated character array
+		if global, ok := c.Src.(*ir.Global); ok {
+			if data, ok := global.Init.(*constant.CharArray); ok {
+				return ast.ConstantValue{Type: "string", Str: string(data.X[:len(data.X)-1])}, true
+			}
+		}
+	}
+
+	return ast.ConstantValue{}, false
+}
+
+func (b *Builder) constantFromValue(v ast.ConstantValue, t types.Type) constant.Constant {
+	if v.Type == "string" {
+		return b.stringConstant(v.Str)
+	}
+
+	if isFloatType(t) {
+		return constant.NewFloat(t.(*types.FloatType), v.AsFloat())
+	}
+
+	// Pointer and interface constants can only be null
+	if isPointerType(t) || b.isInterfaceType(t) {
+		return nullOf(t)
+	}
+
+	return constant.NewInt(t.(*types.IntType), v.Int)
+}
+
+// lookupVariable resolves a local or global variable
+func (b *Builder) lookupVariable(name string) (*Binding, bool) {
+	if binding, ok := b.lookupLocal(name); ok {
+		return binding, true
+	}
+
+	if binding, ok := b.globalVariables[name]; ok {
+		return binding, true
+	}
+
+	return nil, false
+}
+
+// readVariable returns the current value of a variable
+func (b *Builder) readVariable(binding *Binding) value.Value {
+	if binding.direct {
+		return binding.value
+	}
+
+	return b.currentBlock.NewLoad(binding.value.Type().(*types.PointerType).ElemType, binding.value)
+}
+
+// variableSlot returns the storage a write to name should go to
+func (b *Builder) variableSlot(name string) value.Value {
+	binding, ok := b.lookupVariable(name)
+
+	if !ok {
+		panic(fmt.Sprintf("Unknown identifier: %s", name))
+	}
+
+	if binding.isConst {
+		panic(fmt.Sprintf("Cannot assign to const '%s'", name))
+	}
+
+	return binding.value
+}
@@ -0,0 +1,222 @@
+package builder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// interfaceType is a declared interface. A value is a named LLVM struct of the memory it refers to and
+// the method table of the class or struct stored in it, so calls find the implementation at runtime
+type interfaceType struct {
+	typ     *types.StructType
+	methods []*ir.Func            // Signatures of the table entries in declaration order, never defined
+	tables  map[string]*ir.Global // Method tables, keyed by the class or struct they are for
+}
+
+// declareInterfaces adds every interface type to the module. The signatures of the methods are lowered
+// by declareInterfaceMethods, once the types they use are laid out
+func (b *Builder) declareInterfaces(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type == ast.InterfaceDeclaration {
+			b.interfaces[child.Name] = &interfaceType{typ: types.NewStruct(bytePointer, types.NewPointer(bytePointer)), tables: make(map[string]*ir.Global)}
+			b.module.NewTypeDef(child.Name, b.interfaces[child.Name].typ)
+		}
+	}
+}
+
+// declareInterfaceMethods lowers the signature of every interface method. Entries receive the memory
+// of the value as `this`, whatever it is an instance of
+func (b *Builder) declareInterfaceMethods(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type != ast.InterfaceDeclaration {
+			continue
+		}
+
+		i := b.interfaces[child.Name]
+		for _, method := range child.Children {
+			retType, params := b.signature(method)
+			params[0] = ir.NewParam("this", bytePointer)
+
+			fn := ir.NewFunc(method.Name, retType, params...)
+			b.recordRefs(fn, method)
+			i.methods = append(i.methods, fn)
+		}
+	}
+}
+
+// isInterfaceType reports whether t is the type of interface values
+func (b *Builder) isInterfaceType(t types.Type) bool {
+	s, ok := t.(*types.StructType)
+	if !ok {
+		return false
+	}
+
+	i, ok := b.interfaces[s.Name()]
+	return ok && i.typ == s
+}
+
+// toInterface converts an instance or a struct to a value of the interface t. Structs are copied into
+// memory of their own, which the interface value refers to
+func (b *Builder) toInterface(val value.Value, t *types.StructType) value.Value {
+	if _, isNull := val.(*constant.Null); isNull {
+		return constant.NewZeroInitializer(t)
+	}
+
+	i := b.interfaces[t.Name()]
+	var data value.Value
+	var owner string
+	boxed := false
+
+	if p, ok := val.Type().(*types.PointerType); ok {
+		owner = p.ElemType.Name()
+		data = b.currentBlock.NewBitCast(val, bytePointer)
+	} else {
+		owner = val.Type().Name()
+		size, _ := b.sizeAlign(val.Type())
+		data = b.currentBlock.NewCall(b.allocFunction(), constant.NewInt(types.I64, int64(size)), constant.NewNull(dropType))
+		b.currentBlock.NewStore(val, b.currentBlock.NewBitCast(data, types.NewPointer(val.Type())))
+		boxed = true
+	}
+
+	table := constant.NewBitCast(b.methodTable(i, t.Name(), owner), types.NewPointer(bytePointer))
+	fat := b.currentBlock.NewInsertValue(b.currentBlock.NewInsertValue(constant.NewZeroInitializer(t), data, 0), table, 1)
+
+	if boxed {
+		return b.temporary(fat)
+	}
+
+	// A new instance hands its count over to the interface value
+	for k, temporary := range b.temporaries {
+		if temporary == val {
+			b.temporaries[k] = fat
+		}
+	}
+
+	return fat
+}
+
+// methodTable returns the table of the implementations owner, a class or a struct, gives the methods of
+// the interface name, generating it on first use
+func (b *Builder) methodTable(i *interfaceType, name, owner string) *ir.Global {
+	if table, ok := i.tables[owner]; ok {
+		return table
+	}
+
+	entries := make([]constant.Constant, len(i.methods))
+	for k, method := range i.methods {
+		entries[k] = constant.NewBitCast(b.entry(owner, strings.TrimPrefix(method.Name(), name+".")), bytePointer)
+	}
+
+	t := types.NewArray(uint64(len(entries)), bytePointer)
+	table := b.module.NewGlobalDef("__velox_itable."+owner+"."+name, constant.NewZeroInitializer(t))
+	if len(entries) > 0 {
+		table.Init = constant.NewArray(t, entries...)
+	}
+
+	table.Immutable = true
+	i.tables[owner] = table
+	return table
+}
+
+// entry finds the function a method table holds for the method name of owner. A virtual method goes
+// through the vtable of the instance and a struct method gets its value out of the memory first
+func (b *Builder) entry(owner, name string) *ir.Func {
+	if c, ok := b.classes[owner]; ok {
+		fn := b.implementation(c, name)
+		slot := slices.Index(c.slots, name)
+		if c.vtable == nil || slot < 0 {
+			return fn
+		}
+
+		return b.runtimeFunction("__velox_virtual."+owner+"."+name, fn.Sig.RetType, func(thunk *ir.Func) {
+			block := thunk.NewBlock("entry")
+			b.forward(thunk, block, b.virtualCallee(block, thunk.Params[0], slot, fn), arguments(thunk.Params))
+		}, copyParams(fn.Params)...)
+	}
+
+	s := b.structs[owner]
+	fn := b.functions[owner+"."+name]
+
+	params := append([]*ir.Param{ir.NewParam("this", bytePointer)}, copyParams(fn.Params[1:])...)
+	return b.runtimeFunction("__velox_unbox."+owner+"."+name, fn.Sig.RetType, func(thunk *ir.Func) {
+		block := thunk.NewBlock("entry")
+		this := block.NewLoad(s.typ, block.NewBitCast(thunk.Params[0], types.NewPointer(s.typ)))
+		b.forward(thunk, block, fn, append([]value.Value{this}, arguments(thunk.Params[1:])...))
+	}, params...)
+}
+
+// forward ends block of thunk with a call of callee, returning its result
+func (b *Builder) forward(thunk *ir.Func, block *ir.Block, callee value.Value, args []value.Value) {
+	result := block.NewCall(callee, args...)
+	if thunk.Sig.RetType.Equal(types.Void) {
+		block.NewRet(nil)
+	} else {
+		block.NewRet(result)
+	}
+}
+
+// arguments passes params on as they are
+func arguments(params []*ir.Param) []value.Value {
+	args := make([]value.Value, len(params))
+	for i, param := range params {
+		args[i] = param
+	}
+
+	return args
+}
+
+// copyParams returns new parameters with the names and types of params
+func copyParams(params []*ir.Param) []*ir.Param {
+	copies := make([]*ir.Param, len(params))
+	for i, param := range params {
+		copies[i] = ir.NewParam(param.Name(), param.Typ)
+	}
+
+	return copies
+}
+
+// generateInterfaceCall calls a method through the method table of an interface value, passing the
+// memory it refers to as `this`
+func (b *Builder) generateInterfaceCall(node *ast.ASTNode, i *interfaceType) value.Value {
+	slot := slices.IndexFunc(i.methods, func(fn *ir.Func) bool { return fn.Name() == node.Name })
+	if slot < 0 {
+		panic(fmt.Sprintf("Interface has no method '%s'", node.Name))
+	}
+
+	method := i.methods[slot]
+	receiver := b.generateExpression(node.Children[0])
+	b.borrow(node.Children[0], receiver)
+
+	data := b.currentBlock.NewExtractValue(receiver, 0)
+	table := b.currentBlock.NewExtractValue(receiver, 1)
+	entry := b.currentBlock.NewLoad(bytePointer, b.currentBlock.NewGetElementPtr(bytePointer, table, constant.NewInt(types.I64, int64(slot))))
+
+	args := append([]value.Value{data}, b.generateArguments(method, node.Children[1:], 1)...)
+	return b.result(b.currentBlock.NewCall(b.currentBlock.NewBitCast(entry, method.Type()), args...))
+}
+
+// interfaceComparison compares interface values by identity, whether they refer to the same memory
+func (b *Builder) interfaceComparison(node *ast.ASTNode, left, right value.Value) value.Value {
+	t := left.Type()
+	if !b.isInterfaceType(t) {
+		t = right.Type()
+	}
+
+	left = b.currentBlock.NewExtractValue(b.implicitConvert(left, t, "comparison"), 0)
+	right = b.currentBlock.NewExtractValue(b.implicitConvert(right, t, "comparison"), 0)
+
+	pred := enum.IPredEQ
+	if node.Name == "!=" {
+		pred = enum.IPredNE
+	}
+
+	return b.currentBlock.NewICmp(pred, left, right)
+}
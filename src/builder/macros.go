@@ -0,0 +1,117 @@
+package builder
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// generateMacroExpansion lowers a postfix `::` macro. A macro goes through every stage:
+//   - the tokenizer reads `::` as a Macro token
+//   - the parser turns `expr::name` into a MacroExpansion node with expr as its only child
+//   - sema.analyzeMacroExpansion checks the operand and gives the expansion its type
+//   - this function emits the code, ::slef below is the smallest complete example
+func (b *Builder) generateMacroExpansion(node *ast.ASTNode) value.Value {
+	switch node.Name {
+	case "prev":
+		// Every store to the variable first copies the old value into its shadow slot
+		binding, ok := b.lookupVariable(node.Children[0].Name)
+		if !ok || binding.previous == nil {
+			panic(fmt.Sprintf("'%s' does not track its previous value", node.Children[0].Name))
+		}
+
+		return b.currentBlock.NewLoad(binding.previous.Type().(*types.PointerType).ElemType, binding.previous)
+	case "slef":
+		// Like slef has every letter of self, the result has every hex digit of the operand, shuffled
+		operand := b.convertValue(b.generateExpression(node.Children[0]), types.I32)
+		return b.currentBlock.NewCall(b.slefFunction(), operand)
+	}
+
+	panic(fmt.Sprintf("Unknown macro: ::%s", node.Name))
+}
+
+// hexDigits is the number of hex digits in an int
+const hexDigits = 8
+
+// slefFunction returns the runtime helper behind ::slef, emitting it on first use. A value with n
+// significant hex digits has them reordered by permutation n-1 of a table generated from the seed
+func (b *Builder) slefFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_slef"]; ok {
+		return fn
+	}
+
+	rng := rand.New(rand.NewSource(b.seed))
+	rowType := types.NewArray(hexDigits, types.I32)
+
+	var rows []constant.Constant
+	for n := 1; n <= hexDigits; n++ {
+		perm := rng.Perm(n)
+
+		// Reroll the identity so that anything with two or more digits is visibly scrambled
+		for n > 1 && isIdentity(perm) {
+			perm = rng.Perm(n)
+		}
+
+		row := make([]constant.Constant, hexDigits)
+		for i := range row {
+			source := i // Unused positions are never read
+			if i < n {
+				source = perm[i]
+			}
+
+			row[i] = constant.NewInt(types.I32, int64(source))
+		}
+
+		rows = append(rows, constant.NewArray(rowType, row...))
+	}
+
+	table := b.module.NewGlobalDef("__velox_slef_permutations", constant.NewArray(types.NewArray(hexDigits, rowType), rows...))
+	table.Immutable = true
+
+	ctlz := b.module.NewFunc("llvm.ctlz.i32", types.I32, ir.NewParam("", types.I32), ir.NewParam("", types.I1))
+
+	param := ir.NewParam("value", types.I32)
+	fn := b.module.NewFunc("__velox_slef", types.I32, param)
+	b.functions["__velox_slef"] = fn
+
+	entry := fn.NewBlock("entry")
+	zero := constant.NewInt(types.I32, 0)
+
+	// digits = max(1, ceil(significant bits / 4))
+	bits := entry.NewSub(constant.NewInt(types.I32, 32), entry.NewCall(ctlz, param, constant.False))
+	rounded := entry.NewUDiv(entry.NewAdd(bits, constant.NewInt(types.I32, 3)), constant.NewInt(types.I32, 4))
+	digits := entry.NewSelect(entry.NewICmp(enum.IPredEQ, rounded, zero), constant.NewInt(types.I32, 1), rounded)
+	row := entry.NewSub(digits, constant.NewInt(types.I32, 1))
+
+	var result value.Value = zero
+	for i := 0; i < hexDigits; i++ {
+		position := constant.NewInt(types.I32, int64(i))
+
+		sourcePtr := entry.NewGetElementPtr(table.ContentType, table, zero, row, position)
+		source := entry.NewLoad(types.I32, sourcePtr)
+		digit := entry.NewAnd(entry.NewLShr(param, entry.NewMul(source, constant.NewInt(types.I32, 4))), constant.NewInt(types.I32, 0xF))
+		placed := entry.NewShl(digit, constant.NewInt(types.I32, int64(4*i)))
+
+		inRange := entry.NewICmp(enum.IPredULT, position, digits)
+		result = entry.NewOr(result, entry.NewSelect(inRange, placed, zero))
+	}
+
+	entry.NewRet(result)
+	return fn
+}
+
+func isIdentity(perm []int) bool {
+	for i, v := range perm {
+		if i != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,6 +3,8 @@ package builder
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
@@ -14,39 +16,88 @@ import (
 
 type LoopTrace struct {
 	condition, body, end *ir.Block
+	// Scopes open where break and continue lead, the locals of deeper ones are released when jumping
+	breakDepth, continueDepth int
 }
 
 type Builder struct {
 	ast             *ast.ASTNode
 	module          *ir.Module
-	functions       []*ir.Func
+	functions       map[string]*ir.Func
 	currentFunction *ir.Func
 	blocks          []*ir.Block
 	currentBlock    *ir.Block
-	locals          map[string]value.Value
+	scopes          []*Scope
+	slotNames       map[string]int
+	entrySlots      int
 	globals         map[string]constant.Constant
+	globalVariables map[string]*Binding
+	strings         map[string]constant.Constant
 	loops           []*LoopTrace
+	seed            int64 // Seeds everything the compiler randomizes, such as the ::slef permutations
+	seeded          bool  // Whether the seed was given explicitly, which also fixes the runtime RNG
+	lambdas         int   // Lambdas generated so far, numbering their functions
+	structs         map[string]*structType
+	enums           map[string]*types.IntType // Backing type of every enum
+	unions          map[string]*unionType
+	refs            map[*ir.Func][]bool // Which parameters of a function are ref, for functions that have any
+	classes         map[string]*classType
+	interfaces      map[string]*interfaceType
+	target          TargetType
+	debug           bool       // Whether programs report the allocations still live at exit
+	liveAllocations *ir.Global // Counters of the runtime allocator, created with it
+	liveBytes       *ir.Global
+	stderr          *ir.Global
+	temporaries     []value.Value // New references the current statement gives back at its end
+	countedGlobals  []*ir.Global  // Globals holding a reference, released before the leak report
 }
 
 func NewBuilder(ast *ast.ASTNode) *Builder {
 	return &Builder{
-		ast:       ast,
-		module:    ir.NewModule(),
-		locals:    make(map[string]value.Value),
-		globals:   make(map[string]constant.Constant),
-		loops:     make([]*LoopTrace, 0),
-		functions: make([]*ir.Func, 0),
+		ast:             ast,
+		module:          ir.NewModule(),
+		globals:         make(map[string]constant.Constant),
+		globalVariables: make(map[string]*Binding),
+		strings:         make(map[string]constant.Constant),
+		loops:           make([]*LoopTrace, 0),
+		functions:       make(map[string]*ir.Func),
+		structs:         make(map[string]*structType),
+		enums:           make(map[string]*types.IntType),
+		unions:          make(map[string]*unionType),
+		refs:            make(map[*ir.Func][]bool),
+		classes:         make(map[string]*classType),
+		interfaces:      make(map[string]*interfaceType),
+		seed:            time.Now().UnixNano(),
 	}
 }
 
-type targetType int
+// SetSeed makes randomized output reproducible
+func (b *Builder) SetSeed(seed int64) *Builder {
+	b.seed = seed
+	b.seeded = true
+	return b
+}
+
+type TargetType int
 
 const (
-	Windows targetType = iota
+	Windows TargetType = iota
 	Linux
 )
 
-func (b *Builder) SetTarget(target targetType) *Builder {
+// Macros lists the names the preprocessor defines as 1 when compiling for target
+func (target TargetType) Macros() []string {
+	switch target {
+	case Windows:
+		return []string{"__WINDOWS__", "__X86_64__"}
+	case Linux:
+		return []string{"__LINUX__", "__X86_64__"}
+	}
+
+	panic(fmt.Sprintf("Unsupported target: %d", target))
+}
+
+func (b *Builder) SetTarget(target TargetType) *Builder {
 	switch target {
 	case Windows:
 		b.module.TargetTriple = "x86_64-pc-windows-msvc"
@@ -56,97 +107,251 @@ func (b *Builder) SetTarget(target targetType) *Builder {
 		panic(fmt.Sprintf("Unsupported target: %d", target))
 	}
 
+	b.target = target
 	return b
 }
 
 func (b *Builder) Build() *ir.Module {
-	for _, child := range b.ast.Children {
+	declarations := topLevel(b.ast.Children)
+	b.declareEnums(declarations)
+	b.declareUnions(declarations)
+	b.declareInterfaces(declarations)
+	b.declareClasses(declarations)
+	b.declareStructs(declarations)
+	b.layoutClasses(declarations)
+	b.layoutUnions(declarations)
+	b.declareInterfaceMethods(declarations)
+
+	// Every signature is declared before any body is generated, so calls can refer to functions defined later
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.FunctionDeclaration:
+			b.declareFunction(child)
+		case ast.ClassDeclaration, ast.StructDeclaration:
+			for _, method := range methods(child) {
+				b.declareFunction(method)
+			}
+		}
+	}
+
+	b.defineVtables(declarations)
+
+	for _, child := range declarations {
 		switch child.Type {
 		case ast.PreprocessorDirective:
 			b.generatePreprocessorDirective(child)
+		case ast.VariableDeclaration:
+			b.generateGlobalVariable(child)
 		case ast.FunctionDeclaration:
-			b.generateFunction(child)
+			if !child.IsPrototype() {
+				b.generateFunction(child)
+			}
+		case ast.ClassDeclaration, ast.StructDeclaration:
+			for _, method := range methods(child) {
+				b.generateFunction(method)
+			}
 		}
 	}
 
+	b.finishReport()
+	b.mangleSymbols()
 	return b.module
 }
 
-func (b *Builder) generatePreprocessorDirective(node *ast.ASTNode) {
-	if node.Name == "#define" && len(node.Children) == 2 {
-		name := node.Children[0].Name
+// symbolSpelling replaces the characters of type names that assemblers reject in symbols
+var symbolSpelling = strings.NewReplacer("<", "$", ">", "$", ",", "$", "*", "$ptr", "[]", "$arr", "(", "$fn$", ")", "$")
 
-		// Parse value to int
-		var value int64 = 0
+// mangleSymbols renames the functions and globals of instances of generics, such as `List<int>.add`,
+// to symbols any assembler accepts, `List$int$.add`
+func (b *Builder) mangleSymbols() {
+	for _, fn := range b.module.Funcs {
+		if symbol := symbolSpelling.Replace(fn.Name()); symbol != fn.Name() {
+			fn.SetName(symbol)
+		}
+	}
 
-		if node.Children[1].Type == ast.Literal {
-			fmt.Sscanf(node.Children[1].Name, "%d", &value)
-		} else {
-			panic(fmt.Sprintf("Unsupported value type: %v", node.Children[1].Type))
+	// Unnamed globals keep their numbers
+	for _, global := range b.module.Globals {
+		if symbol := symbolSpelling.Replace(global.Name()); symbol != global.Name() {
+			global.SetName(symbol)
 		}
+	}
+}
 
-		// Add to globals
-		b.globals[name] = constant.NewInt(types.I32, value)
-		return
+// topLevel lists the declarations of the program with imported modules expanded in place. Semantic
+// analysis has already qualified their names, so they can share the module's namespace. Generics are
+// left out, their instances follow the declarations as functions and classes of their own
+func topLevel(nodes []*ast.ASTNode) []*ast.ASTNode {
+	var declarations []*ast.ASTNode
+
+	for _, node := range nodes {
+		if node.Type == ast.ImportDeclaration {
+			declarations = append(declarations, topLevel(node.Children)...)
+		} else if !node.IsGeneric() {
+			declarations = append(declarations, node)
+		}
 	}
 
-	panic(fmt.Sprintf("Unsupported preprocessor directive: %v", node.Name))
+	return declarations
 }
 
-func (b *Builder) generateFunction(node *ast.ASTNode) {
-	name := node.Name
-	retType := getTypeFromName(node.Children[0].Name)
+func (b *Builder) generatePreprocessorDirective(node *ast.ASTNode) {
+	name := node.Children[0].Name
 
-	// Create parameter types
-	var paramTypes []types.Type
-	var paramNames []string
-	for _, param := range node.Children[1].Children {
-		paramName := param.Name
-		paramType := getTypeFromName(param.Children[0].Name)
-		paramTypes = append(paramTypes, paramType)
-		paramNames = append(paramNames, paramName)
-	}
+	switch node.Name {
+	case "#define":
+		// A define without a value only marks the name as defined
+		if len(node.Children) < 2 {
+			b.globals[name] = nil
+			return
+		}
 
-	// Create function type
-	var funcType *types.FuncType
+		// The value keeps the type of its expression, `#define RATIO 2.5` is a float
+		folded, err := ast.EvaluateConstant(node.Children[1], b.constantLookup)
+		if err != nil {
+			panic(fmt.Sprintf("Value of '%s' is not constant: %v", name, err))
+		}
 
-	if len(paramTypes) > 0 {
-		funcType = types.NewFunc(retType, paramTypes...)
-	} else {
-		funcType = types.NewFunc(retType)
+		b.globals[name] = b.constantFromValue(folded, b.getTypeFromName(folded.Type))
+	case "#undef":
+		delete(b.globals, name)
+	default:
+		panic(fmt.Sprintf("Unsupported preprocessor directive: %v", node.Name))
 	}
+}
+
+// declareFunction adds the signature of a prototype or definition to the module. A prototype followed
+// by its definition shares one ir.Func, a prototype that is never defined becomes an external declaration
+func (b *Builder) declareFunction(node *ast.ASTNode) *ir.Func {
+	if fn, ok := b.functions[node.Name]; ok {
+		return fn
+	}
+
+	retType, params := b.signature(node)
+	fn := b.module.NewFunc(node.Name, retType, params...)
+	b.functions[node.Name] = fn
+	b.recordRefs(fn, node)
 
+	return fn
+}
+
+// signature lowers the return type and the parameters of the function declared by node
+func (b *Builder) signature(node *ast.ASTNode) (types.Type, []*ir.Param) {
 	var params []*ir.Param
-	for i, paramName := range paramNames {
-		params = append(params, ir.NewParam(paramName, paramTypes[i]))
+	for _, param := range node.Children[1].Children {
+		paramType := b.getTypeFromName(param.Children[0].Name)
+
+		// A ref parameter receives the address of the caller's variable
+		if param.HasModifier("ref") {
+			paramType = types.NewPointer(paramType)
+		}
+
+		params = append(params, ir.NewParam(param.Name, paramType))
 	}
 
-	// Create function
-	fn := b.module.NewFunc(name, funcType.RetType, params...)
+	return b.getTypeFromName(node.Children[0].Name), params
+}
 
-	// Add function to builder
-	b.functions = append(b.functions, fn)
-	b.currentFunction = fn
+// recordRefs notes which parameters of fn, declared by node, are ref
+func (b *Builder) recordRefs(fn *ir.Func, node *ast.ASTNode) {
+	for i, param := range node.Children[1].Children {
+		if param.HasModifier("ref") {
+			if b.refs[fn] == nil {
+				b.refs[fn] = make([]bool, len(fn.Params))
+			}
 
-	// Locals
-	b.locals = make(map[string]value.Value)
-	for i, param := range fn.Params {
-		param.SetName(paramNames[i])
-		b.locals[paramNames[i]] = param
+			b.refs[fn][i] = true
+		}
 	}
+}
+
+func (b *Builder) generateFunction(node *ast.ASTNode) {
+	fn := b.functions[node.Name]
+
+	// Parameters live in the function's outermost scope, which the body shares
+	b.beginFunction(fn)
+	b.bindParameters(fn.Params, node.Children[1].Children)
+
+	b.generateStatements(node.Children[2])
+	b.leaveScope()
+
+	b.finishFunction(fn)
+	b.currentFunction = nil
+}
+
+// beginFunction starts generating the body of fn, with one empty scope, and returns its entry block
+func (b *Builder) beginFunction(fn *ir.Func) *ir.Block {
+	b.currentFunction = fn
 
 	entry := fn.NewBlock("entry")
 	b.blocks = append(b.blocks, entry)
 	b.currentBlock = entry
 
-	b.generateBlock(entry, node.Children[2])
+	b.scopes = nil
+	b.slotNames = make(map[string]int)
+	b.entrySlots = 0
+	b.pushScope()
+
+	return entry
+}
+
+// bindParameters declares params under the names of paramNodes. Mutable parameters are spilled to
+// stack slots up front so they can be reassigned like any other local, const parameters are read
+// straight from the argument and ref parameters use the caller's variable as their slot. The caller
+// keeps a reference it passes alive, a parameter only takes a count of its own when it is written
+func (b *Builder) bindParameters(params []*ir.Param, paramNodes []*ast.ASTNode) {
+	for i, param := range params {
+		name := paramNodes[i].Name
 
-	// Add return statement if not present
+		if paramNodes[i].HasModifier("ref") {
+			param.SetName(name)
+
+			binding := &Binding{value: param}
+			if paramNodes[i].TracksPrevious {
+				b.trackPrevious(name, binding, b.readVariable(binding))
+			}
+
+			b.declareLocal(name, binding)
+			continue
+		}
+
+		if paramNodes[i].HasModifier("const") {
+			param.SetName(name)
+			b.declareLocal(name, &Binding{value: param, direct: true, isConst: true})
+			continue
+		}
+
+		param.SetName(name + ".arg")
+		slot := b.newLocalSlot(name, param.Typ)
+		b.currentFunction.Blocks[0].NewStore(param, slot)
+
+		binding := &Binding{value: slot, written: paramNodes[i].Written}
+		if b.isCounted(param.Typ) && binding.written {
+			b.retain(param)
+			binding.counted = true
+		}
+
+		if paramNodes[i].TracksPrevious {
+			b.trackPrevious(name, binding, param)
+		}
+
+		b.declareLocal(name, binding)
+	}
+}
+
+// finishFunction adds the return statement if not present. Sema guarantees non-void functions return
+// on every path, so a trailing block without a terminator can only be reached in void functions. Retains
+// that turned out to be redundant are removed once the body is complete
+func (b *Builder) finishFunction(fn *ir.Func) {
 	if b.currentBlock.Term == nil {
-		b.currentBlock.NewRet(nil)
+		if fn.Sig.RetType.Equal(types.Void) {
+			b.currentBlock.NewRet(nil)
+		} else {
+			b.currentBlock.NewUnreachable()
+		}
 	}
 
-	b.currentFunction = nil
+	b.elideRetains(fn)
 }
 
 func (b *Builder) generateExpression(node *ast.ASTNode) value.Value {
@@ -159,12 +364,69 @@ func (b *Builder) generateExpression(node *ast.ASTNode) value.Value {
 		return b.generateBinaryExpression(node)
 	case ast.FunctionCall:
 		return b.generateFunctionCall(node)
+	case ast.CastExpression:
+		return b.generateCast(node)
+	case ast.UnaryExpression:
+		return b.generateUnaryExpression(node)
+	case ast.PostfixExpression:
+		return b.generateIncrement(node.Children[0], node.Name, false)
+	case ast.MacroExpansion:
+		return b.generateMacroExpansion(node)
+	case ast.Lambda:
+		return b.generateLambda(node)
+	case ast.StructLiteral:
+		return b.generateStructLiteral(node)
+	case ast.UnionLiteral:
+		return b.generateUnionLiteral(node)
+	case ast.MemberAccess:
+		return b.generateFieldAccess(node)
+	case ast.MatchExpression:
+		return b.generateMatch(node)
+	case ast.NewExpression:
+		return b.generateNew(node)
+	case ast.MethodCall:
+		return b.generateMethodCall(node)
+	case ast.CallExpression:
+		return b.generateIndirectCall(node, b.generateExpression(node.Children[0]), node.Children[1:])
+	case ast.ArrayAccess:
+		return b.generateArrayAccess(node)
+	case ast.ArrayInitializer:
+		return b.generateArrayInitializer(node)
 	default:
 		panic(fmt.Sprintf("Unsupported expression type: %d", node.Type))
 	}
 }
 
 func (b *Builder) generateLiteral(node *ast.ASTNode) value.Value {
+	switch ast.LiteralType(node.Name) {
+	case "string":
+		str, err := ast.ParseStringLiteral(node.Name)
+		if err != nil {
+			panic(err)
+		}
+
+		return b.stringConstant(str)
+	case "char":
+		char, err := ast.ParseCharLiteral(node.Name)
+		if err != nil {
+			panic(err)
+		}
+
+		return constant.NewInt(types.I8, int64(char))
+	case "bool":
+		switch node.Name {
+		case "true":
+			return constant.True
+		case "false":
+			return constant.False
+		}
+
+		// maybe flips a coin every time it is evaluated
+		return b.currentBlock.NewCall(b.maybeFunction())
+	case "null":
+		return nullPointer
+	}
+
 	if val, err := strconv.Atoi(node.Name); err == nil {
 		return constant.NewInt(types.I32, int64(val))
 	} else if val, err := strconv.ParseFloat(node.Name, 64); err == nil {
@@ -174,19 +436,42 @@ func (b *Builder) generateLiteral(node *ast.ASTNode) value.Value {
 	panic(fmt.Sprintf("Unsupported literal type: %s", node.Name))
 }
 
-func (b *Builder) generateIdentifier(node *ast.ASTNode) value.Value {
-	if val, ok := b.locals[node.Name]; ok {
-		if _, isParam := val.(*ir.Param); isParam {
-			return val
-		}
+// stringConstant emits a NUL-terminated global for str and returns a pointer to its first character.
+// Identical strings share one global
+func (b *Builder) stringConstant(str string) constant.Constant {
+	if ptr, ok := b.strings[str]; ok {
+		return ptr
+	}
+
+	data := constant.NewCharArrayFromString(str + "\x00")
+	global := b.module.NewGlobalDef("", data)
+	global.Immutable = true
+
+	zero := constant.NewInt(types.I64, 0)
+	ptr := constant.NewGetElementPtr(data.Typ, global, zero, zero)
+	b.strings[str] = ptr
 
-		return b.currentBlock.NewLoad(val.Type().(*types.PointerType).ElemType, val)
+	return ptr
+}
+
+func (b *Builder) generateIdentifier(node *ast.ASTNode) value.Value {
+	if binding, ok := b.lookupVariable(node.Name); ok {
+		return b.readVariable(binding)
 	}
 
 	if val, ok := b.globals[node.Name]; ok {
+		if val == nil {
+			panic(fmt.Sprintf("'%s' is defined without a value", node.Name))
+		}
+
 		return val
 	}
 
+	// A function name used as a value is a closure without captures
+	if fn, ok := b.functions[node.Name]; ok {
+		return b.functionValue(fn)
+	}
+
 	panic(fmt.Sprintf("Unknown identifier: %s", node.Name))
 }
 
@@ -194,16 +479,33 @@ func (b *Builder) generateBinaryExpression(node *ast.ASTNode) value.Value {
 	left := b.generateExpression(node.Children[0])
 	right := b.generateExpression(node.Children[1])
 
-	lType, rType := left.Type(), right.Type()
+	if b.isInterfaceType(left.Type()) || b.isInterfaceType(right.Type()) {
+		return b.interfaceComparison(node, left, right)
+	}
+
+	if _, isStruct := left.Type().(*types.StructType); isStruct {
+		equal := b.structEqual(left, right)
+		if node.Name == "!=" {
+			return b.currentBlock.NewXor(equal, constant.True)
+		}
 
-	if lType != rType {
-		panic(fmt.Sprintf("Binary expression types do not match: %v, %v", lType, rType))
+		return equal
 	}
 
-	if lType != types.I32 && lType != types.Double {
-		panic(fmt.Sprintf("Unsupported binary expression type: %v", lType))
+	// Instances and arrays compare like pointers
+	if isPointerNode(node.Children[0]) || isPointerNode(node.Children[1]) || b.isReferenceNode(node.Children[0]) || b.isReferenceNode(node.Children[1]) {
+		return b.generatePointerOperation(node, left, right)
 	}
 
+	if !isNumericType(left.Type()) || !isNumericType(right.Type()) {
+		panic(fmt.Sprintf("Unsupported binary expression types: %v, %v", left.Type(), right.Type()))
+	}
+
+	// Both operands are brought to a common type before the operation
+	lType := arithmeticType(left.Type(), right.Type())
+	left = b.convertValue(left, lType)
+	right = b.convertValue(right, lType)
+
 	switch node.Name {
 	case "+":
 		switch lType {
@@ -289,58 +591,229 @@ func (b *Builder) generateBinaryExpression(node *ast.ASTNode) value.Value {
 	return nil
 }
 
+func (b *Builder) generateCast(node *ast.ASTNode) value.Value {
+	operand := b.generateExpression(node.Children[0])
+	target := b.getTypeFromName(node.Name)
+
+	if isPointerType(target) || isPointerNode(node.Children[0]) {
+		return b.convertPointer(operand, target)
+	}
+
+	if !isNumericType(operand.Type()) {
+		panic(fmt.Sprintf("Cannot cast %s to %s", typeName(operand.Type()), node.Name))
+	}
+
+	// Explicit casts never warn, the programmer asked for the conversion
+	return b.convertValue(operand, target)
+}
+
+func (b *Builder) generateUnaryExpression(node *ast.ASTNode) value.Value {
+	switch node.Name {
+	case "++", "--":
+		return b.generateIncrement(node.Children[0], node.Name, true)
+	case "&":
+		return b.targetSlot(node.Children[0])
+	case "*":
+		return b.generateDereference(node)
+	case "-":
+		operand := b.generateExpression(node.Children[0])
+
+		// Negative literals stay constants so they can initialize consts without storage
+		switch c := operand.(type) {
+		case *constant.Int:
+			return constant.NewInt(types.I32, -b.convertValue(c, types.I32).(*constant.Int).X.Int64())
+		case *constant.Float:
+			f, _ := c.X.Float64()
+			return constant.NewFloat(c.Typ, -f)
+		}
+
+		if isFloatType(operand.Type()) {
+			return b.currentBlock.NewFNeg(operand)
+		}
+
+		operand = b.convertValue(operand, arithmeticType(operand.Type(), types.I32))
+		return b.currentBlock.NewSub(constant.NewInt(operand.Type().(*types.IntType), 0), operand)
+	case "!":
+		operand := b.generateExpression(node.Children[0])
+
+		if isFloatType(operand.Type()) {
+			return b.currentBlock.NewFCmp(enum.FPredOEQ, operand, constant.NewFloat(operand.Type().(*types.FloatType), 0))
+		}
+
+		return b.currentBlock.NewICmp(enum.IPredEQ, operand, constant.NewInt(operand.Type().(*types.IntType), 0))
+	}
+
+	panic(fmt.Sprintf("Unsupported unary operator: %s", node.Name))
+}
+
+// generateIncrement applies ++ or -- to a variable and returns the new value for the prefix form
+// or the original value for the postfix form
+func (b *Builder) generateIncrement(target *ast.ASTNode, operator string, prefix bool) value.Value {
+	if target.Type != ast.Identifier && target.Type != ast.MemberAccess && target.Type != ast.ArrayAccess && !(target.Type == ast.UnaryExpression && target.Name == "*") {
+		panic(fmt.Sprintf("Cannot apply %s to %s", operator, ast.ASTNodeTypeNames[target.Type]))
+	}
+
+	slot := b.targetSlot(target)
+	varType := slot.Type().(*types.PointerType).ElemType
+	old := b.currentBlock.NewLoad(varType, slot)
+
+	var updated value.Value
+	if isPointerType(varType) {
+		updated = b.movePointer(old, constant.NewInt(types.I64, 1), operator == "--")
+	} else if isFloatType(varType) {
+		one := constant.NewFloat(varType.(*types.FloatType), 1)
+
+		if operator == "++" {
+			updated = b.currentBlock.NewFAdd(old, one)
+		} else {
+			updated = b.currentBlock.NewFSub(old, one)
+		}
+	} else {
+		one := constant.NewInt(varType.(*types.IntType), 1)
+
+		if operator == "++" {
+			updated = b.currentBlock.NewAdd(old, one)
+		} else {
+			updated = b.currentBlock.NewSub(old, one)
+		}
+	}
+
+	if target.Type == ast.Identifier {
+		b.recordPrevious(target.Name, old)
+	}
+	b.currentBlock.NewStore(updated, slot)
+
+	if prefix {
+		return updated
+	}
+
+	return old
+}
+
 func (b *Builder) generateFunctionCall(node *ast.ASTNode) value.Value {
 	fnName := node.Name
-	var fn *ir.Func
 
-	// Find the function in the module
-	for _, f := range b.module.Funcs {
-		if f.Name() == fnName {
-			fn = f
-			break
-		}
+	// Variables holding a function hide functions of the same name, as in sema
+	if binding, ok := b.lookupVariable(fnName); ok {
+		return b.generateIndirectCall(node, b.readVariable(binding), node.Children)
 	}
 
-	if fn == nil {
+	fn, ok := b.functions[fnName]
+
+	if !ok {
 		if fnName == "printf" {
 			fn = b.module.NewFunc("printf", types.Void, ir.NewParam("format", types.NewPointer(types.I8)))
 			fn.Sig.Variadic = true
+			b.functions[fnName] = fn
 		} else {
 			panic(fmt.Sprintf("Function not found: %s", fnName))
 		}
 	}
 
-	var args []value.Value
-	for _, arg := range node.Children {
-		args = append(args, b.generateExpression(arg))
-	}
+	args := b.generateArguments(fn, node.Children, 0)
 
 	if fnName == "printf" {
 		formatStr := ""
 
 		// Generate format string dynamically based on the argument types
-		for _, arg := range args {
+		for i, arg := range args {
+			if isPointerNode(node.Children[i]) {
+				formatStr += "%p"
+				continue
+			}
+
 			switch arg.Type() {
 			case types.I32:
 				formatStr += "%d"
 			case types.Double:
 				formatStr += "%f"
+			case types.I8:
+				// Variadic arguments are promoted to int, as in C
+				formatStr += "%c"
+				args[i] = b.convertValue(arg, types.I32)
+			case types.I1:
+				formatStr += "%d"
+				args[i] = b.convertValue(arg, types.I32)
 			default:
-				panic(fmt.Sprintf("Unsupported printf argument type: %v", arg.Type()))
+				if !arg.Type().Equal(stringType) {
+					panic(fmt.Sprintf("Unsupported printf argument type: %v", arg.Type()))
+				}
+
+				formatStr += "%s"
 			}
 		}
 
-		formatStr += "\n\x00"
-		args = append([]value.Value{b.module.NewGlobalDef("", constant.NewCharArray([]byte(formatStr)))}, args...)
+		formatStr += "\n"
+		args = append([]value.Value{b.stringConstant(formatStr)}, args...)
+	}
+
+	return b.result(b.currentBlock.NewCall(fn, args...))
+}
+
+// generateArguments evaluates the arguments of a call to fn, which go to its parameters from first on
+func (b *Builder) generateArguments(fn *ir.Func, nodes []*ast.ASTNode, first int) []value.Value {
+	var args []value.Value
+	for i, arg := range nodes {
+		param := first + i
+
+		// A ref parameter is passed the argument's storage
+		if b.refs[fn] != nil && b.refs[fn][param] {
+			args = append(args, b.targetSlot(arg))
+			continue
+		}
+
+		argValue := b.generateExpression(arg)
+
+		if param < len(fn.Params) && fn.Name() != "printf" {
+			argValue = b.implicitConvert(argValue, fn.Params[param].Typ, fmt.Sprintf("argument %d of '%s'", i+1, fn.Name()))
+			b.borrow(arg, argValue)
+		}
+
+		args = append(args, argValue)
+	}
+
+	return args
+}
+
+// generateIndirectCall calls through a function value, passing the closure's environment along
+func (b *Builder) generateIndirectCall(node *ast.ASTNode, callee value.Value, argNodes []*ast.ASTNode) value.Value {
+	sig, ok := closureSignature(callee.Type())
+	if !ok {
+		panic(fmt.Sprintf("'%s' is not a function", node.Name))
+	}
+
+	code := b.currentBlock.NewExtractValue(callee, 0)
+	args := []value.Value{b.currentBlock.NewExtractValue(callee, 1)}
+
+	for i, arg := range argNodes {
+		args = append(args, b.implicitConvert(b.generateExpression(arg), sig.Params[i+1], fmt.Sprintf("argument %d of '%s'", i+1, node.Name)))
+		b.borrow(arg, args[len(args)-1])
 	}
 
-	return b.currentBlock.NewCall(fn, args...)
+	return b.result(b.currentBlock.NewCall(code, args...))
 }
 
+// generateBlock emits the statements of node into block inside a new lexical scope
 func (b *Builder) generateBlock(block *ir.Block, node *ast.ASTNode) {
 	b.currentBlock = block
 
-	for _, child := range node.Children {
+	b.pushScope()
+	b.generateStatements(node)
+	b.leaveScope()
+}
+
+// generateStatements emits the statements of node. References a statement created without storing them
+// are released at its end
+func (b *Builder) generateStatements(node *ast.ASTNode) {
+	for i, child := range node.Children {
+		// Statements after a return, break or continue never run, but still need a block of their own
+		if i > 0 && b.currentBlock.Term != nil {
+			b.currentBlock = b.currentFunction.NewBlock(fmt.Sprintf("unreachable.%d", len(b.blocks)))
+			b.blocks = append(b.blocks, b.currentBlock)
+		}
+
+		mark := len(b.temporaries)
+
 		switch child.Type {
 		case ast.ReturnStatement:
 			b.generateReturn(child)
@@ -348,6 +821,10 @@ func (b *Builder) generateBlock(block *ir.Block, node *ast.ASTNode) {
 			b.generateVariableDeclaration(child)
 		case ast.FunctionCall:
 			b.generateFunctionCall(child)
+		case ast.MethodCall:
+			b.generateMethodCall(child)
+		case ast.UnaryExpression, ast.PostfixExpression, ast.CallExpression:
+			b.generateExpression(child)
 		case ast.Statement:
 			switch child.Name {
 			case "if":
@@ -356,6 +833,10 @@ func (b *Builder) generateBlock(block *ir.Block, node *ast.ASTNode) {
 				b.generateBreakContinue(true)
 			case "break":
 				b.generateBreakContinue(false)
+			case "unsafe":
+				b.generateBlock(b.currentBlock, child.Children[0])
+			case "delete":
+				b.generateDelete(child)
 			default:
 				panic(fmt.Sprintf("Unsupported statement type: %s", child.Name))
 			}
@@ -363,32 +844,83 @@ func (b *Builder) generateBlock(block *ir.Block, node *ast.ASTNode) {
 			b.generateAssignment(child)
 		case ast.WhileStatement:
 			b.generateWhileStatement(child)
+		case ast.SwitchStatement:
+			b.generateSwitch(child)
+		case ast.PreprocessorDirective:
+			b.generatePreprocessorDirective(child)
 		default:
 			panic(fmt.Sprintf("Unsupported block type: %s", ast.ASTNodeTypeNames[child.Type]))
 		}
+
+		if b.currentBlock.Term == nil {
+			b.releaseTemporaries(mark)
+		} else {
+			b.temporaries = b.temporaries[:mark]
+		}
 	}
 }
 
 func (b *Builder) generateReturn(node *ast.ASTNode) {
 	if len(node.Children) == 0 {
-		b.currentBlock.NewRet(nil)
+		b.returnValue(nil)
 		return
 	}
 
-	b.currentBlock.NewRet(b.generateExpression(node.Children[0]))
+	retValue := b.generateExpression(node.Children[0])
+	b.returnValue(b.implicitConvert(retValue, b.currentFunction.Sig.RetType, fmt.Sprintf("return from '%s'", b.currentFunction.Name())))
+}
+
+// returnValue returns val, or nothing for nil, after releasing what the function holds. A returned
+// reference keeps a count for the caller
+func (b *Builder) returnValue(val value.Value) {
+	if val != nil && b.isCounted(val.Type()) {
+		val = b.own(val)
+	}
+
+	b.releaseTemporaries(0)
+	b.releaseLocals(0)
+	b.currentBlock.NewRet(val)
 }
 
 func (b *Builder) generateVariableDeclaration(node *ast.ASTNode) {
 	name := node.Name
 
-	alloca := b.currentBlock.NewAlloca(getTypeFromName(node.Children[0].Name))
-	alloca.SetName(name)
-
-	b.locals[name] = alloca
+	varType := b.getTypeFromName(node.Children[0].Name)
+	isConst := node.HasModifier("const")
 
+	var initValue value.Value
 	if len(node.Children) > 1 {
-		b.currentBlock.NewStore(b.generateExpression(node.Children[1]), alloca)
+		initValue = b.implicitConvert(b.generateExpression(node.Children[1]), varType, fmt.Sprintf("initialization of '%s'", name))
 	}
+
+	// A reference starts out null, so leaving the scope can release it whether or not it was assigned
+	counted := b.isCounted(varType)
+	if counted && initValue == nil {
+		initValue = nullOf(varType)
+	}
+
+	// The name only becomes visible after its initializer, so `int x = x;` refers to an outer x.
+	// A const initialized from a literal never changes, so it is bound to the constant with no storage
+	if _, isConstant := initValue.(constant.Constant); isConst && isConstant {
+		b.declareLocal(name, &Binding{value: initValue, direct: true, isConst: true})
+		return
+	}
+
+	alloca := b.newLocalSlot(name, varType)
+	if counted {
+		initValue = b.own(initValue)
+	}
+
+	if initValue != nil {
+		b.currentBlock.NewStore(initValue, alloca)
+	}
+
+	binding := &Binding{value: alloca, isConst: isConst, counted: counted, written: node.Written}
+	if node.TracksPrevious {
+		b.trackPrevious(name, binding, initValue)
+	}
+
+	b.declareLocal(name, binding)
 }
 
 func (b *Builder) generateAssignment(node *ast.ASTNode) {
@@ -396,70 +928,92 @@ func (b *Builder) generateAssignment(node *ast.ASTNode) {
 	operator := node.Children[0].Name
 	rightExpr := b.generateExpression(node.Children[1])
 
-	alloca, ok := b.locals[name]
-
-	if !ok {
-		panic(fmt.Sprintf("Unknown identifier: %s", name))
+	// A field path in the third child writes into the variable
+	var alloca value.Value
+	if len(node.Children) > 2 {
+		alloca = b.targetSlot(node.Children[2])
+	} else {
+		alloca = b.variableSlot(name)
 	}
 
-	if _, isParam := alloca.(*ir.Param); isParam {
-		alloca = b.currentBlock.NewAlloca(alloca.Type())
-		b.currentBlock.NewStore(b.locals[name], alloca)
+	varType := alloca.Type().(*types.PointerType).ElemType
+
+	if b.isCounted(varType) {
+		weak := len(node.Children) > 2 && b.isWeak(node.Children[2])
+		b.storeReference(b.implicitConvert(rightExpr, varType, fmt.Sprintf("assignment to '%s'", name)), alloca, weak)
+		return
 	}
 
-	loadInst := b.currentBlock.NewLoad(alloca.Type().(*types.PointerType).ElemType, alloca)
+	loadInst := b.currentBlock.NewLoad(varType, alloca)
 
+	// Compound assignments are computed in the common type, then converted back to the variable's type
+	var left value.Value = loadInst
 	var result value.Value
 
+	if isPointerType(varType) && operator != "=" {
+		rightExpr = b.movePointer(left, rightExpr, operator == "-=")
+		operator = "="
+	} else if operator != "=" {
+		opType := arithmeticType(varType, rightExpr.Type())
+		left = b.convertValue(left, opType)
+		rightExpr = b.convertValue(rightExpr, opType)
+	}
+
 	switch operator {
 	case "=":
 		result = rightExpr
 	case "+=":
-		switch loadInst.Type() {
+		switch left.Type() {
 		case types.I32:
-			result = b.currentBlock.NewAdd(loadInst, rightExpr)
+			result = b.currentBlock.NewAdd(left, rightExpr)
 		case types.Double:
-			result = b.currentBlock.NewFAdd(loadInst, rightExpr)
+			result = b.currentBlock.NewFAdd(left, rightExpr)
 		}
 	case "-=":
-		switch loadInst.Type() {
+		switch left.Type() {
 		case types.I32:
-			result = b.currentBlock.NewSub(loadInst, rightExpr)
+			result = b.currentBlock.NewSub(left, rightExpr)
 		case types.Double:
-			result = b.currentBlock.NewFSub(loadInst, rightExpr)
+			result = b.currentBlock.NewFSub(left, rightExpr)
 		}
 	case "*=":
-		switch loadInst.Type() {
+		switch left.Type() {
 		case types.I32:
-			result = b.currentBlock.NewMul(loadInst, rightExpr)
+			result = b.currentBlock.NewMul(left, rightExpr)
 		case types.Double:
-			result = b.currentBlock.NewFMul(loadInst, rightExpr)
+			result = b.currentBlock.NewFMul(left, rightExpr)
 		}
 	case "/=":
-		switch loadInst.Type() {
+		switch left.Type() {
 		case types.I32:
-			result = b.currentBlock.NewSDiv(loadInst, rightExpr)
+			result = b.currentBlock.NewSDiv(left, rightExpr)
 		case types.Double:
-			result = b.currentBlock.NewFDiv(loadInst, rightExpr)
+			result = b.currentBlock.NewFDiv(left, rightExpr)
 		}
 	case "%=":
-		switch loadInst.Type() {
+		switch left.Type() {
 		case types.I32:
-			result = b.currentBlock.NewSRem(loadInst, rightExpr)
+			result = b.currentBlock.NewSRem(left, rightExpr)
 		case types.Double:
-			result = b.currentBlock.NewFRem(loadInst, rightExpr)
+			result = b.currentBlock.NewFRem(left, rightExpr)
 		}
 	default:
 		panic(fmt.Sprintf("Unsupported assignment operator: %s", operator))
 	}
 
+	result = b.implicitConvert(result, varType, fmt.Sprintf("assignment to '%s'", name))
+
+	if len(node.Children) < 3 {
+		b.recordPrevious(name, loadInst)
+	}
 	b.currentBlock.NewStore(result, alloca)
-	b.locals[name] = alloca
 }
 
 func (b *Builder) generateConditional(node *ast.ASTNode) {
 	// Generate the condition expression
+	mark := len(b.temporaries)
 	condition := b.generateExpression(node.Children[0])
+	b.releaseTemporaries(mark)
 
 	// Create basic blocks
 	body := b.currentFunction.NewBlock(fmt.Sprintf("if.body.%d", len(b.blocks)))
@@ -525,14 +1079,18 @@ func (b *Builder) generateWhileStatement(node *ast.ASTNode) {
 
 	// Add loop trace
 	b.loops = append(b.loops, &LoopTrace{
-		condition: condition,
-		body:      body,
-		end:       end,
+		condition:     condition,
+		body:          body,
+		end:           end,
+		breakDepth:    len(b.scopes),
+		continueDepth: len(b.scopes),
 	})
 
-	// Generate condition block
+	// Generate condition block, which runs on every iteration and releases its temporaries each time
 	b.currentBlock = condition
+	mark := len(b.temporaries)
 	conditionExpr := b.generateExpression(node.Children[0])
+	b.releaseTemporaries(mark)
 	b.currentBlock.NewCondBr(conditionExpr, body, end)
 
 	// Generate body block
@@ -546,6 +1104,7 @@ func (b *Builder) generateWhileStatement(node *ast.ASTNode) {
 		b.currentBlock.NewBr(end)
 	}
 
+	b.loops = b.loops[:len(b.loops)-1]
 	b.currentBlock = end
 }
 
@@ -555,25 +1114,74 @@ func (b *Builder) generateBreakContinue(isContinue bool) {
 	}
 
 	var target *ir.Block
+	loop := b.loops[len(b.loops)-1]
 
 	if isContinue {
-		target = b.loops[len(b.loops)-1].condition
+		target = loop.condition
+		b.releaseLocals(loop.continueDepth)
 	} else {
-		target = b.loops[len(b.loops)-1].end
+		target = loop.end
+		b.releaseLocals(loop.breakDepth)
 	}
 
 	b.currentBlock.NewBr(target)
 }
 
-func getTypeFromName(name string) types.Type {
+// Strings are pointers to NUL-terminated character data, as in C
+var stringType = types.NewPointer(types.I8)
+
+func (b *Builder) getTypeFromName(name string) types.Type {
 	switch name {
 	case "int":
 		return types.I32
+	case "char":
+		return types.I8
+	case "bool":
+		return types.I1
+	case "string":
+		return stringType
 	case "float":
 		return types.Double
 	case "void":
 		return types.Void
-	default:
-		panic(fmt.Sprintf("Unsupported type: %s", name))
 	}
+
+	if s, ok := b.structs[name]; ok {
+		return s.typ
+	}
+
+	if backing, ok := b.enums[name]; ok {
+		return backing
+	}
+
+	if u, ok := b.unions[name]; ok {
+		return u.typ
+	}
+
+	if c, ok := b.classes[name]; ok {
+		return types.NewPointer(c.typ)
+	}
+
+	if i, ok := b.interfaces[name]; ok {
+		return i.typ
+	}
+
+	if pointee, ok := ast.Pointee(name); ok {
+		return b.pointerType(pointee)
+	}
+
+	if element, ok := ast.Element(name); ok {
+		return b.arrayType(element)
+	}
+
+	if ret, params, ok := ast.ParseFunctionType(name); ok {
+		paramTypes := make([]types.Type, len(params))
+		for i, param := range params {
+			paramTypes[i] = b.getTypeFromName(param)
+		}
+
+		return closureType(b.getTypeFromName(ret), paramTypes)
+	}
+
+	panic(fmt.Sprintf("Unsupported type: %s", name))
 }
@@ -0,0 +1,123 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// null has no pointee of its own, it is converted to whatever pointer it is used as
+var nullPointer = constant.NewNull(types.NewPointer(types.I8))
+
+func isPointerType(t types.Type) bool {
+	_, ok := t.(*types.PointerType)
+	return ok
+}
+
+// isPointerNode reports whether node, already analyzed, is a pointer. Strings share the LLVM type of
+// char* but are not pointers to the language
+func isPointerNode(node *ast.ASTNode) bool {
+	_, ok := ast.Pointee(node.ResolvedType)
+	return ok || node.ResolvedType == "null"
+}
+
+// pointerType lowers `T*`, with void* being a pointer to bytes as in C
+func (b *Builder) pointerType(pointee string) types.Type {
+	if pointee == "void" {
+		return types.NewPointer(types.I8)
+	}
+
+	return types.NewPointer(b.getTypeFromName(pointee))
+}
+
+// convertPointer converts between pointers and to and from integers. Integers are sign extended to
+// the width of an address first
+func (b *Builder) convertPointer(val value.Value, target types.Type) value.Value {
+	from := val.Type()
+
+	switch {
+	case isPointerType(from) && isPointerType(target):
+		if _, ok := val.(*constant.Null); ok {
+			return constant.NewNull(target.(*types.PointerType))
+		}
+
+		return b.currentBlock.NewBitCast(val, target)
+	case isPointerType(from) && target.Equal(types.I1):
+		return b.currentBlock.NewICmp(enum.IPredNE, val, constant.NewNull(from.(*types.PointerType)))
+	case isPointerType(from) && isIntegerType(target):
+		return b.currentBlock.NewPtrToInt(val, target)
+	case isIntegerType(from) && isPointerType(target):
+		return b.currentBlock.NewIntToPtr(b.convertValue(val, types.I64), target)
+	}
+
+	panic(fmt.Sprintf("Cannot convert %s to %s", typeName(from), typeName(target)))
+}
+
+// generateDereference reads the value a pointer points to
+func (b *Builder) generateDereference(node *ast.ASTNode) value.Value {
+	ptr := b.generateExpression(node.Children[0])
+	return b.currentBlock.NewLoad(ptr.Type().(*types.PointerType).ElemType, ptr)
+}
+
+// movePointer advances ptr by offset elements, or goes back by them
+func (b *Builder) movePointer(ptr, offset value.Value, back bool) value.Value {
+	offset = b.convertValue(offset, types.I64)
+	if back {
+		offset = b.currentBlock.NewSub(constant.NewInt(types.I64, 0), offset)
+	}
+
+	return b.currentBlock.NewGetElementPtr(ptr.Type().(*types.PointerType).ElemType, ptr, offset)
+}
+
+// generatePointerOperation emits a binary operator with a pointer operand. Sema has checked the
+// operands, null and void* are brought to the type of the other side before comparing
+func (b *Builder) generatePointerOperation(node *ast.ASTNode, left, right value.Value) value.Value {
+	switch node.Name {
+	case "+":
+		if !isPointerType(left.Type()) {
+			left, right = right, left
+		}
+
+		return b.movePointer(left, right, false)
+	case "-":
+		if !isPointerType(right.Type()) {
+			return b.movePointer(left, right, true)
+		}
+
+		// The distance in elements, which always divides exactly
+		elemSize, _ := b.sizeAlign(left.Type().(*types.PointerType).ElemType)
+		distance := b.currentBlock.NewSub(b.currentBlock.NewPtrToInt(left, types.I64), b.currentBlock.NewPtrToInt(right, types.I64))
+		elements := b.currentBlock.NewSDiv(distance, constant.NewInt(types.I64, int64(elemSize)))
+		elements.Exact = true
+
+		return b.currentBlock.NewTrunc(elements, types.I32)
+	}
+
+	if !left.Type().Equal(right.Type()) {
+		if _, ok := left.(*constant.Null); ok {
+			left = b.convertPointer(left, right.Type())
+		} else {
+			right = b.convertPointer(right, left.Type())
+		}
+	}
+
+	predicates := map[string]enum.IPred{
+		"==": enum.IPredEQ,
+		"!=": enum.IPredNE,
+		"<":  enum.IPredULT,
+		"<=": enum.IPredULE,
+		">":  enum.IPredUGT,
+		">=": enum.IPredUGE,
+	}
+
+	pred, ok := predicates[node.Name]
+	if !ok {
+		panic(fmt.Sprintf("Unsupported pointer operator: %s", node.Name))
+	}
+
+	return b.currentBlock.NewICmp(pred, left, right)
+}
@@ -0,0 +1,581 @@
+package builder
+
+import (
+	"strings"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// Instances and arrays are reference counted. Every variable, field, element and global holding a
+// reference owns one count, taken with a retain when the reference is stored and given back with a
+// release when it is replaced or the variable goes out of scope. Expressions producing a new reference,
+// such as `New` or a call, hand their count to the statement, which releases it at its end unless the
+// reference was stored. Weak fields own no count and read as null once what they refer to is gone
+
+// isCounted reports whether values of t are counted references, pointers to an instance or an array,
+// interface values and function values, which refer to the environment of a lambda
+func (b *Builder) isCounted(t types.Type) bool {
+	if _, isClosure := closureSignature(t); isClosure || b.isInterfaceType(t) {
+		return true
+	}
+
+	p, ok := t.(*types.PointerType)
+	if !ok {
+		return false
+	}
+
+	s, ok := p.ElemType.(*types.StructType)
+	if !ok {
+		return false
+	}
+
+	if c, ok := b.classes[s.Name()]; ok {
+		return c.typ == s
+	}
+
+	if s.Name() != "" || len(s.Fields) != 2 || !s.Fields[0].Equal(types.I64) {
+		return false
+	}
+
+	elements, ok := s.Fields[1].(*types.ArrayType)
+	return ok && elements.Len == 0
+}
+
+// runtimeFunction returns the runtime function name, generating it with body on first use
+func (b *Builder) runtimeFunction(name string, retType types.Type, body func(fn *ir.Func), params ...*ir.Param) *ir.Func {
+	if fn, ok := b.functions[name]; ok {
+		return fn
+	}
+
+	fn := b.module.NewFunc(name, retType, params...)
+	b.functions[name] = fn
+	body(fn)
+
+	return fn
+}
+
+// unlessNull starts fn with a check of its first parameter, returning right away for null. The
+// returned block continues with a reference that is not null
+func unlessNull(fn *ir.Func) *ir.Block {
+	entry := fn.NewBlock("entry")
+	counted := fn.NewBlock("counted")
+	done := fn.NewBlock("done")
+
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, fn.Params[0], constant.NewNull(bytePointer)), done, counted)
+	done.NewRet(nil)
+
+	return counted
+}
+
+// adjust adds delta to one of the counts in header and returns the new count
+func adjust(block *ir.Block, header value.Value, field int64, delta int64) value.Value {
+	slot := headerField(block, header, field)
+	count := block.NewAdd(block.NewLoad(types.I32, slot), constant.NewInt(types.I32, delta))
+	block.NewStore(count, slot)
+
+	return count
+}
+
+func (b *Builder) retainFunction() *ir.Func {
+	return b.runtimeFunction("__velox_retain", types.Void, func(fn *ir.Func) {
+		block := unlessNull(fn)
+		adjust(block, headerOf(block, fn.Params[0]), headerStrong, 1)
+		block.NewRet(nil)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// releaseFunction returns the runtime function giving back a count. The last one drops the references
+// the block holds and frees it, unless weak references still point to it. The drop runs with an extra
+// weak count, so weak fields of the block referring to the block itself cannot free it halfway
+func (b *Builder) releaseFunction() *ir.Func {
+	return b.runtimeFunction("__velox_release", types.Void, func(fn *ir.Func) {
+		memory := fn.Params[0]
+		block := unlessNull(fn)
+		destroy := fn.NewBlock("destroy")
+		drop := fn.NewBlock("drop")
+		dropped := fn.NewBlock("dropped")
+		free := fn.NewBlock("free")
+		done := fn.Blocks[2]
+
+		header := headerOf(block, memory)
+		strong := adjust(block, header, headerStrong, -1)
+		block.NewCondBr(block.NewICmp(enum.IPredEQ, strong, constant.NewInt(types.I32, 0)), destroy, done)
+
+		adjust(destroy, header, headerWeak, 1)
+		dropper := destroy.NewLoad(dropType, headerField(destroy, header, headerDrop))
+		destroy.NewCondBr(destroy.NewICmp(enum.IPredEQ, dropper, constant.NewNull(dropType)), dropped, drop)
+
+		drop.NewCall(dropper, memory)
+		drop.NewBr(dropped)
+
+		weak := adjust(dropped, header, headerWeak, -1)
+		dropped.NewCondBr(dropped.NewICmp(enum.IPredEQ, weak, constant.NewInt(types.I32, 0)), free, done)
+
+		free.NewCall(b.freeFunction(), memory)
+		free.NewBr(done)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+func (b *Builder) retainWeakFunction() *ir.Func {
+	return b.runtimeFunction("__velox_retain_weak", types.Void, func(fn *ir.Func) {
+		block := unlessNull(fn)
+		adjust(block, headerOf(block, fn.Params[0]), headerWeak, 1)
+		block.NewRet(nil)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// releaseWeakFunction returns the runtime function giving back a weak count, which frees a block that
+// was only kept for its weak references
+func (b *Builder) releaseWeakFunction() *ir.Func {
+	return b.runtimeFunction("__velox_release_weak", types.Void, func(fn *ir.Func) {
+		memory := fn.Params[0]
+		block := unlessNull(fn)
+		free := fn.NewBlock("free")
+		done := fn.Blocks[2]
+
+		header := headerOf(block, memory)
+		weak := adjust(block, header, headerWeak, -1)
+		strong := block.NewLoad(types.I32, headerField(block, header, headerStrong))
+		unused := block.NewOr(weak, strong)
+		block.NewCondBr(block.NewICmp(enum.IPredEQ, unused, constant.NewInt(types.I32, 0)), free, done)
+
+		free.NewCall(b.freeFunction(), memory)
+		free.NewBr(done)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// loadWeakFunction returns the runtime function reading a weak reference, which is null once what it
+// refers to has been released
+func (b *Builder) loadWeakFunction() *ir.Func {
+	return b.runtimeFunction("__velox_load_weak", bytePointer, func(fn *ir.Func) {
+		memory := fn.Params[0]
+		entry := fn.NewBlock("entry")
+		counted := fn.NewBlock("counted")
+		done := fn.NewBlock("done")
+
+		entry.NewCondBr(entry.NewICmp(enum.IPredEQ, memory, constant.NewNull(bytePointer)), done, counted)
+
+		strong := counted.NewLoad(types.I32, headerField(counted, headerOf(counted, memory), headerStrong))
+		alive := counted.NewICmp(enum.IPredNE, strong, constant.NewInt(types.I32, 0))
+		counted.NewRet(counted.NewSelect(alive, memory, constant.NewNull(bytePointer)))
+
+		done.NewRet(constant.NewNull(bytePointer))
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// dropFunction returns the function releasing the references held by the fields of an instance of c,
+// or null when it holds none
+func (b *Builder) dropFunction(c *classType) constant.Constant {
+	var counted []int
+	for i, field := range c.typ.Fields {
+		if b.isCounted(field) {
+			counted = append(counted, i)
+		}
+	}
+
+	if len(counted) == 0 {
+		return constant.NewNull(dropType)
+	}
+
+	return b.runtimeFunction("__velox_drop."+c.typ.Name(), types.Void, func(fn *ir.Func) {
+		entry := fn.NewBlock("entry")
+		instance := entry.NewBitCast(fn.Params[0], types.NewPointer(c.typ))
+
+		for _, i := range counted {
+			slot := entry.NewGetElementPtr(c.typ, instance, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			release := b.releaseFunction()
+			if c.weak[c.fields[i]] {
+				release = b.releaseWeakFunction()
+			}
+
+			entry.NewCall(release, b.memoryOf(entry, entry.NewLoad(c.typ.Fields[i], slot)))
+		}
+
+		entry.NewRet(nil)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// elementsDrop returns the function releasing the elements of an array of element, or null when they
+// are not counted
+func (b *Builder) elementsDrop(element types.Type) constant.Constant {
+	if !b.isCounted(element) {
+		return constant.NewNull(dropType)
+	}
+
+	// Interface and function values are wider than a pointer, so arrays of them get functions of their own
+	name, stored := "__velox_drop_elements", types.Type(bytePointer)
+	if b.isInterfaceType(element) {
+		name, stored = "__velox_drop_interfaces", element
+	} else if _, isClosure := closureSignature(element); isClosure {
+		name, stored = "__velox_drop_closures", element
+	}
+
+	return b.runtimeFunction(name, types.Void, func(fn *ir.Func) {
+		layout := types.NewStruct(types.I64, types.NewArray(0, stored))
+		entry := fn.NewBlock("entry")
+		loop := fn.NewBlock("loop")
+		body := fn.NewBlock("body")
+		done := fn.NewBlock("done")
+
+		zero := constant.NewInt(types.I32, 0)
+		array := entry.NewBitCast(fn.Params[0], types.NewPointer(layout))
+		length := entry.NewLoad(types.I64, entry.NewGetElementPtr(layout, array, zero, zero))
+		entry.NewBr(loop)
+
+		index := loop.NewPhi(ir.NewIncoming(constant.NewInt(types.I64, 0), entry))
+		loop.NewCondBr(loop.NewICmp(enum.IPredULT, index, length), body, done)
+
+		slot := body.NewGetElementPtr(layout, array, zero, constant.NewInt(types.I32, 1), index)
+		body.NewCall(b.releaseFunction(), b.memoryOf(body, body.NewLoad(stored, slot)))
+		index.Incs = append(index.Incs, ir.NewIncoming(body.NewAdd(index, constant.NewInt(types.I64, 1)), body))
+		body.NewBr(loop)
+
+		done.NewRet(nil)
+	}, ir.NewParam("memory", bytePointer))
+}
+
+// retain takes a count of the reference val
+func (b *Builder) retain(val value.Value) {
+	if isNullConstant(val) {
+		return
+	}
+
+	if memory := b.memoryOf(b.currentBlock, val); !isNullConstant(memory) {
+		b.currentBlock.NewCall(b.retainFunction(), memory)
+	}
+}
+
+// release gives back a count of the reference val
+func (b *Builder) release(val value.Value) {
+	if isNullConstant(val) {
+		return
+	}
+
+	if memory := b.memoryOf(b.currentBlock, val); !isNullConstant(memory) {
+		b.currentBlock.NewCall(b.releaseFunction(), memory)
+	}
+}
+
+// memoryOf returns the memory the reference val refers to as i8*, for an interface value the memory of
+// the instance or struct it holds and for a function value its environment
+func (b *Builder) memoryOf(block *ir.Block, val value.Value) value.Value {
+	field := -1
+	if b.isInterfaceType(val.Type()) {
+		field = 0
+	} else if _, isClosure := closureSignature(val.Type()); isClosure {
+		field = 1
+	}
+
+	// A named function has no environment, its closure is a constant
+	if field >= 0 {
+		if known, ok := val.(*constant.Struct); ok {
+			return known.Fields[field]
+		}
+
+		return block.NewExtractValue(val, uint64(field))
+	}
+
+	if val.Type().Equal(bytePointer) {
+		return val
+	}
+
+	return block.NewBitCast(val, bytePointer)
+}
+
+// nullOf is the reference of type t that refers to nothing
+func nullOf(t types.Type) constant.Constant {
+	if p, ok := t.(*types.PointerType); ok {
+		return constant.NewNull(p)
+	}
+
+	return constant.NewZeroInitializer(t)
+}
+
+// isNullConstant reports whether val is a reference known to be null at compile time
+func isNullConstant(val value.Value) bool {
+	switch val.(type) {
+	case *constant.Null, *constant.ZeroInitializer:
+		return true
+	}
+
+	return false
+}
+
+// temporary records that val, a new reference, owns a count the current statement has to give back
+func (b *Builder) temporary(val value.Value) value.Value {
+	b.temporaries = append(b.temporaries, val)
+	return val
+}
+
+// result records the value of a call as a temporary when it is a reference, which the callee returns
+// with a count for the caller
+func (b *Builder) result(val value.Value) value.Value {
+	if b.isCounted(val.Type()) {
+		b.temporary(val)
+	}
+
+	return val
+}
+
+// own returns val holding a count of its own, ready to be stored. A temporary hands over its count,
+// anything else is retained
+func (b *Builder) own(val value.Value) value.Value {
+	for i := len(b.temporaries) - 1; i >= 0; i-- {
+		if b.temporaries[i] == val {
+			b.temporaries = append(b.temporaries[:i], b.temporaries[i+1:]...)
+			return val
+		}
+	}
+
+	b.retain(val)
+	return val
+}
+
+// borrow keeps the reference passed as an argument alive during a call. Temporaries live until the end
+// of the statement and locals that are never written cannot change under the callee, anything else,
+// such as a global or a field, could be replaced by the callee and is retained until the statement ends
+func (b *Builder) borrow(node *ast.ASTNode, val value.Value) {
+	if !b.isCounted(val.Type()) {
+		return
+	}
+
+	if isNullConstant(val) {
+		return
+	}
+
+	for _, temporary := range b.temporaries {
+		if temporary == val {
+			return
+		}
+	}
+
+	if node.Type == ast.Identifier {
+		if binding, ok := b.lookupLocal(node.Name); ok && !binding.written {
+			return
+		}
+	}
+
+	b.retain(val)
+	b.temporary(val)
+}
+
+// releaseTemporaries gives back the counts of the temporaries recorded since mark
+func (b *Builder) releaseTemporaries(mark int) {
+	for i := len(b.temporaries) - 1; i >= mark; i-- {
+		b.release(b.temporaries[i])
+	}
+
+	b.temporaries = b.temporaries[:mark]
+}
+
+// releaseLocals gives back the references held by the locals of the scopes from depth on, the
+// innermost and latest declared first. The scopes stay open, as for a return or break
+func (b *Builder) releaseLocals(depth int) {
+	for i := len(b.scopes) - 1; i >= depth; i-- {
+		counted := b.scopes[i].counted
+		for j := len(counted) - 1; j >= 0; j-- {
+			b.release(b.readVariable(counted[j]))
+		}
+	}
+}
+
+// leaveScope closes the innermost scope, releasing its locals if its end can be reached
+func (b *Builder) leaveScope() {
+	if b.currentBlock.Term == nil {
+		b.releaseLocals(len(b.scopes) - 1)
+	}
+
+	b.popScope()
+}
+
+// storeReference stores the reference val in slot, giving back the count of the one it replaces. A weak
+// slot only takes a weak count, val stays owned by whoever held it
+func (b *Builder) storeReference(val, slot value.Value, weak bool) {
+	old := b.currentBlock.NewLoad(slot.Type().(*types.PointerType).ElemType, slot)
+
+	if !weak {
+		b.currentBlock.NewStore(b.own(val), slot)
+		b.release(old)
+		return
+	}
+
+	if _, isNull := val.(*constant.Null); !isNull {
+		b.currentBlock.NewCall(b.retainWeakFunction(), b.currentBlock.NewBitCast(val, bytePointer))
+	}
+
+	b.currentBlock.NewStore(val, slot)
+	b.currentBlock.NewCall(b.releaseWeakFunction(), b.currentBlock.NewBitCast(old, bytePointer))
+}
+
+// isWeak reports whether target is a weak field of an instance
+func (b *Builder) isWeak(target *ast.ASTNode) bool {
+	if target.Type != ast.MemberAccess {
+		return false
+	}
+
+	c := b.classes[target.Children[0].ResolvedType]
+	return c != nil && c.weak[target.Name]
+}
+
+// loadWeak reads the weak reference in slot, null once what it referred to is gone
+func (b *Builder) loadWeak(slot value.Value) value.Value {
+	t := slot.Type().(*types.PointerType).ElemType
+	memory := b.currentBlock.NewBitCast(b.currentBlock.NewLoad(t, slot), bytePointer)
+
+	return b.currentBlock.NewBitCast(b.currentBlock.NewCall(b.loadWeakFunction(), memory), t)
+}
+
+// elideRetains removes a retain followed in the same block by a release of the same reference, when
+// nothing in between can release anything else. Whoever held the reference before the retain still
+// does after the release, so the pair has no effect. Loads of a local slot are traced back to the value
+// last stored in it, so a reference stored in a local and released at the end of its scope is found
+func (b *Builder) elideRetains(fn *ir.Func) {
+	removed := make(map[ir.Instruction]bool)
+
+	for _, block := range fn.Blocks {
+		roots := make(map[value.Value]value.Value)
+		stored := make(map[value.Value]value.Value)
+		root := func(v value.Value) value.Value {
+			if r, ok := roots[v]; ok {
+				return r
+			}
+
+			return v
+		}
+
+		var pending []*ir.InstCall
+
+		for _, inst := range block.Insts {
+			switch inst := inst.(type) {
+			case *ir.InstBitCast:
+				roots[inst] = root(inst.From)
+			case *ir.InstExtractValue:
+				roots[inst] = root(inst.X)
+			case *ir.InstLoad:
+				if _, isSlot := inst.Src.(*ir.InstAlloca); isSlot {
+					if v, ok := stored[inst.Src]; ok {
+						roots[inst] = v
+					} else {
+						stored[inst.Src] = inst
+					}
+				}
+			case *ir.InstStore:
+				if _, isSlot := inst.Dst.(*ir.InstAlloca); isSlot {
+					stored[inst.Dst] = root(inst.Src)
+				} else {
+					stored = make(map[value.Value]value.Value)
+				}
+			case *ir.InstCall:
+				switch callee := calleeName(inst); {
+				case callee == "__velox_retain":
+					pending = append(pending, inst)
+				case callee == "__velox_release":
+					matched := false
+					for i, retain := range pending {
+						if root(retain.Args[0]) == root(inst.Args[0]) {
+							removed[retain], removed[inst] = true, true
+							pending = append(pending[:i], pending[i+1:]...)
+							matched = true
+							break
+						}
+					}
+
+					if !matched {
+						pending = nil
+					}
+				case !harmless(callee):
+					pending = nil
+					stored = make(map[value.Value]value.Value)
+				}
+			}
+		}
+
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+
+	removeUnused(fn, removed)
+	for _, block := range fn.Blocks {
+		kept := block.Insts[:0]
+		for _, inst := range block.Insts {
+			if !removed[inst] {
+				kept = append(kept, inst)
+			}
+		}
+		block.Insts = kept
+	}
+}
+
+// removeUnused adds to removed the casts, extractions and loads that only fed the calls being removed
+func removeUnused(fn *ir.Func, removed map[ir.Instruction]bool) {
+	uses := make(map[value.Value]int)
+	var unused []value.Value
+
+	for _, block := range fn.Blocks {
+		for _, inst := range block.Insts {
+			for _, operand := range inst.Operands() {
+				if removed[inst] {
+					unused = append(unused, *operand)
+				} else {
+					uses[*operand]++
+				}
+			}
+		}
+
+		if block.Term != nil {
+			for _, operand := range block.Term.Operands() {
+				uses[*operand]++
+			}
+		}
+	}
+
+	for len(unused) > 0 {
+		v := unused[len(unused)-1]
+		unused = unused[:len(unused)-1]
+
+		if uses[v] > 0 {
+			continue
+		}
+
+		switch inst := v.(type) {
+		case *ir.InstBitCast:
+			removed[inst] = true
+			uses[inst.From]--
+			unused = append(unused, inst.From)
+		case *ir.InstExtractValue:
+			removed[inst] = true
+			uses[inst.X]--
+			unused = append(unused, inst.X)
+		case *ir.InstLoad:
+			removed[inst] = true
+		}
+	}
+}
+
+func calleeName(call *ir.InstCall) string {
+	if fn, ok := call.Callee.(*ir.Func); ok {
+		return fn.Name()
+	}
+
+	return ""
+}
+
+// harmless reports whether a call to callee cannot release a reference: printf and the runtime, apart
+// from what frees memory
+func harmless(callee string) bool {
+	switch {
+	case callee == "printf", callee == "__velox_release_weak":
+		return true
+	case callee == "__velox_free", strings.HasPrefix(callee, "__velox_drop"):
+		return false
+	}
+
+	return strings.HasPrefix(callee, "__velox_")
+}
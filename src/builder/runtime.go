@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+)
+
+// goldenRatio is 0x9E3779B97F4A7C15 as a signed 64-bit integer
+const goldenRatio = -0x61C8864680B583EB
+
+// externalFunction declares a libc function the runtime relies on, unless the program already did
+func (b *Builder) externalFunction(name string, retType types.Type, params ...types.Type) *ir.Func {
+	if fn, ok := b.functions[name]; ok {
+		return fn
+	}
+
+	var irParams []*ir.Param
+	for _, param := range params {
+		irParams = append(irParams, ir.NewParam("", param))
+	}
+
+	fn := b.module.NewFunc(name, retType, irParams...)
+	b.functions[name] = fn
+	return fn
+}
+
+// randomFunction returns the runtime's xorshift64 generator, emitting it on first use. With --seed the
+// state starts from the seed, otherwise it is seeded from the clock on the first call
+func (b *Builder) randomFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_random"]; ok {
+		return fn
+	}
+
+	var initial int64 // Zero means not seeded yet
+	if b.seeded {
+		initial = splitmix(b.seed)
+	}
+
+	state := b.module.NewGlobalDef("__velox_rng_state", constant.NewInt(types.I64, initial))
+
+	fn := b.module.NewFunc("__velox_random", types.I64)
+	b.functions["__velox_random"] = fn
+
+	entry := fn.NewBlock("entry")
+	seed := fn.NewBlock("seed")
+	next := fn.NewBlock("next")
+
+	current := entry.NewLoad(types.I64, state)
+	entry.NewCondBr(entry.NewICmp(enum.IPredEQ, current, constant.NewInt(types.I64, 0)), seed, next)
+
+	// The clock is spread over all bits and forced odd, xorshift must never start from zero
+	timeFn := b.externalFunction("time", types.I64, types.NewPointer(types.I64))
+	now := seed.NewCall(timeFn, constant.NewNull(types.NewPointer(types.I64)))
+	seeded := seed.NewOr(seed.NewMul(now, constant.NewInt(types.I64, goldenRatio)), constant.NewInt(types.I64, 1))
+	seed.NewBr(next)
+
+	x := next.NewPhi(ir.NewIncoming(current, entry), ir.NewIncoming(seeded, seed))
+	x1 := next.NewXor(x, next.NewShl(x, constant.NewInt(types.I64, 13)))
+	x2 := next.NewXor(x1, next.NewLShr(x1, constant.NewInt(types.I64, 7)))
+	x3 := next.NewXor(x2, next.NewShl(x2, constant.NewInt(types.I64, 17)))
+	next.NewStore(x3, state)
+	next.NewRet(x3)
+
+	return fn
+}
+
+// maybeFunction returns the runtime helper behind `maybe`, which takes the top bit of the generator
+func (b *Builder) maybeFunction() *ir.Func {
+	if fn, ok := b.functions["__velox_maybe"]; ok {
+		return fn
+	}
+
+	fn := b.module.NewFunc("__velox_maybe", types.I1)
+	b.functions["__velox_maybe"] = fn
+
+	entry := fn.NewBlock("entry")
+	random := entry.NewCall(b.randomFunction())
+	entry.NewRet(entry.NewTrunc(entry.NewLShr(random, constant.NewInt(types.I64, 63)), types.I1))
+
+	return fn
+}
+
+// splitmix spreads a user seed over all 64 bits, so small seeds like 1 and 2 give unrelated sequences
+func splitmix(seed int64) int64 {
+	z := uint64(seed) + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+
+	if z == 0 {
+		return 1
+	}
+
+	return int64(z)
+}
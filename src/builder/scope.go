@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// Binding is what a variable name resolves to. Mutable variables are stored in a slot (an alloca or
+// global) and read through loads, const scalars may instead be bound directly to their SSA value
+type Binding struct {
+	value    value.Value
+	direct   bool
+	isConst  bool
+	previous value.Value // Shadow slot holding the value before the last store, only when ::prev is read
+	counted  bool        // Holds a reference whose count the scope gives back when it ends
+	written  bool        // Written after its declaration, so a call could see it change
+}
+
+// Scope holds the locals declared directly inside one ast.Block (or the parameters of a function)
+type Scope struct {
+	locals  map[string]*Binding
+	counted []*Binding // Locals holding a reference, in the order they were declared
+}
+
+func (b *Builder) pushScope() {
+	b.scopes = append(b.scopes, &Scope{locals: make(map[string]*Binding)})
+}
+
+func (b *Builder) popScope() {
+	b.scopes = b.scopes[:len(b.scopes)-1]
+}
+
+// declareLocal binds name in the innermost scope. Redeclaring a name in the same scope is an error,
+// declaring it in an inner scope shadows the outer binding until that scope ends
+func (b *Builder) declareLocal(name string, binding *Binding) {
+	scope := b.scopes[len(b.scopes)-1]
+
+	if _, exists := scope.locals[name]; exists {
+		panic(fmt.Sprintf("Redeclaration of '%s' in the same scope", name))
+	}
+
+	scope.locals[name] = binding
+	if binding.counted {
+		scope.counted = append(scope.counted, binding)
+	}
+}
+
+// trackPrevious gives binding a shadow slot for ::prev, starting out equal to the variable itself
+func (b *Builder) trackPrevious(name string, binding *Binding, initial value.Value) {
+	binding.previous = b.newLocalSlot(name+".prev", binding.value.Type().(*types.PointerType).ElemType)
+
+	if initial != nil {
+		b.currentBlock.NewStore(initial, binding.previous)
+	}
+}
+
+// recordPrevious saves old, the value a variable held before a store, for later ::prev reads
+func (b *Builder) recordPrevious(name string, old value.Value) {
+	if binding, ok := b.lookupVariable(name); ok && binding.previous != nil {
+		b.currentBlock.NewStore(old, binding.previous)
+	}
+}
+
+func (b *Builder) lookupLocal(name string) (*Binding, bool) {
+	for i := len(b.scopes) - 1; i >= 0; i-- {
+		if val, ok := b.scopes[i].locals[name]; ok {
+			return val, true
+		}
+	}
+
+	return nil, false
+}
+
+// newLocalSlot allocates stack space for a local in the entry block, so declarations inside loops
+// reuse one slot instead of growing the stack on every iteration
+func (b *Builder) newLocalSlot(name string, elemType types.Type) *ir.InstAlloca {
+	entry := b.currentFunction.Blocks[0]
+
+	alloca := ir.NewAlloca(elemType)
+
+	// Shadowed names get a numeric suffix so every slot in the function has a unique name
+	if count := b.slotNames[name]; count > 0 {
+		alloca.SetName(fmt.Sprintf("%s.%d", name, count))
+	} else {
+		alloca.SetName(name)
+	}
+	b.slotNames[name]++
+
+	insts := make([]ir.Instruction, 0, len(entry.Insts)+1)
+	insts = append(insts, entry.Insts[:b.entrySlots]...)
+	insts = append(insts, alloca)
+	entry.Insts = append(insts, entry.Insts[b.entrySlots:]...)
+	b.entrySlots++
+
+	return alloca
+}
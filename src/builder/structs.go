@@ -0,0 +1,188 @@
+package builder
+
+import (
+	"fmt"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// structType is a declared struct, lowered to a named LLVM struct with the fields in declaration order
+type structType struct {
+	typ    *types.StructType
+	fields []string
+}
+
+// declareStructs adds every struct type to the module. All names exist before any field type is
+// looked up, so structs can hold structs declared after them
+func (b *Builder) declareStructs(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type == ast.StructDeclaration {
+			b.structs[child.Name] = &structType{typ: types.NewStruct()}
+			b.module.NewTypeDef(child.Name, b.structs[child.Name].typ)
+		}
+	}
+
+	for _, child := range declarations {
+		if child.Type == ast.StructDeclaration {
+			s := b.structs[child.Name]
+
+			for _, field := range child.Children {
+				if field.Type != ast.VariableDeclaration {
+					continue
+				}
+
+				s.typ.Fields = append(s.typ.Fields, b.getTypeFromName(field.Children[0].Name))
+				s.fields = append(s.fields, field.Name)
+			}
+		}
+	}
+}
+
+// fieldIndex finds the position of a field in the struct or class type t
+func (b *Builder) fieldIndex(t types.Type, name string) int {
+	var fields []string
+	if s, ok := b.structs[t.Name()]; ok {
+		fields = s.fields
+	} else if c, ok := b.classes[t.Name()]; ok {
+		fields = c.fields
+	}
+
+	for i, field := range fields {
+		if field == name {
+			return i
+		}
+	}
+
+	panic(fmt.Sprintf("%s has no field '%s'", t, name))
+}
+
+// generateStructLiteral builds a struct value. Fields without a value are zero
+func (b *Builder) generateStructLiteral(node *ast.ASTNode) value.Value {
+	t := b.structs[node.Name].typ
+	var result value.Value = constant.NewZeroInitializer(t)
+
+	for i, element := range node.Children {
+		index := i
+		if element.Type == ast.Assignment {
+			index, element = b.fieldIndex(t, element.Name), element.Children[1]
+		}
+
+		val := b.implicitConvert(b.generateExpression(element), t.Fields[index], fmt.Sprintf("field %d of '%s'", index+1, node.Name))
+		result = b.currentBlock.NewInsertValue(result, val, uint64(index))
+	}
+
+	return result
+}
+
+// constantStruct folds the struct literal initializing a global
+func (b *Builder) constantStruct(node *ast.ASTNode, t *types.StructType) constant.Constant {
+	fields := make([]constant.Constant, len(t.Fields))
+	for i, fieldType := range t.Fields {
+		fields[i] = constant.NewZeroInitializer(fieldType)
+	}
+
+	for i, element := range node.Children {
+		index := i
+		if element.Type == ast.Assignment {
+			index, element = b.fieldIndex(t, element.Name), element.Children[1]
+		}
+
+		if inner, ok := t.Fields[index].(*types.StructType); ok && element.Type == ast.StructLiteral {
+			fields[index] = b.constantStruct(element, inner)
+			continue
+		}
+
+		if element.Type == ast.UnionLiteral {
+			fields[index] = b.constantUnion(element)
+			continue
+		}
+
+		folded, err := ast.EvaluateConstant(element, b.constantLookup)
+		if err != nil {
+			panic(fmt.Sprintf("Field %d of '%s' is not constant: %v", index+1, node.Name, err))
+		}
+
+		fields[index] = b.constantFromValue(folded.Convert(typeName(t.Fields[index])), t.Fields[index])
+	}
+
+	return constant.NewStruct(t, fields...)
+}
+
+// generateFieldAccess reads a field out of a struct value, of the struct a pointer points to or of an
+// instance. The length of an array is stored as 64 bits but read as an int, a weak field reads as null
+// once what it referred to is gone
+func (b *Builder) generateFieldAccess(node *ast.ASTNode) value.Value {
+	if _, isArray := ast.Element(node.Children[0].ResolvedType); isArray {
+		return b.currentBlock.NewTrunc(b.arrayLength(b.generateExpression(node.Children[0])), types.I32)
+	}
+
+	if isPointerNode(node.Children[0]) || b.isReferenceNode(node.Children[0]) {
+		slot := b.targetSlot(node)
+		if b.isWeak(node) {
+			return b.loadWeak(slot)
+		}
+
+		return b.currentBlock.NewLoad(slot.Type().(*types.PointerType).ElemType, slot)
+	}
+
+	base := b.generateExpression(node.Children[0])
+	return b.currentBlock.NewExtractValue(base, uint64(b.fieldIndex(base.Type(), node.Name)))
+}
+
+// targetSlot returns the storage a write to a variable, one of its fields, a dereferenced pointer or an
+// element goes to
+func (b *Builder) targetSlot(node *ast.ASTNode) value.Value {
+	switch {
+	case node.Type == ast.UnaryExpression && node.Name == "*":
+		return b.generateExpression(node.Children[0])
+	case node.Type == ast.ArrayAccess:
+		return b.elementSlot(node)
+	case node.Type != ast.MemberAccess:
+		return b.variableSlot(node.Name)
+	}
+
+	// Fields of a struct behind a pointer, and those of an instance, are reached through the pointer's value
+	var base value.Value
+	if isPointerNode(node.Children[0]) || b.isReferenceNode(node.Children[0]) {
+		base = b.generateExpression(node.Children[0])
+	} else {
+		base = b.targetSlot(node.Children[0])
+	}
+
+	t := base.Type().(*types.PointerType).ElemType
+	index := constant.NewInt(types.I32, int64(b.fieldIndex(t, node.Name)))
+
+	return b.currentBlock.NewGetElementPtr(t, base, constant.NewInt(types.I32, 0), index)
+}
+
+// structEqual compares two structs of the same type field by field
+func (b *Builder) structEqual(left, right value.Value) value.Value {
+	var result value.Value = constant.True
+
+	for i, fieldType := range left.Type().(*types.StructType).Fields {
+		l := b.currentBlock.NewExtractValue(left, uint64(i))
+		r := b.currentBlock.NewExtractValue(right, uint64(i))
+
+		var equal value.Value
+		switch {
+		case isFloatType(fieldType):
+			equal = b.currentBlock.NewFCmp(enum.FPredOEQ, l, r)
+		case isIntegerType(fieldType) || isPointerType(fieldType):
+			equal = b.currentBlock.NewICmp(enum.IPredEQ, l, r)
+		default:
+			equal = b.structEqual(l, r)
+		}
+
+		if i == 0 {
+			result = equal
+		} else {
+			result = b.currentBlock.NewAnd(result, equal)
+		}
+	}
+
+	return result
+}
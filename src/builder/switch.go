@@ -0,0 +1,154 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// declareEnums records the backing type of every enum and defines its variants as constants named
+// `Enum.Variant`. Sema has folded every variant's value into a literal
+func (b *Builder) declareEnums(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type != ast.EnumDeclaration {
+			continue
+		}
+
+		backing := b.getTypeFromName(child.Children[0].Name).(*types.IntType)
+		b.enums[child.Name] = backing
+
+		for _, variant := range child.Children[1:] {
+			v, err := strconv.ParseInt(variant.Children[0].Name, 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("Value of '%s.%s' is not folded: %s", child.Name, variant.Name, variant.Children[0].Name))
+			}
+
+			b.globals[child.Name+"."+variant.Name] = constant.NewInt(backing, v)
+		}
+	}
+}
+
+// caseValue folds the label of a case to a constant of type t. A pattern gives the tag of its variant
+func (b *Builder) caseValue(label *ast.ASTNode, t types.Type) constant.Constant {
+	if label.Type == ast.UnionLiteral {
+		_, tag := b.variantOf(label.Name)
+		return constant.NewInt(t.(*types.IntType), int64(tag))
+	}
+
+	folded, err := ast.EvaluateConstant(label, b.constantLookup)
+	if err != nil {
+		panic(fmt.Sprintf("Case is not constant: %v", err))
+	}
+
+	return constant.NewInt(t.(*types.IntType), folded.Convert(typeName(t)).Int)
+}
+
+// generateSwitch lowers a switch to an LLVM switch. A break leaves the switch and a continue goes to the
+// enclosing loop, so the switch is pushed as a loop whose condition is that loop's
+func (b *Builder) generateSwitch(node *ast.ASTNode) {
+	mark := len(b.temporaries)
+	val, slot := b.scrutinee(node.Children[0])
+	b.releaseTemporaries(mark)
+	entry := b.currentBlock
+
+	end := b.currentFunction.NewBlock(fmt.Sprintf("switch.end.%d", len(b.blocks)))
+	b.blocks = append(b.blocks, end)
+
+	trace := &LoopTrace{end: end, breakDepth: len(b.scopes)}
+	if len(b.loops) > 0 {
+		trace.condition = b.loops[len(b.loops)-1].condition
+		trace.continueDepth = b.loops[len(b.loops)-1].continueDepth
+	}
+	b.loops = append(b.loops, trace)
+
+	fallback := end
+	var cases []*ir.Case
+
+	for _, c := range node.Children[1:] {
+		body := b.currentFunction.NewBlock(fmt.Sprintf("switch.case.%d", len(b.blocks)))
+		b.blocks = append(b.blocks, body)
+
+		if c.Name == "default" {
+			fallback = body
+		}
+
+		for _, label := range c.Children[1:] {
+			cases = append(cases, ir.NewCase(b.caseValue(label, val.Type()), body))
+		}
+
+		b.currentBlock = body
+		b.pushScope()
+		b.bindPattern(c, slot)
+		b.generateBlock(b.currentBlock, c.Children[0])
+		b.popScope()
+
+		if b.currentBlock.Term == nil {
+			b.currentBlock.NewBr(end)
+		}
+	}
+
+	entry.NewSwitch(val, fallback, cases...)
+	b.loops = b.loops[:len(b.loops)-1]
+	b.currentBlock = end
+}
+
+// generateMatch lowers a match to an LLVM switch whose arms meet in a phi. Sema checked that the arms
+// cover every variant, a value no arm handles can only come from a cast and aborts. Each arm releases
+// its own temporaries, a reference it results in is handed to the match with a count
+func (b *Builder) generateMatch(node *ast.ASTNode) value.Value {
+	val, slot := b.scrutinee(node.Children[0])
+	entry := b.currentBlock
+	resultType := b.getTypeFromName(node.ResolvedType)
+
+	end := b.currentFunction.NewBlock(fmt.Sprintf("match.end.%d", len(b.blocks)))
+	b.blocks = append(b.blocks, end)
+
+	var fallback *ir.Block
+	var cases []*ir.Case
+	var incoming []*ir.Incoming
+
+	for _, arm := range node.Children[1:] {
+		body := b.currentFunction.NewBlock(fmt.Sprintf("match.arm.%d", len(b.blocks)))
+		b.blocks = append(b.blocks, body)
+
+		if arm.Name == "default" {
+			fallback = body
+		}
+
+		for _, label := range arm.Children[1:] {
+			cases = append(cases, ir.NewCase(b.caseValue(label, val.Type()), body))
+		}
+
+		b.currentBlock = body
+		b.pushScope()
+		b.bindPattern(arm, slot)
+
+		mark := len(b.temporaries)
+		result := b.implicitConvert(b.generateExpression(arm.Children[0]), resultType, "match arm")
+		if b.isCounted(resultType) {
+			result = b.own(result)
+		}
+
+		b.releaseTemporaries(mark)
+		b.popScope()
+		incoming = append(incoming, ir.NewIncoming(result, b.currentBlock))
+		b.currentBlock.NewBr(end)
+	}
+
+	if fallback == nil {
+		fallback = b.currentFunction.NewBlock(fmt.Sprintf("match.none.%d", len(b.blocks)))
+		b.blocks = append(b.blocks, fallback)
+
+		fallback.NewCall(b.externalFunction("abort", types.Void))
+		fallback.NewUnreachable()
+	}
+
+	entry.NewSwitch(val, fallback, cases...)
+	b.currentBlock = end
+	return b.result(end.NewPhi(incoming...))
+}
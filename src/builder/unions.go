@@ -0,0 +1,201 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+	"velox.eparker.dev/src/ast"
+)
+
+// unionType is a declared union, lowered to a named LLVM struct holding the tag of the variant followed
+// by a payload big and aligned enough for the fields of any variant. Each variant views the payload as
+// a struct of its fields
+type unionType struct {
+	typ      *types.StructType
+	variants []string
+	payloads []*types.StructType
+}
+
+// declareUnions adds every union type to the module. The layout depends on the field types, so it
+// happens in layoutUnions once structs are declared
+func (b *Builder) declareUnions(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type == ast.UnionDeclaration {
+			b.unions[child.Name] = &unionType{typ: types.NewStruct()}
+			b.module.NewTypeDef(child.Name, b.unions[child.Name].typ)
+		}
+	}
+}
+
+// layoutUnions gives every union its tag and payload
+func (b *Builder) layoutUnions(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type != ast.UnionDeclaration {
+			continue
+		}
+
+		u := b.unions[child.Name]
+		for _, variant := range child.Children {
+			payload := types.NewStruct()
+			for _, field := range variant.Children {
+				payload.Fields = append(payload.Fields, b.getTypeFromName(field.Children[0].Name))
+			}
+
+			u.variants = append(u.variants, variant.Name)
+			u.payloads = append(u.payloads, payload)
+		}
+	}
+
+	for _, child := range declarations {
+		if child.Type == ast.UnionDeclaration {
+			b.layoutUnion(b.unions[child.Name])
+		}
+	}
+}
+
+// layoutUnion sizes the payload of u as an array of the widest alignment any variant needs. Unions
+// holding other unions lay those out first
+func (b *Builder) layoutUnion(u *unionType) {
+	if len(u.typ.Fields) > 0 {
+		return
+	}
+
+	size, align := uint64(0), uint64(1)
+	for _, payload := range u.payloads {
+		payloadSize, payloadAlign := b.sizeAlign(payload)
+		size = max(size, payloadSize)
+		align = max(align, payloadAlign)
+	}
+
+	chunk := types.NewInt(align * 8)
+	u.typ.Fields = []types.Type{types.I32, types.NewArray((size+align-1)/align, chunk)}
+}
+
+// sizeAlign returns the size and alignment of t in bytes on the x86-64 targets, following C's layout
+func (b *Builder) sizeAlign(t types.Type) (uint64, uint64) {
+	switch t := t.(type) {
+	case *types.IntType:
+		size := max((t.BitSize+7)/8, 1)
+		return size, size
+	case *types.FloatType:
+		return 8, 8
+	case *types.PointerType:
+		return 8, 8
+	case *types.ArrayType:
+		size, align := b.sizeAlign(t.ElemType)
+		return size * t.Len, align
+	case *types.StructType:
+		if u, ok := b.unions[t.Name()]; ok {
+			b.layoutUnion(u)
+		}
+
+		size, align := uint64(0), uint64(1)
+		for _, field := range t.Fields {
+			fieldSize, fieldAlign := b.sizeAlign(field)
+			size = (size+fieldAlign-1)/fieldAlign*fieldAlign + fieldSize
+			align = max(align, fieldAlign)
+		}
+
+		return (size + align - 1) / align * align, align
+	}
+
+	panic(fmt.Sprintf("Unknown size of %s", t))
+}
+
+// variantOf resolves `Shape.Circle` to its union and tag
+func (b *Builder) variantOf(name string) (*unionType, int) {
+	i := strings.LastIndex(name, ".")
+	if u, ok := b.unions[name[:max(i, 0)]]; ok {
+		for tag, variant := range u.variants {
+			if variant == name[i+1:] {
+				return u, tag
+			}
+		}
+	}
+
+	panic(fmt.Sprintf("Unknown union variant: %s", name))
+}
+
+// constantUnion builds a variant without fields, which needs no code
+func (b *Builder) constantUnion(node *ast.ASTNode) constant.Constant {
+	u, tag := b.variantOf(node.Name)
+	if len(node.Children) > 0 {
+		panic(fmt.Sprintf("'%s' has fields and is not constant", node.Name))
+	}
+
+	return constant.NewStruct(u.typ, constant.NewInt(types.I32, int64(tag)), constant.NewZeroInitializer(u.typ.Fields[1]))
+}
+
+// generateUnionLiteral builds a union value. The fields are stored through the payload of a stack slot,
+// viewed as the variant's struct
+func (b *Builder) generateUnionLiteral(node *ast.ASTNode) value.Value {
+	u, tag := b.variantOf(node.Name)
+	empty := constant.NewStruct(u.typ, constant.NewInt(types.I32, int64(tag)), constant.NewZeroInitializer(u.typ.Fields[1]))
+	if len(node.Children) == 0 {
+		return empty
+	}
+
+	slot := b.newLocalSlot(node.Name, u.typ)
+	b.currentBlock.NewStore(empty, slot)
+
+	payload := b.payload(slot, u, tag)
+	for i, arg := range node.Children {
+		val := b.implicitConvert(b.generateExpression(arg), u.payloads[tag].Fields[i], fmt.Sprintf("field %d of '%s'", i+1, node.Name))
+		b.currentBlock.NewStore(val, b.currentBlock.NewGetElementPtr(u.payloads[tag], payload, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i))))
+	}
+
+	return b.currentBlock.NewLoad(u.typ, slot)
+}
+
+// payload views the payload of the union in slot as the fields of the variant with the given tag
+func (b *Builder) payload(slot value.Value, u *unionType, tag int) value.Value {
+	bytes := b.currentBlock.NewGetElementPtr(u.typ, slot, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 1))
+	return b.currentBlock.NewBitCast(bytes, types.NewPointer(u.payloads[tag]))
+}
+
+// unionOf returns the union a value of type t holds, if it is one
+func (b *Builder) unionOf(t types.Type) *unionType {
+	if s, ok := t.(*types.StructType); ok {
+		return b.unions[s.Name()]
+	}
+
+	return nil
+}
+
+// scrutinee generates the value a switch or match branches on. A union branches on its tag and is kept
+// in a slot, where the patterns of the cases read its fields
+func (b *Builder) scrutinee(node *ast.ASTNode) (value.Value, value.Value) {
+	val := b.generateExpression(node)
+
+	if u := b.unionOf(val.Type()); u != nil {
+		slot := b.newLocalSlot("union", u.typ)
+		b.currentBlock.NewStore(val, slot)
+		return b.currentBlock.NewExtractValue(val, 0), slot
+	}
+
+	return val, nil
+}
+
+// bindPattern binds the names in the pattern of a case to the fields of the union in slot. The case
+// must have a single pattern, sema rejects bindings otherwise
+func (b *Builder) bindPattern(c *ast.ASTNode, slot value.Value) {
+	if slot == nil || len(c.Children) != 2 || len(c.Children[1].Children) == 0 {
+		return
+	}
+
+	pattern := c.Children[1]
+	u, tag := b.variantOf(pattern.Name)
+	payload := b.payload(slot, u, tag)
+
+	for i, binding := range pattern.Children {
+		if binding.Name == "_" {
+			continue
+		}
+
+		field := b.currentBlock.NewGetElementPtr(u.payloads[tag], payload, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+		b.declareLocal(binding.Name, &Binding{value: b.currentBlock.NewLoad(u.payloads[tag].Fields[i], field), direct: true, isConst: true})
+	}
+}
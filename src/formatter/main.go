@@ -0,0 +1,425 @@
+package formatter
+
+import (
+	"os"
+	"strings"
+
+	"velox.eparker.dev/src/tokenizer"
+)
+
+// element is one token of a statement, or a `{ ... }` block of statements nested in it
+type element struct {
+	token   tokenizer.Token
+	block   []*item
+	isBlock bool
+	ordered bool // The block lists the fields of a struct or class or the cases of a switch, which keep their order
+}
+
+// item is a statement, a directive line, an #if ... #endif group or a run of comments with nothing after them
+type item struct {
+	comments  []tokenizer.Token // Comments written above the item
+	trailing  []tokenizer.Token // Comments written after the item on the line it ends on
+	elements  []element
+	directive bool
+	spaced    bool      // Whether a blank line was written above the item
+	lines     []*item   // The directive lines of a group, from #if to #endif
+	branches  [][]*item // The items between each pair of lines
+}
+
+func (it *item) hasBlock() bool {
+	for _, e := range it.elements {
+		if e.isBlock {
+			return true
+		}
+	}
+
+	for _, branch := range it.branches {
+		for _, nested := range branch {
+			if nested.hasBlock() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Format re-emits source code with the statements of every block in the requested order. Files that
+// end with `oops` are reversed, so converting to reversed adds it and converting to normal drops it.
+// Top-level declarations and directive lines keep their place, as the parser reads them as written
+func Format(code string, reversed bool) string {
+	var tokens []tokenizer.Token
+	for _, token := range tokenizer.Tokenize(code, false) {
+		if token.Type != tokenizer.Whitespace {
+			tokens = append(tokens, token)
+		}
+	}
+
+	wasReversed := false
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if tokens[i].Type == tokenizer.Comment {
+			continue
+		}
+
+		if tokens[i].Type == tokenizer.Keyword && tokens[i].Value == "oops" {
+			wasReversed = true
+			tokens = append(tokens[:i:i], tokens[i+1:]...)
+		}
+
+		break
+	}
+
+	r := &reader{tokens: tokens}
+	items := r.items(false)
+
+	if wasReversed != reversed {
+		for _, it := range items {
+			reverseInside(it, false)
+		}
+	}
+
+	w := &writer{}
+	w.items(items, 0)
+
+	if reversed {
+		w.out.WriteString("\noops\n")
+	}
+
+	return w.out.String()
+}
+
+// FormatFile reads path and formats it
+func FormatFile(path string, reversed bool) (string, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return Format(string(code), reversed), nil
+}
+
+type reader struct {
+	tokens       []tokenizer.Token
+	pos          int
+	conditionals int // How many #if groups are open
+}
+
+// items reads statements up to the end of the input, up to the `}` closing a nested block or up to
+// the directive ending a branch of an #if group
+func (r *reader) items(nested bool) []*item {
+	var items []*item
+	var comments []tokenizer.Token
+	spaced := false
+
+	for r.pos < len(r.tokens) {
+		token := r.tokens[r.pos]
+
+		if nested && token.Value == "}" && token.Type == tokenizer.Punctuation {
+			break
+		}
+
+		if r.conditionals > 0 && token.Type == tokenizer.Preprocessor && (token.Value == "#elif" || token.Value == "#else" || token.Value == "#endif") {
+			break
+		}
+
+		if len(comments) == 0 {
+			spaced = r.pos > 0 && token.Line > r.endLine(r.pos-1)+1
+		}
+
+		// A comment on the line a statement ends on belongs to that statement and moves with it
+		if token.Type == tokenizer.Comment && len(comments) == 0 && len(items) > 0 && token.Line == r.endLine(r.pos-1) {
+			items[len(items)-1].trailing = append(items[len(items)-1].trailing, token)
+			r.pos++
+			continue
+		}
+
+		if token.Type == tokenizer.Comment {
+			comments = append(comments, token)
+			r.pos++
+			continue
+		}
+
+		var it *item
+		if token.Value == "#if" || token.Value == "#ifdef" || token.Value == "#ifndef" {
+			it = r.conditional(nested)
+		} else if token.Type == tokenizer.Preprocessor {
+			it = r.directive()
+		} else {
+			it = r.statement()
+		}
+
+		it.comments, comments = comments, nil
+		it.spaced = spaced
+		items = append(items, it)
+	}
+
+	if len(comments) > 0 {
+		items = append(items, &item{comments: comments, spaced: spaced})
+	}
+
+	return items
+}
+
+// endLine is the line token i ends on, block comments can span several
+func (r *reader) endLine(i int) int {
+	return r.tokens[i].Line + strings.Count(r.tokens[i].Value, "\n")
+}
+
+// directive reads a directive, which runs to the end of its line
+func (r *reader) directive() *item {
+	it := &item{directive: true}
+	line := r.tokens[r.pos].Line
+
+	for r.pos < len(r.tokens) && r.tokens[r.pos].Line == line && (len(it.elements) == 0 || r.tokens[r.pos].Type != tokenizer.Preprocessor) {
+		it.elements = append(it.elements, element{token: r.tokens[r.pos]})
+		r.pos++
+	}
+
+	return it
+}
+
+// conditional reads an #if group. The preprocessor keeps one branch and the parser reverses the block
+// around it, so the group moves as a whole and each branch is reversed on its own
+func (r *reader) conditional(nested bool) *item {
+	it := &item{lines: []*item{r.directive()}}
+
+	r.conditionals++
+	defer func() { r.conditionals-- }()
+
+	for {
+		it.branches = append(it.branches, r.items(nested))
+
+		// An unterminated group is left for the preprocessor to report
+		if r.pos >= len(r.tokens) || r.tokens[r.pos].Type != tokenizer.Preprocessor {
+			return it
+		}
+
+		closing := r.tokens[r.pos].Value
+		it.lines = append(it.lines, r.directive())
+
+		if closing == "#endif" {
+			return it
+		}
+	}
+}
+
+// statement reads up to a `;` outside of brackets, or to the end of a block that is not followed by `else`
+func (r *reader) statement() *item {
+	it := &item{}
+	depth := 0
+
+	for r.pos < len(r.tokens) {
+		token := r.tokens[r.pos]
+
+		if token.Type == tokenizer.Preprocessor {
+			break
+		}
+
+		// Statement macros like `SWAP(a, b)` need no semicolon, a new line after their `)` starts the next statement
+		if depth == 0 && len(it.elements) > 0 && !it.elements[len(it.elements)-1].isBlock {
+			last := it.elements[len(it.elements)-1].token
+			if last.Value == ")" && token.Line > last.Line && (token.Type == tokenizer.Identifier || token.Type == tokenizer.Keyword) {
+				return it
+			}
+		}
+
+		if token.Type == tokenizer.Punctuation {
+			switch token.Value {
+			case "{":
+				// A lambda's body is a block anywhere, the statement goes on after it
+				lambda := len(it.elements) > 0 && lastToken(it).Value == "=>"
+
+				if !lambda && (depth > 0 || isInitializer(it)) {
+					depth++
+					break
+				}
+
+				r.pos++
+				block := r.items(true)
+				r.pos++ // The closing brace
+
+				it.elements = append(it.elements, element{block: block, isBlock: true, ordered: isStructBody(it) || isSwitch(it)})
+
+				if lambda || (r.pos < len(r.tokens) && r.tokens[r.pos].Value == "else") {
+					continue
+				}
+
+				// A struct declaration may end with `};`
+				if r.pos < len(r.tokens) && r.tokens[r.pos].Value == ";" {
+					it.elements = append(it.elements, element{token: r.tokens[r.pos]})
+					r.pos++
+				}
+
+				return it
+			case "(", "[":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 && token.Value == "}" {
+					// A stray closing brace, kept so no token is lost
+					it.elements = append(it.elements, element{token: token})
+					r.pos++
+					return it
+				}
+
+				depth--
+
+				// Enum and union declarations end with their variants, `enum Color { Red, Green }`
+				if depth == 0 && token.Value == "}" && hasVariants(it) {
+					it.elements = append(it.elements, element{token: token})
+					r.pos++
+
+					if r.pos < len(r.tokens) && r.tokens[r.pos].Value == ";" {
+						it.elements = append(it.elements, element{token: r.tokens[r.pos]})
+						r.pos++
+					}
+
+					return it
+				}
+			case ";":
+				if depth == 0 {
+					it.elements = append(it.elements, element{token: token})
+					r.pos++
+					return it
+				}
+			}
+		}
+
+		it.elements = append(it.elements, element{token: token})
+		r.pos++
+	}
+
+	return it
+}
+
+// isInitializer reports whether a `{` after the statement so far holds values rather than statements,
+// as in `int a[] = {1, 2}`, `Point{1, 2}`, the variants of an enum or union or the arms of a match. Blocks follow
+// a `)`, `else`, the name of a struct or the `:` of a case
+func isInitializer(it *item) bool {
+	if len(it.elements) == 0 {
+		return false
+	}
+
+	if hasVariants(it) {
+		return true
+	}
+
+	last := lastToken(it)
+	switch last.Type {
+	case tokenizer.Identifier:
+		return !isStructBody(it)
+	case tokenizer.Operator:
+		// `class Box<T> {` and `class Ints : List<int> {` open the body
+		return last.Value != "=>" && last.Value != ":" && !isStructBody(it)
+	case tokenizer.Keyword:
+		return last.Value == "return"
+	}
+
+	if last.Value == ")" {
+		open := openingParen(it)
+		return open > 0 && isKeyword(it.elements[open-1], "match")
+	}
+
+	return last.Value == "," || last.Value == "("
+}
+
+// openingParen finds the element index of the `(` matching the `)` the statement ends with
+func openingParen(it *item) int {
+	depth := 0
+
+	for i := len(it.elements) - 1; i >= 0; i-- {
+		switch it.elements[i].token.Value {
+		case ")":
+			depth++
+		case "(":
+			depth--
+		}
+
+		if depth == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isKeyword(e element, value string) bool {
+	return !e.isBlock && e.token.Type == tokenizer.Keyword && e.token.Value == value
+}
+
+// hasVariants reports whether the statement is an enum or union declaration
+func hasVariants(it *item) bool {
+	return len(it.elements) > 0 && (isKeyword(it.elements[0], "enum") || isKeyword(it.elements[0], "union"))
+}
+
+// isSwitch reports whether the statement so far is `switch (...)`, whose cases keep their order
+func isSwitch(it *item) bool {
+	return len(it.elements) > 0 && isKeyword(it.elements[0], "switch")
+}
+
+// isStructBody reports whether the statement so far declares a struct, class or interface, as in
+// `struct Name` or `class Name : Base, Printable`
+func isStructBody(it *item) bool {
+	return len(it.elements) >= 2 && (isKeyword(it.elements[0], "struct") || isKeyword(it.elements[0], "class") ||
+		isKeyword(it.elements[0], "interface"))
+}
+
+func lastToken(it *item) tokenizer.Token {
+	last := it.elements[len(it.elements)-1]
+	if last.isBlock {
+		return tokenizer.Token{Type: tokenizer.Punctuation, Value: "}"}
+	}
+
+	return last.token
+}
+
+// reverseInside reverses the blocks nested in it. statements is set when it sits in a block itself,
+// which makes the branches of an #if group statement lists too
+func reverseInside(it *item, statements bool) {
+	for _, branch := range it.branches {
+		if statements {
+			reverseItems(branch)
+			continue
+		}
+
+		for _, nested := range branch {
+			reverseInside(nested, false)
+		}
+	}
+
+	for _, e := range it.elements {
+		switch {
+		case e.isBlock && e.ordered:
+			for _, nested := range e.block {
+				reverseInside(nested, false)
+			}
+		case e.isBlock:
+			reverseItems(e.block)
+		}
+	}
+}
+
+// reverseItems reverses a list of statements and everything nested in them
+func reverseItems(items []*item) {
+	if len(items) == 0 {
+		return
+	}
+
+	// Blank lines stay between the same two statements, which changes which one they are above
+	gaps := make([]bool, len(items))
+	for i, it := range items {
+		gaps[i] = it.spaced
+	}
+
+	for l, h := 0, len(items)-1; l < h; l, h = l+1, h-1 {
+		items[l], items[h] = items[h], items[l]
+	}
+
+	items[0].spaced = gaps[0]
+	for i := 1; i < len(items); i++ {
+		items[i].spaced = gaps[len(items)-i]
+	}
+
+	for _, it := range items {
+		reverseInside(it, true)
+	}
+}
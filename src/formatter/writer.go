@@ -0,0 +1,412 @@
+package formatter
+
+import (
+	"strings"
+
+	"velox.eparker.dev/src/tokenizer"
+)
+
+const indentation = "    "
+
+type writer struct {
+	out strings.Builder
+}
+
+func (w *writer) items(items []*item, depth int) {
+	indent := strings.Repeat(indentation, depth)
+
+	for i, it := range items {
+		// Blank lines are kept, and functions and other top-level blocks always get one around them
+		if i > 0 && (it.spaced || (depth == 0 && (it.hasBlock() || items[i-1].hasBlock()))) {
+			w.out.WriteString("\n")
+		}
+
+		for _, comment := range it.comments {
+			w.out.WriteString(indent + comment.Value + "\n")
+		}
+
+		if len(it.elements) == 0 && it.lines == nil {
+			continue
+		}
+
+		if it.lines != nil {
+			w.group(it, depth)
+			continue
+		}
+
+		// Directives always start their line
+		if it.directive {
+			w.directive(it)
+		} else {
+			w.out.WriteString(indent)
+			w.statement(it, depth)
+		}
+
+		for _, comment := range it.trailing {
+			w.out.WriteString(" " + comment.Value)
+		}
+
+		w.out.WriteString("\n")
+	}
+}
+
+// group writes an #if group, its branches indented like the code around the group
+func (w *writer) group(it *item, depth int) {
+	for i, line := range it.lines {
+		w.directive(line)
+		w.out.WriteString("\n")
+
+		if i < len(it.branches) {
+			w.items(it.branches[i], depth)
+		}
+	}
+}
+
+// directive keeps the spacing the line was written with, since `#define F(x)` and `#define F (x)` differ
+func (w *writer) directive(it *item) {
+	for i, e := range it.elements {
+		if i > 0 {
+			previous := it.elements[i-1].token
+			gap := e.token.Column - (previous.Column + len(previous.Value))
+			if gap < 0 {
+				gap = 1
+			}
+
+			w.out.WriteString(strings.Repeat(" ", gap))
+		}
+
+		w.out.WriteString(e.token.Value)
+	}
+}
+
+func (w *writer) statement(it *item, depth int) {
+	var written []tokenizer.Token // The tokens of the current line so far
+	afterBlock := false
+	brackets := typeBrackets(it.elements)
+
+	for i, e := range it.elements {
+		if e.isBlock {
+			if len(written) > 0 {
+				w.out.WriteString(" ")
+			}
+
+			w.out.WriteString("{\n")
+			w.items(e.block, depth+1)
+			w.out.WriteString(strings.Repeat(indentation, depth) + "}")
+
+			written, afterBlock = nil, true
+			continue
+		}
+
+		token := e.token
+
+		// `} else`, but `};` and `}, 2)` after a lambda's body
+		if afterBlock && !strings.Contains(";,)", token.Value) || bracketSpace(it.elements, brackets, i, func() bool { return needsSpace(written, token) }) {
+			w.out.WriteString(" ")
+		}
+
+		w.out.WriteString(token.Value)
+
+		// A line comment runs to the end of the line, so the statement continues on the next one
+		if token.Type == tokenizer.Comment && strings.HasPrefix(token.Value, "//") {
+			w.out.WriteString("\n" + strings.Repeat(indentation, depth+1))
+			written, afterBlock = nil, false
+			continue
+		}
+
+		written, afterBlock = append(written, token), false
+	}
+}
+
+// typeBrackets finds the `<` and `>` enclosing type parameters and arguments, as in `List<int> l` or
+// `T max<T : Ordered>(T a, T b)`, which are written without spaces, unlike comparisons
+func typeBrackets(elements []element) map[int]bool {
+	brackets := make(map[int]bool)
+
+	for i := 1; i < len(elements); i++ {
+		if elements[i].isBlock || elements[i].token.Value != "<" || elements[i-1].isBlock || elements[i-1].token.Type != tokenizer.Identifier {
+			continue
+		}
+
+		end := closingBracket(elements, i)
+		if end < 0 || !followsTypeList(elements, i-1, end) {
+			continue
+		}
+
+		for k := i; k <= end; k++ {
+			if value := elements[k].token.Value; value == "<" || value == ">" || value == ">>" {
+				brackets[k] = true
+			}
+		}
+
+		i = end
+	}
+
+	return brackets
+}
+
+// closingBracket finds the element closing the list of types opened at elements[open], or -1 when
+// anything but a type comes first
+func closingBracket(elements []element, open int) int {
+	depth := 0
+
+	for i := open; i < len(elements); i++ {
+		token := elements[i].token
+		switch {
+		case elements[i].isBlock:
+			return -1
+		case token.Value == "<":
+			depth++
+		case token.Value == ">":
+			depth--
+		case token.Value == ">>":
+			depth -= 2
+		case token.Type == tokenizer.Identifier, isTypeName(&token), strings.Contains(".,[]()*:", token.Value) && token.Type != tokenizer.String:
+		default:
+			return -1
+		}
+
+		if depth <= 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// followsTypeList reports whether what comes after the list of types ending at elements[end] makes it
+// one. A name after it declares something of the type, which is only told apart from `a < b, c > d` at
+// the start of a declaration: `List<int> l` or the parameters of a function
+func followsTypeList(elements []element, name, end int) bool {
+	if end+1 >= len(elements) || elements[end+1].isBlock {
+		return true
+	}
+
+	next := elements[end+1].token
+	if next.Type != tokenizer.Identifier {
+		return strings.Contains("([*;,)", next.Value) || next.Value == ">" || next.Value == ">>"
+	}
+
+	// A qualified name, `geo.List<int> l`
+	if name >= 2 && elements[name-1].token.Value == "." {
+		name -= 2
+	}
+
+	for name > 0 && isModifier(&elements[name-1].token) {
+		name--
+	}
+
+	if name == 0 {
+		return true
+	}
+
+	before := elements[name-1].token.Value
+	declaresFunction := len(elements) > 2 && elements[1].token.Type == tokenizer.Identifier && (elements[2].token.Value == "(" || elements[2].token.Value == "<")
+	return (before == "(" || before == ",") && declaresFunction
+}
+
+// bracketSpace decides whether a space goes before elements[i], keeping the brackets of type lists close
+// to the types. spaced decides for anything else
+func bracketSpace(elements []element, brackets map[int]bool, i int, spaced func() bool) bool {
+	switch {
+	case brackets[i]:
+		return false
+	case i > 0 && brackets[i-1] && elements[i-1].token.Value == "<":
+		return false
+	case i > 0 && brackets[i-1]:
+		// `List<int> l` and `class Box<T> {`, but `max<int>(a, b)` and `List<int>[] lists`
+		return !strings.Contains("([*;,)", elements[i].token.Value)
+	}
+
+	return spaced()
+}
+
+// back returns the token n places before the end of written, or nil
+func back(written []tokenizer.Token, n int) *tokenizer.Token {
+	if n > len(written) {
+		return nil
+	}
+
+	return &written[len(written)-n]
+}
+
+// needsSpace decides whether a space goes between the tokens written so far and current, C style
+func needsSpace(written []tokenizer.Token, current tokenizer.Token) bool {
+	previous, beforePrevious := back(written, 1), back(written, 2)
+	if previous == nil {
+		return false
+	}
+
+	switch current.Value {
+	case "}":
+		return spacedBrace(written, matchingBrace(written))
+	case ";", ",", ")", "]", "::":
+		return false
+	case ".":
+		return previous.Value == "," // A named field in `{.x = 1, .y = 2}`
+	case ":":
+		// `case 1:` and `default:`, but `enum Size : char`
+		return written[0].Value != "case" && written[0].Value != "default"
+	case "{":
+		// Initializers, `Point{1, 2}`
+		if previous.Type == tokenizer.Identifier && !spacedBrace(written, len(written)) {
+			return false
+		}
+	case "(", "[":
+		// Calls, indexing, function types, `int(int)`, and constructors, `New(int x)`
+		if previous.Type == tokenizer.Identifier || previous.Value == ")" || previous.Value == "]" || isTypeName(previous) || previous.Value == "New" {
+			return false
+		}
+	case "++", "--":
+		if isOperand(previous) {
+			return false // Postfix
+		}
+	case "*":
+		if pointerSuffix(written) {
+			return false // `int* p`
+		}
+	}
+
+	// No space after a cast, `(int)x` or `(int*)p`, but one after a function type, `int(int) f`
+	stars := 0
+	for back(written, stars+2) != nil && back(written, stars+2).Value == "*" {
+		stars++
+	}
+
+	if typ := back(written, stars+2); previous.Value == ")" && typ != nil && isTypeName(typ) && isOperand(&current) {
+		if open := back(written, stars+3); open != nil && open.Value == "(" {
+			if before := back(written, stars+4); before == nil || !isTypeName(before) && before.Value != ")" {
+				return false
+			}
+		}
+	}
+
+	if previous.Value == "{" {
+		return spacedBrace(written, len(written)-1)
+	}
+
+	switch previous.Value {
+	case "(", "[", ".", "::", "!", "~":
+		return false
+	case "++", "--":
+		return beforePrevious != nil && isOperand(beforePrevious) // Space after postfix, none after prefix
+	case "-", "+", "*", "&":
+		if previous.Value == "*" && pointerSuffix(written[:len(written)-1]) {
+			return true
+		}
+
+		// A unary operator sticks to its operand
+		return beforePrevious != nil && isOperand(beforePrevious)
+	}
+
+	return true
+}
+
+// pointerSuffix reports whether a `*` written next makes a pointer type out of what precedes it rather
+// than multiplying or dereferencing. Named types are only recognized where a declaration starts: at the
+// start of a statement or a parameter of a function declaration
+func pointerSuffix(written []tokenizer.Token) bool {
+	previous := back(written, 1)
+	switch {
+	case previous == nil:
+		return false
+	case isTypeName(previous):
+		return true
+	case previous.Value == "*":
+		return pointerSuffix(written[:len(written)-1])
+	case previous.Type != tokenizer.Identifier:
+		return false
+	}
+
+	start := len(written) - 1
+	for start > 0 && isModifier(&written[start-1]) {
+		start--
+	}
+
+	if start == 0 {
+		return true
+	}
+
+	opener := written[start-1].Value
+	return (opener == "(" || opener == ",") && inParameters(written[:start])
+}
+
+func isModifier(token *tokenizer.Token) bool {
+	return token.Type == tokenizer.Keyword && (token.Value == "const" || token.Value == "ref" || token.Value == "weak" ||
+		token.Value == "virtual" || token.Value == "override")
+}
+
+// inParameters reports whether written ends inside the parameter list of a function declaration,
+// `int f(` followed by parameters
+func inParameters(written []tokenizer.Token) bool {
+	if len(written) < 3 || written[1].Type != tokenizer.Identifier || written[2].Value != "(" {
+		return false
+	}
+
+	if written[0].Type != tokenizer.Identifier && !isTypeName(&written[0]) {
+		return false
+	}
+
+	depth := 0
+	for _, token := range written[2:] {
+		switch token.Value {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+	}
+
+	return depth == 1
+}
+
+// spacedBrace reports whether the `{` at written[i] opens the variants of an enum or union or the arms
+// of a match, which are written `{ A, B }` unlike the values of an initializer
+func spacedBrace(written []tokenizer.Token, i int) bool {
+	if i < 0 {
+		return false
+	}
+
+	declaresVariants := written[0].Type == tokenizer.Keyword && (written[0].Value == "enum" || written[0].Value == "union")
+	return declaresVariants || i > 0 && written[i-1].Value == ")"
+}
+
+// matchingBrace finds the index of the `{` closed by a `}` written next, or -1
+func matchingBrace(written []tokenizer.Token) int {
+	depth := 0
+
+	for i := len(written) - 1; i >= 0; i-- {
+		switch written[i].Value {
+		case "}":
+			depth++
+		case "{":
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+
+	return -1
+}
+
+// isOperand reports whether a token can end an operand, which makes the operator after it binary
+func isOperand(token *tokenizer.Token) bool {
+	switch token.Type {
+	case tokenizer.Identifier, tokenizer.Number, tokenizer.String:
+		return true
+	case tokenizer.Keyword:
+		return token.Value == "true" || token.Value == "false" || token.Value == "maybe"
+	}
+
+	return token.Value == ")" || token.Value == "]"
+}
+
+func isTypeName(token *tokenizer.Token) bool {
+	switch token.Value {
+	case "int", "float", "char", "bool", "void":
+		return token.Type == tokenizer.Keyword
+	}
+
+	return false
+}
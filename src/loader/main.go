@@ -0,0 +1,92 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+	"velox.eparker.dev/src/preprocessor"
+	"velox.eparker.dev/src/tokenizer"
+)
+
+// Loader resolves `import name;` declarations. Every module is read from name.vl, parsed on its own
+// and attached to the first import of it, so the whole program ends up in a single tree
+type Loader struct {
+	debugMode       bool
+	searchPaths     []string
+	newPreprocessor func() *preprocessor.Preprocessor
+	loaded          map[string]string // Module name to the file it was read from
+	loading         []string          // Modules whose imports are being resolved, outermost first
+}
+
+// NewLoader creates a loader. newPreprocessor is called once per module, so macros do not leak between files
+func NewLoader(newPreprocessor func() *preprocessor.Preprocessor, debugMode bool) *Loader {
+	return &Loader{
+		debugMode:       debugMode,
+		newPreprocessor: newPreprocessor,
+		loaded:          make(map[string]string),
+	}
+}
+
+// AddSearchPath adds a directory searched for modules after the importing file's own directory
+func (l *Loader) AddSearchPath(path string) *Loader {
+	l.searchPaths = append(l.searchPaths, path)
+	return l
+}
+
+func (l *Loader) Error(format string, node *ast.ASTNode) {
+	fmt.Printf("%s: %s\n", tokenizer.FormatPosition(node.File, node.Line, node.Column), format)
+
+	if l.debugMode {
+		panic("Loader error")
+	}
+
+	os.Exit(1)
+}
+
+// ResolveImports loads every module imported by program, which was read from file
+func (l *Loader) ResolveImports(program *ast.ASTNode, file string) {
+	// The main file takes part in cycle detection under its own name
+	l.loading = append(l.loading, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	l.resolve(program, file)
+	l.loading = l.loading[:len(l.loading)-1]
+}
+
+func (l *Loader) resolve(module *ast.ASTNode, file string) {
+	for _, child := range module.Children {
+		if child.Type != ast.ImportDeclaration {
+			continue
+		}
+
+		for i, name := range l.loading {
+			if name == child.Name {
+				cycle := append(append([]string{}, l.loading[i:]...), child.Name)
+				l.Error(fmt.Sprintf("Import cycle: %s", strings.Join(cycle, " -> ")), child)
+			}
+		}
+
+		// Later imports of a module only make its names visible, the declarations live under the first one
+		if _, ok := l.loaded[child.Name]; ok {
+			continue
+		}
+
+		path, ok := preprocessor.ResolvePath(child.Name+".vl", file, l.searchPaths)
+		if !ok {
+			l.Error(fmt.Sprintf("Cannot find module '%s'", child.Name), child)
+		}
+
+		tokens, err := tokenizer.TokenizeFile(path)
+		if err != nil {
+			l.Error(err.Error(), child)
+		}
+
+		l.loaded[child.Name] = path
+		child.Children = ast.NewParser(l.newPreprocessor().Process(tokens), l.debugMode).Parse().Children
+
+		l.loading = append(l.loading, child.Name)
+		l.resolve(child, path)
+		l.loading = l.loading[:len(l.loading)-1]
+	}
+}
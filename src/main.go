@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"velox.eparker.dev/src/ast"
 	"velox.eparker.dev/src/builder"
+	"velox.eparker.dev/src/formatter"
+	"velox.eparker.dev/src/loader"
+	"velox.eparker.dev/src/preprocessor"
+	"velox.eparker.dev/src/sema"
 	"velox.eparker.dev/src/tokenizer"
 )
 
@@ -38,21 +43,76 @@ func writeTextFile(filename string, data string) error {
 	return nil
 }
 
+// Compiler version 0.1.0, exposed to source code as __VELOX_VERSION__ (major*10000 + minor*100 + patch)
+const compilerVersion = 100
+
 type Arguments struct {
 	InputFile     string
 	PreserveFiles bool
+	WarnShadowing bool
+	Release       bool
+	Target        string
+	Defines       []string // NAME=value pairs from -D flags
+	SearchPaths   []string // Directories from -I flags, searched by #include and import
+	Seed          int64
+	HasSeed       bool // Without --seed every build is randomized differently
+	NoMaybe       bool
+	Format        string // "normal" or "reversed" prints the input in that statement order instead of compiling
+}
+
+var targets = map[string]builder.TargetType{
+	"linux":   builder.Linux,
+	"windows": builder.Windows,
 }
 
 var args Arguments = (func() Arguments {
-	var output Arguments
+	output := Arguments{Target: "linux"}
 
 	for _, arg := range os.Args {
 		switch arg {
 		case "--preserve":
 			output.PreserveFiles = true
+		case "--warn-shadow":
+			output.WarnShadowing = true
+		case "--release":
+			output.Release = true
+		case "--no-maybe":
+			output.NoMaybe = true
+		}
+
+		if strings.HasPrefix(arg, "--seed=") {
+			seed, err := strconv.ParseInt(strings.TrimPrefix(arg, "--seed="), 10, 64)
+			if err != nil {
+				fmt.Printf("Invalid seed: %s\n", arg)
+				os.Exit(1)
+			}
+
+			output.Seed, output.HasSeed = seed, true
+		}
+
+		if strings.HasPrefix(arg, "--format=") {
+			output.Format = strings.TrimPrefix(arg, "--format=")
+
+			if output.Format != "normal" && output.Format != "reversed" {
+				fmt.Printf("Unknown format: %s, expected normal or reversed\n", output.Format)
+				os.Exit(1)
+			}
+		}
+
+		if strings.HasPrefix(arg, "--target=") {
+			output.Target = strings.TrimPrefix(arg, "--target=")
+
+			if _, ok := targets[output.Target]; !ok {
+				fmt.Printf("Unknown target: %s\n", output.Target)
+				os.Exit(1)
+			}
 		}
 
-		if !strings.HasPrefix(arg, "--") {
+		if strings.HasPrefix(arg, "-D") {
+			output.Defines = append(output.Defines, strings.TrimPrefix(arg, "-D"))
+		} else if strings.HasPrefix(arg, "-I") {
+			output.SearchPaths = append(output.SearchPaths, strings.TrimPrefix(arg, "-I"))
+		} else if !strings.HasPrefix(arg, "--") {
 			output.InputFile = arg
 		}
 	}
@@ -82,43 +142,86 @@ func checkOutputDir() {
 	}
 }
 
-func main() {
-	file, err := os.OpenFile(args.InputFile, os.O_RDONLY, 0644)
-
-	if err != nil {
-		panic(err)
+// newPreprocessor sets up the predefined macros every file starts with
+func newPreprocessor(target builder.TargetType) *preprocessor.Preprocessor {
+	// Predefined macros first, so -D can override them
+	pp := preprocessor.NewPreprocessor(true)
+	for _, name := range target.Macros() {
+		pp.Define(name, "1")
 	}
 
-	defer file.Close()
+	pp.Define("__VELOX_VERSION__", fmt.Sprint(compilerVersion))
+	if args.Release {
+		pp.Define("__RELEASE__", "1")
+	} else {
+		pp.Define("__DEBUG__", "1")
+	}
 
-	stat, err := file.Stat()
+	for _, definition := range args.Defines {
+		pp.DefineFlag(definition)
+	}
 
-	if err != nil {
-		panic(err)
+	for _, path := range args.SearchPaths {
+		pp.AddIncludePath(path)
 	}
 
-	buffer := make([]byte, stat.Size())
+	return pp
+}
+
+func main() {
+	if args.Format != "" {
+		source, err := formatter.FormatFile(args.InputFile, args.Format == "reversed")
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Print(source)
+		return
+	}
 
-	_, err = file.Read(buffer)
+	tokens, err := tokenizer.TokenizeFile(args.InputFile)
 
 	if err != nil {
 		panic(err)
 	}
 
-	code := string(buffer)
-
-	tokens := tokenizer.Tokenize(code, true)
 	fmt.Printf("Found %d tokens\n", len(tokens))
 
+	target := targets[args.Target]
+	tokens = newPreprocessor(target).Process(tokens)
+
 	checkOutputDir()
 
 	writeToJSONFile("./artifacts/tokens.json", tokens)
 
-	ast := ast.NewParser(tokens, true).Parse()
-	writeTextFile("./artifacts/ast.txt", ast.StringIndented(0))
-	writeToJSONFile("./artifacts/ast.json", ast)
+	program := ast.NewParser(tokens, true).Parse()
+
+	modules := loader.NewLoader(func() *preprocessor.Preprocessor { return newPreprocessor(target) }, true)
+	for _, path := range args.SearchPaths {
+		modules.AddSearchPath(path)
+	}
+	modules.ResolveImports(program, args.InputFile)
+	writeTextFile("./artifacts/ast.txt", program.StringIndented(0))
+
+	// Every user error is reported here, the builder assumes a well-typed tree
+	diagnostics := sema.NewAnalyzer(program).SetWarnShadowing(args.WarnShadowing).SetAllowMaybe(!args.NoMaybe).Analyze()
+	for _, diagnostic := range diagnostics {
+		fmt.Println(diagnostic)
+	}
+
+	writeToJSONFile("./artifacts/ast.json", program)
+
+	if sema.HasErrors(diagnostics) {
+		fmt.Printf("Compilation failed with %d diagnostic(s)\n", len(diagnostics))
+		os.Exit(1)
+	}
+
+	llvmBuilder := builder.NewBuilder(program).SetTarget(target).SetDebug(!args.Release)
+	if args.HasSeed {
+		llvmBuilder.SetSeed(args.Seed)
+	}
 
-	writeTextFile("./artifacts/output.ll", builder.NewBuilder(ast).SetTarget(builder.Linux).Build().String())
+	writeTextFile("./artifacts/output.ll", llvmBuilder.Build().String())
 
 	// Compile to assembly
 	cmd := exec.Command("llc", "./artifacts/output.ll")
@@ -0,0 +1,211 @@
+package preprocessor
+
+import (
+	"fmt"
+	"strconv"
+
+	"velox.eparker.dev/src/tokenizer"
+)
+
+// functionMacroDirective handles `#define NAME(params) body` and the #undef of such a macro. It reports
+// false for every other directive, which is then passed on to the parser
+func (pp *Preprocessor) functionMacroDirective(directive tokenizer.Token, operands []tokenizer.Token) bool {
+	if len(operands) == 0 || operands[0].Type != tokenizer.Identifier {
+		return false
+	}
+
+	name := operands[0]
+
+	if directive.Value == "#undef" {
+		if definition, ok := pp.definitions[name.Value]; ok && definition.Function {
+			delete(pp.definitions, name.Value)
+			return true
+		}
+
+		return false
+	}
+
+	// Only a parenthesis directly after the name makes a function-like macro, `#define X (1)` is a constant
+	if directive.Value != "#define" || len(operands) < 2 || operands[1].Value != "(" ||
+		operands[1].Line != name.Line || operands[1].Column != name.Column+len(name.Value) {
+		return false
+	}
+
+	definition := &Definition{Name: name.Value, Function: true, Token: name}
+
+	i := 2
+	for i < len(operands) && operands[i].Value != ")" {
+		param := operands[i]
+		if param.Type != tokenizer.Identifier {
+			pp.Error(fmt.Sprintf("Expected a parameter name in macro '%s', got %s", name.Value, param.String()), param)
+		}
+
+		definition.Params = append(definition.Params, param.Value)
+		i++
+
+		if i < len(operands) && operands[i].Value == "," {
+			i++
+		}
+	}
+
+	if i >= len(operands) {
+		pp.Error(fmt.Sprintf("Missing ) in the parameters of macro '%s'", name.Value), name)
+	}
+
+	definition.Value = operands[i+1:]
+	pp.definitions[name.Value] = definition
+	return true
+}
+
+// expand replaces calls to function-like macros and the __LINE__ and __FILE__ builtins in tokens.
+// Macros in disabled are being expanded already and are left alone, so a macro cannot recurse
+func (pp *Preprocessor) expand(tokens []tokenizer.Token, disabled map[string]bool) []tokenizer.Token {
+	var output []tokenizer.Token
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if token.Type != tokenizer.Identifier {
+			output = append(output, token)
+			continue
+		}
+
+		switch token.Value {
+		case "__LINE__":
+			token.Type, token.Value = tokenizer.Number, strconv.Itoa(token.Line)
+			output = append(output, token)
+			continue
+		case "__FILE__":
+			token.Type, token.Value = tokenizer.String, strconv.Quote(token.File)
+			output = append(output, token)
+			continue
+		}
+
+		definition, ok := pp.definitions[token.Value]
+
+		// Like in C, a function-like macro name that is not called is left as a plain name
+		if !ok || !definition.Function || disabled[token.Value] || i+1 >= len(tokens) || tokens[i+1].Value != "(" {
+			output = append(output, token)
+			continue
+		}
+
+		args, end := pp.collectArguments(token, tokens, i+1)
+		output = append(output, pp.expandMacro(definition, token, args, disabled)...)
+		i = end
+	}
+
+	return output
+}
+
+// collectArguments splits the call starting at the '(' at tokens[open] on its top-level commas. It
+// returns the arguments and the index of the closing ')'
+func (pp *Preprocessor) collectArguments(use tokenizer.Token, tokens []tokenizer.Token, open int) ([][]tokenizer.Token, int) {
+	var args [][]tokenizer.Token
+	var current []tokenizer.Token
+	depth := 0
+
+	for i := open + 1; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if token.Type == tokenizer.Punctuation {
+			switch token.Value {
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					if len(current) > 0 || len(args) > 0 {
+						args = append(args, current)
+					}
+
+					return args, i
+				}
+
+				depth--
+			case ",":
+				if depth == 0 {
+					args = append(args, current)
+					current = nil
+					continue
+				}
+			}
+		}
+
+		current = append(current, token)
+	}
+
+	pp.Error(fmt.Sprintf("Unterminated call to macro '%s'", use.Value), use)
+	return nil, len(tokens)
+}
+
+// expandMacro substitutes args into the body of definition. The result takes the position of the use
+// site and remembers the macro it came from, so errors inside it point at the call
+func (pp *Preprocessor) expandMacro(definition *Definition, use tokenizer.Token, args [][]tokenizer.Token, disabled map[string]bool) []tokenizer.Token {
+	if len(args) != len(definition.Params) {
+		pp.Error(fmt.Sprintf("Macro '%s' expects %d argument(s), got %d", definition.Name, len(definition.Params), len(args)), use)
+	}
+
+	params := make(map[string][]tokenizer.Token)
+	for i, param := range definition.Params {
+		params[param] = pp.expand(args[i], disabled)
+	}
+
+	expansion := &tokenizer.Expansion{
+		Macro:  definition.Name,
+		Line:   definition.Token.Line,
+		Column: definition.Token.Column,
+		File:   definition.Token.File,
+		Parent: use.Expansion,
+	}
+
+	pp.expansions++
+	renames := pp.hygienicNames(definition, pp.expansions)
+
+	var body []tokenizer.Token
+	for _, token := range definition.Value {
+		if arg, ok := params[token.Value]; ok && token.Type == tokenizer.Identifier {
+			body = append(body, arg...)
+			continue
+		}
+
+		if renamed, ok := renames[token.Value]; ok && token.Type == tokenizer.Identifier {
+			token.Value = renamed
+		}
+
+		token.Line, token.Column, token.File = use.Line, use.Column, use.File
+		token.Expansion = expansion
+		body = append(body, token)
+	}
+
+	// The result is scanned again for further macros, with this one switched off
+	inner := map[string]bool{definition.Name: true}
+	for name := range disabled {
+		inner[name] = true
+	}
+
+	return pp.expand(body, inner)
+}
+
+// hygienicNames renames the locals a macro body declares, so `int tmp = a;` inside a macro can neither
+// clash with nor capture a `tmp` at the call site. `$` cannot appear in source names
+func (pp *Preprocessor) hygienicNames(definition *Definition, expansion int) map[string]string {
+	renames := make(map[string]string)
+
+	for i := 1; i < len(definition.Value); i++ {
+		previous, token := definition.Value[i-1], definition.Value[i]
+
+		if previous.Type != tokenizer.Keyword || token.Type != tokenizer.Identifier {
+			continue
+		}
+
+		switch previous.Value {
+		case "int", "float", "char", "bool":
+			renames[token.Value] = fmt.Sprintf("%s$%d", token.Value, expansion)
+		}
+	}
+
+	for _, param := range definition.Params {
+		delete(renames, param)
+	}
+
+	return renames
+}
@@ -0,0 +1,410 @@
+package preprocessor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+	"velox.eparker.dev/src/tokenizer"
+)
+
+// Definition is a macro known to the preprocessor. Value holds the tokens after the name, which may be
+// empty. Function-like macros take parameters and are expanded here instead of being passed to the parser
+type Definition struct {
+	Name     string
+	Value    []tokenizer.Token
+	Function bool
+	Params   []string
+	Token    tokenizer.Token // The macro's name where it is defined
+}
+
+// conditional tracks one #if ... #endif group
+type conditional struct {
+	start        tokenizer.Token
+	parentActive bool // Whether the group is inside live code at all
+	active       bool // Whether the current branch is kept
+	taken        bool // Whether any branch so far was kept
+	seenElse     bool
+}
+
+// maxIncludeDepth stops a file without an include guard from including itself forever
+const maxIncludeDepth = 64
+
+// Preprocessor runs over the token stream before parsing. It splices in #include files, drops the
+// inactive branches of conditional groups and keeps #define / #undef in place so the parser still sees them
+type Preprocessor struct {
+	debugMode    bool
+	predefined   []*Definition // Predefined and command line macros, emitted ahead of the source
+	definitions  map[string]*Definition
+	evaluating   map[string]bool
+	includePaths []string
+	once         map[string]bool // Files marked with #pragma once, by absolute path
+	depth        int
+	expansions   int // Counts function-like macro expansions, so hygienic names are unique
+}
+
+func NewPreprocessor(debugMode bool) *Preprocessor {
+	return &Preprocessor{
+		debugMode:   debugMode,
+		definitions: make(map[string]*Definition),
+		evaluating:  make(map[string]bool),
+		once:        make(map[string]bool),
+	}
+}
+
+// AddIncludePath adds a directory searched by #include after the including file's own directory
+func (pp *Preprocessor) AddIncludePath(path string) *Preprocessor {
+	pp.includePaths = append(pp.includePaths, path)
+	return pp
+}
+
+// Define predefines name with the given source text as its value. Defining a name again replaces it
+func (pp *Preprocessor) Define(name, value string) *Preprocessor {
+	tokens := tokenizer.Tokenize(value, true)
+	for i := range tokens {
+		tokens[i].Line = 0 // Line 0 marks definitions that did not come from a source file
+	}
+
+	definition := &Definition{Name: name, Value: tokens}
+
+	if _, exists := pp.definitions[name]; exists {
+		for i, existing := range pp.predefined {
+			if existing.Name == name {
+				pp.predefined[i] = definition
+			}
+		}
+	} else {
+		pp.predefined = append(pp.predefined, definition)
+	}
+
+	pp.definitions[name] = definition
+	return pp
+}
+
+// DefineFlag handles a `-DNAME=value` flag. A bare `-DNAME` defines NAME as 1
+func (pp *Preprocessor) DefineFlag(definition string) *Preprocessor {
+	name, value, hasValue := strings.Cut(definition, "=")
+	if !hasValue {
+		value = "1"
+	}
+
+	return pp.Define(name, value)
+}
+
+func (pp *Preprocessor) Error(format string, token tokenizer.Token) {
+	fmt.Printf("%s: %s\n", token.Position(), format)
+
+	for _, note := range token.Expansion.Notes() {
+		fmt.Println(note)
+	}
+
+	if pp.debugMode {
+		panic("Preprocessor error")
+	}
+
+	os.Exit(1)
+}
+
+// Process returns the tokens the parser should see: the predefined macros as #define directives,
+// followed by the live parts of the source
+func (pp *Preprocessor) Process(tokens []tokenizer.Token) []tokenizer.Token {
+	var output []tokenizer.Token
+
+	for _, definition := range pp.predefined {
+		output = append(output,
+			tokenizer.Token{Type: tokenizer.Preprocessor, Value: "#define"},
+			tokenizer.Token{Type: tokenizer.Identifier, Value: definition.Name},
+		)
+		output = append(output, definition.Value...)
+	}
+
+	return append(output, pp.process(tokens)...)
+}
+
+// process handles the tokens of one file. Conditional groups must be closed in the file that opens them
+func (pp *Preprocessor) process(tokens []tokenizer.Token) []tokenizer.Token {
+	var output []tokenizer.Token
+
+	var stack []*conditional
+	active := func() bool {
+		return len(stack) == 0 || stack[len(stack)-1].active
+	}
+
+	for i := 0; i < len(tokens); {
+		token := tokens[i]
+
+		if token.Type != tokenizer.Preprocessor {
+			// Code runs up to the next directive, a macro call cannot span one
+			end := i + 1
+			for end < len(tokens) && tokens[end].Type != tokenizer.Preprocessor {
+				end++
+			}
+
+			if active() {
+				output = append(output, pp.expand(tokens[i:end], nil)...)
+			}
+
+			i = end
+			continue
+		}
+
+		// A directive runs to the end of its line
+		end := i + 1
+		for end < len(tokens) && tokens[end].Line == token.Line && tokens[end].File == token.File && tokens[end].Type != tokenizer.Preprocessor {
+			end++
+		}
+
+		operands := tokens[i+1 : end]
+
+		switch token.Value {
+		case "#if", "#ifdef", "#ifndef":
+			group := &conditional{start: token, parentActive: active()}
+
+			if group.parentActive {
+				group.active = pp.evaluateCondition(token, operands)
+				group.taken = group.active
+			}
+
+			stack = append(stack, group)
+		case "#elif", "#else":
+			if len(stack) == 0 {
+				pp.Error(fmt.Sprintf("%s without #if", token.Value), token)
+			}
+
+			group := stack[len(stack)-1]
+
+			if group.seenElse {
+				pp.Error(fmt.Sprintf("%s after #else", token.Value), token)
+			}
+
+			if token.Value == "#else" {
+				if len(operands) > 0 {
+					pp.Error("Unexpected tokens after #else", operands[0])
+				}
+
+				group.seenElse = true
+				group.active = group.parentActive && !group.taken
+			} else {
+				group.active = group.parentActive && !group.taken && pp.evaluateCondition(token, operands)
+			}
+
+			group.taken = group.taken || group.active
+		case "#endif":
+			if len(stack) == 0 {
+				pp.Error("#endif without #if", token)
+			}
+
+			if len(operands) > 0 {
+				pp.Error("Unexpected tokens after #endif", operands[0])
+			}
+
+			stack = stack[:len(stack)-1]
+		case "#include":
+			if active() {
+				output = append(output, pp.include(token, operands)...)
+			}
+		case "#pragma":
+			if !active() {
+				break
+			}
+
+			if len(operands) != 1 || operands[0].Value != "once" {
+				pp.Error("Unsupported #pragma, only #pragma once is known", token)
+			}
+
+			pp.once[absolutePath(token.File)] = true
+		default:
+			if !active() || pp.functionMacroDirective(token, operands) {
+				break
+			}
+
+			pp.track(token, operands)
+
+			// Function-like macros in a constant's value are expanded now, the parser only sees the result
+			if token.Value == "#define" && len(operands) > 1 {
+				output = append(output, token, operands[0])
+				output = append(output, pp.expand(operands[1:], nil)...)
+			} else {
+				output = append(output, tokens[i:end]...)
+			}
+		}
+
+		i = end
+	}
+
+	if len(stack) > 0 {
+		group := stack[len(stack)-1]
+		pp.Error(fmt.Sprintf("Unterminated %s", group.start.Value), group.start)
+	}
+
+	return output
+}
+
+// include returns the preprocessed tokens of the file named by an `#include "path"` directive
+func (pp *Preprocessor) include(directive tokenizer.Token, operands []tokenizer.Token) []tokenizer.Token {
+	if len(operands) != 1 || ast.LiteralType(operands[0].Value) != "string" {
+		pp.Error("#include expects a quoted path", directive)
+	}
+
+	name, err := ast.ParseStringLiteral(operands[0].Value)
+	if err != nil {
+		pp.Error(err.Error(), operands[0])
+	}
+
+	path, ok := ResolvePath(name, directive.File, pp.includePaths)
+	if !ok {
+		pp.Error(fmt.Sprintf("Cannot find included file '%s'", name), operands[0])
+	}
+
+	if pp.once[absolutePath(path)] {
+		return nil
+	}
+
+	if pp.depth >= maxIncludeDepth {
+		pp.Error(fmt.Sprintf("Includes nested more than %d deep, is '%s' missing an include guard?", maxIncludeDepth, name), directive)
+	}
+
+	tokens, err := tokenizer.TokenizeFile(path)
+	if err != nil {
+		pp.Error(err.Error(), directive)
+	}
+
+	pp.depth++
+	defer func() { pp.depth-- }()
+
+	return pp.process(tokens)
+}
+
+// ResolvePath finds name next to the file that refers to it, then in each search path
+func ResolvePath(name, fromFile string, searchPaths []string) (string, bool) {
+	candidates := []string{filepath.Join(filepath.Dir(fromFile), name)}
+	for _, dir := range searchPaths {
+		candidates = append(candidates, filepath.Join(dir, name))
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+func absolutePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+// track records #define and #undef so later conditions can see them
+func (pp *Preprocessor) track(directive tokenizer.Token, operands []tokenizer.Token) {
+	if len(operands) == 0 || operands[0].Type != tokenizer.Identifier {
+		return // Malformed directives are reported by the parser
+	}
+
+	switch directive.Value {
+	case "#define":
+		pp.definitions[operands[0].Value] = &Definition{Name: operands[0].Value, Value: operands[1:]}
+	case "#undef":
+		delete(pp.definitions, operands[0].Value)
+	}
+}
+
+// evaluateCondition decides whether the branch opened by directive is kept
+func (pp *Preprocessor) evaluateCondition(directive tokenizer.Token, operands []tokenizer.Token) bool {
+	if directive.Value == "#ifdef" || directive.Value == "#ifndef" {
+		if len(operands) != 1 || operands[0].Type != tokenizer.Identifier {
+			pp.Error(fmt.Sprintf("%s expects a single name", directive.Value), directive)
+		}
+
+		_, defined := pp.definitions[operands[0].Value]
+		return defined == (directive.Value == "#ifdef")
+	}
+
+	if len(operands) == 0 {
+		pp.Error(fmt.Sprintf("%s expects a condition", directive.Value), directive)
+	}
+
+	condition := pp.expand(pp.replaceDefined(operands), nil)
+
+	result, err := ast.EvaluateConstant(ast.ParseTokenExpression(condition, pp.debugMode), pp.lookup)
+	if err != nil {
+		pp.Error(fmt.Sprintf("Invalid %s condition: %v", directive.Value, err), directive)
+	}
+
+	if result.Type == "string" {
+		pp.Error(fmt.Sprintf("%s condition cannot be a string", directive.Value), directive)
+	}
+
+	return result.AsFloat() != 0
+}
+
+// replaceDefined rewrites `defined(NAME)` and `defined NAME` to 1 or 0
+func (pp *Preprocessor) replaceDefined(tokens []tokenizer.Token) []tokenizer.Token {
+	var output []tokenizer.Token
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		if token.Type != tokenizer.Identifier || token.Value != "defined" {
+			output = append(output, token)
+			continue
+		}
+
+		parenthesized := i+1 < len(tokens) && tokens[i+1].Value == "("
+		nameIndex := i + 1
+		if parenthesized {
+			nameIndex++
+		}
+
+		if nameIndex >= len(tokens) || tokens[nameIndex].Type != tokenizer.Identifier {
+			pp.Error("defined expects a name", token)
+		}
+
+		if parenthesized {
+			if nameIndex+1 >= len(tokens) || tokens[nameIndex+1].Value != ")" {
+				pp.Error("Expected ) after defined(", token)
+			}
+
+			i = nameIndex + 1
+		} else {
+			i = nameIndex
+		}
+
+		value := "0"
+		if _, ok := pp.definitions[tokens[nameIndex].Value]; ok {
+			value = "1"
+		}
+
+		output = append(output, tokenizer.Token{Type: tokenizer.Number, Value: value, Line: token.Line, Column: token.Column})
+	}
+
+	return output
+}
+
+// lookup resolves names in #if conditions. As in C, names that are not defined evaluate to 0
+func (pp *Preprocessor) lookup(name string) (ast.ConstantValue, bool) {
+	definition, ok := pp.definitions[name]
+	if !ok {
+		return ast.ConstantValue{Type: "int"}, true
+	}
+
+	if len(definition.Value) == 0 || definition.Function || pp.evaluating[name] {
+		return ast.ConstantValue{}, false
+	}
+
+	pp.evaluating[name] = true
+	defer delete(pp.evaluating, name)
+
+	value, err := ast.EvaluateConstant(ast.ParseTokenExpression(pp.expand(definition.Value, nil), pp.debugMode), pp.lookup)
+	if err != nil {
+		return ast.ConstantValue{}, false
+	}
+
+	return value, true
+}
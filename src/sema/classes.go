@@ -0,0 +1,724 @@
+package sema
+
+import (
+	"fmt"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// Class is a declared class. Instances live on the heap, variables of the class hold a reference to one
+type Class struct {
+	Name        string
+	Base        *Class             // Class it inherits from, if any
+	Interfaces  []*Interface       // Interfaces the class declares it implements, not those of its bases
+	Fields      []*Symbol          // Only the fields the class declares itself
+	Methods     map[string]*Symbol // Keyed by the method's own name, the function is named `Class.method`
+	Constructor *Symbol
+	Node        *ast.ASTNode
+}
+
+// ownField looks name up among the fields the class declares itself
+func (c *Class) ownField(name string) *Symbol {
+	for _, field := range c.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// field looks name up in the class and then in its bases
+func (c *Class) field(name string) *Symbol {
+	for class := c; class != nil; class = class.Base {
+		if field := class.ownField(name); field != nil {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// method finds the implementation of a method in the class or the nearest base declaring it
+func (c *Class) method(name string) *Symbol {
+	for class := c; class != nil; class = class.Base {
+		if method := class.Methods[name]; method != nil {
+			return method
+		}
+	}
+
+	return nil
+}
+
+// constructor is the constructor of the class, or the one it inherits when it declares none
+func (c *Class) constructor() *Symbol {
+	for class := c; class != nil; class = class.Base {
+		if class.Constructor != nil {
+			return class.Constructor
+		}
+	}
+
+	return nil
+}
+
+// virtual reports whether calls of a method are dispatched through the vtable of the instance
+func (c *Class) virtual(name string) bool {
+	method := c.method(name)
+	return method != nil && (method.Node.HasModifier("virtual") || method.Node.HasModifier("override"))
+}
+
+// derives reports whether c is base or inherits from it
+func (c *Class) derives(base *Class) bool {
+	for class := c; class != nil; class = class.Base {
+		if class == base {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collectClasses registers every class name, so fields and signatures can use classes declared later
+func (a *Analyzer) collectClasses(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.ClassDeclaration:
+			if child.IsGeneric() {
+				continue
+			}
+
+			child.Name = a.qualify(child.Name)
+
+			if existing, ok := a.classes[child.Name]; ok {
+				a.errorf(child, "Class '%s' is already declared on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if existing, ok := a.structs[child.Name]; ok {
+				a.errorf(child, "'%s' is already declared as a struct on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if existing, ok := a.enums[child.Name]; ok {
+				a.errorf(child, "'%s' is already declared as an enum on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if existing, ok := a.unions[child.Name]; ok {
+				a.errorf(child, "'%s' is already declared as a union on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			a.classes[child.Name] = &Class{Name: child.Name, Methods: make(map[string]*Symbol), Node: child}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectClasses(child.Children) })
+		}
+	}
+}
+
+// inheritClasses resolves the base and the interfaces every class lists before its members. Only the
+// first may be a class
+func (a *Analyzer) inheritClasses(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.ClassDeclaration:
+			if c := a.classes[child.Name]; c != nil && c.Node == child {
+				a.inheritClass(c)
+			}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.inheritClasses(child.Children) })
+		}
+	}
+
+	// Classes inheriting from themselves, directly or not, keep no base so lookups terminate
+	var cyclic []*Class
+	for _, c := range a.classes {
+		class := c.Base
+		for steps := 0; class != nil && class != c && steps < len(a.classes); steps++ {
+			class = class.Base
+		}
+
+		if class == c {
+			a.errorf(c.Node.Children[0], "Class '%s' inherits from itself", c.Name)
+			cyclic = append(cyclic, c)
+		}
+	}
+
+	for _, c := range cyclic {
+		c.Base = nil
+	}
+}
+
+// inheritClass resolves the supertypes listed by the declaration of c
+func (a *Analyzer) inheritClass(c *Class) {
+	for k, supertype := range supertypes(c.Node) {
+		if i := a.implementedInterface("Class", c.Name, supertype, c.Interfaces); i != nil {
+			c.Interfaces = append(c.Interfaces, i)
+			continue
+		}
+
+		a.site = supertype
+		base := a.lookupClass(supertype.Name)
+		switch {
+		case a.interfaces[supertype.Name] != nil:
+		case base != nil && k == 0:
+			c.Base = base
+			supertype.Name = base.Name
+		case base != nil:
+			a.errorf(supertype, "Class '%s' can only inherit from one class, which is listed first", c.Name)
+		default:
+			a.errorf(supertype, "Class '%s' can only inherit from a class or implement an interface, '%s' is neither", c.Name, supertype.Name)
+		}
+	}
+}
+
+// analyzeClasses resolves the field types of every class and declares its methods and constructor.
+// Both are functions named after the class that receive the instance as a const `this` parameter
+func (a *Analyzer) analyzeClasses(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.ClassDeclaration:
+			if c := a.classes[child.Name]; c != nil && c.Node == child {
+				a.declareMembers(c)
+			}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.analyzeClasses(child.Children) })
+		}
+	}
+}
+
+func (a *Analyzer) declareMembers(c *Class) {
+	for _, member := range c.Node.Children {
+		switch member.Type {
+		case ast.VariableDeclaration:
+			a.declareClassField(c, member)
+		case ast.ConstructorDeclaration, ast.FunctionDeclaration:
+			a.declareMethod(c, member)
+		}
+	}
+}
+
+func (a *Analyzer) declareClassField(c *Class, field *ast.ASTNode) {
+	if c.ownField(field.Name) != nil {
+		a.errorf(field, "Class '%s' already has a field '%s'", c.Name, field.Name)
+		return
+	}
+
+	if !a.resolveType(field.Children[0], false) {
+		a.errorf(field.Children[0], "Invalid type '%s' of field '%s'", field.Children[0].Name, field.Name)
+	} else if field.HasModifier("weak") && !a.isReference(field.Children[0].Name) {
+		a.errorf(field, "Only references can be weak, '%s.%s' is %s", c.Name, field.Name, field.Children[0].Name)
+	} else if field.HasModifier("weak") && a.interfaces[field.Children[0].Name] != nil {
+		a.errorf(field, "Interface values cannot be weak, '%s.%s' is %s", c.Name, field.Name, field.Children[0].Name)
+	}
+
+	for _, modifier := range []string{"const", "ref"} {
+		if field.HasModifier(modifier) {
+			a.errorf(field, "Field '%s' of '%s' cannot be %s", field.Name, c.Name, modifier)
+		}
+	}
+
+	field.ResolvedType = field.Children[0].Name
+	c.Fields = append(c.Fields, &Symbol{Name: field.Name, Kind: Variable, Type: field.ResolvedType, Node: field})
+}
+
+// declareMethod turns a method or the constructor into a function. The constructor becomes a void
+// function named `Class.New`, which runs once the instance is allocated and its fields initialized
+func (a *Analyzer) declareMethod(c *Class, node *ast.ASTNode) {
+	name := node.Name
+	if node.Type == ast.ConstructorDeclaration {
+		if c.Constructor != nil {
+			a.errorf(node, "Class '%s' already has a constructor on line %d", c.Name, c.Constructor.Node.Line)
+			return
+		}
+
+		voidType := &ast.ASTNode{Type: ast.Identifier, Name: "void", Line: node.Line, Column: node.Column, File: node.File}
+		node.Type = ast.FunctionDeclaration
+		node.Children = append([]*ast.ASTNode{voidType}, node.Children...)
+	} else if node.IsPrototype() {
+		a.errorf(node, "Method '%s' of '%s' needs a body", name, c.Name)
+		return
+	}
+
+	if c.Methods[name] != nil {
+		a.errorf(node, "Class '%s' already has a method '%s'", c.Name, name)
+		return
+	}
+
+	if c.ownField(name) != nil {
+		a.errorf(node, "Class '%s' already has a field '%s'", c.Name, name)
+		return
+	}
+
+	fn := a.methodFunction(c.Name, node)
+	if name == "New" {
+		c.Constructor = fn
+	} else {
+		c.Methods[name] = fn
+	}
+}
+
+// checkInheritance checks the members of every class against those it inherits. Fields and methods
+// cannot be declared again, except for virtual methods, which an `override` with the same signature
+// replaces
+func (a *Analyzer) checkInheritance(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.ClassDeclaration:
+			if c := a.classes[child.Name]; c != nil && c.Node == child {
+				a.checkMembers(c)
+			}
+		case ast.ImportDeclaration:
+			a.checkInheritance(child.Children)
+		}
+	}
+}
+
+func (a *Analyzer) checkMembers(c *Class) {
+	a.checkImplementations("Class", c.Node, c.method)
+
+	for _, field := range c.Fields {
+		if c.Base != nil && c.Base.field(field.Name) != nil {
+			a.errorf(field.Node, "Class '%s' already inherits a field '%s'", c.Name, field.Name)
+		} else if c.Base != nil && c.Base.method(field.Name) != nil {
+			a.errorf(field.Node, "Class '%s' already inherits a method '%s'", c.Name, field.Name)
+		}
+	}
+
+	for _, member := range c.Node.Children {
+		fn := a.functions[member.Name]
+		if member.Type != ast.FunctionDeclaration || fn == nil || fn.Node != member || fn.Class != c.Name || fn == c.Constructor {
+			continue
+		}
+
+		name := strings.TrimPrefix(member.Name, c.Name+".")
+		override := member.HasModifier("override")
+		var inherited *Symbol
+		if c.Base != nil {
+			inherited = c.Base.method(name)
+		}
+
+		switch {
+		case override && member.HasModifier("virtual"):
+			a.errorf(member, "Method '%s' of '%s' cannot be both virtual and override", name, c.Name)
+		case c.Base != nil && c.Base.field(name) != nil:
+			a.errorf(member, "Class '%s' already inherits a field '%s'", c.Name, name)
+		case inherited == nil && override:
+			a.errorf(member, "Method '%s' of '%s' is marked override, but no base class declares it", name, c.Name)
+		case inherited == nil:
+		case !c.Base.virtual(name):
+			a.errorf(member, "Method '%s' of '%s' replaces '%s', which is not virtual", name, c.Name, inherited.Name)
+		case !override:
+			a.errorf(member, "Method '%s' of '%s' replaces virtual '%s', mark it override", name, c.Name, inherited.Name)
+		case !sameMethodSignature(fn, inherited):
+			a.errorf(member, "Override '%s' must have the signature of '%s'", fn.Name, inherited.Name)
+		}
+	}
+}
+
+// sameMethodSignature reports whether two methods return and take the same types, apart from `this`
+func sameMethodSignature(method, inherited *Symbol) bool {
+	if method.Type != inherited.Type || len(method.ParamTypes) != len(inherited.ParamTypes) {
+		return false
+	}
+
+	for i := 1; i < len(method.ParamTypes); i++ {
+		if method.ParamTypes[i] != inherited.ParamTypes[i] || refParam(method, i) != refParam(inherited, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// methodFunction declares a method of the class or struct owner as a function named `Owner.method`
+func (a *Analyzer) methodFunction(owner string, node *ast.ASTNode) *Symbol {
+	if node.IsGeneric() {
+		a.errorf(node.TypeParameters[0], "Method '%s' of '%s' cannot have type parameters, only functions and classes can", node.Name, owner)
+	}
+
+	params := node.Children[1]
+	params.Children = append([]*ast.ASTNode{receiverParameter(owner, node)}, params.Children...)
+	node.Name = owner + "." + node.Name
+
+	a.declareFunction(node)
+	fn := a.functions[node.Name]
+	fn.Class = owner
+	return fn
+}
+
+// receiverParameter is the const `this` a method of owner receives first
+func receiverParameter(owner string, node *ast.ASTNode) *ast.ASTNode {
+	return &ast.ASTNode{
+		Type:      ast.VariableDeclaration,
+		Name:      "this",
+		Children:  []*ast.ASTNode{{Type: ast.Identifier, Name: owner, Line: node.Line, Column: node.Column, File: node.File}},
+		Modifiers: []string{"const"},
+		Line:      node.Line,
+		Column:    node.Column,
+		File:      node.File,
+	}
+}
+
+// analyzeClass checks the field initializers and the bodies of the methods of a class. Like globals,
+// initializers must be known at compile time
+func (a *Analyzer) analyzeClass(node *ast.ASTNode) {
+	c := a.classes[node.Name]
+	if c == nil || c.Node != node {
+		return
+	}
+
+	for _, member := range node.Children {
+		switch {
+		case member.Type == ast.VariableDeclaration && len(member.Children) > 1:
+			valueType := a.analyzeValue(member.Children[1], member.ResolvedType)
+			if valueType == "" || member.ResolvedType == "" {
+				continue
+			}
+
+			if err := a.constantLiteral(member.Children[1]); err != nil {
+				a.errorf(member.Children[1], "Initializer of field '%s' must be a constant expression: %v", member.Name, err)
+				continue
+			}
+
+			a.checkConversion(member.Children[1], valueType, member.ResolvedType, fmt.Sprintf("initialization of '%s.%s'", c.Name, member.Name))
+		case member.Type == ast.FunctionDeclaration && a.functions[member.Name] != nil && a.functions[member.Name].Class == c.Name:
+			a.analyzeFunction(member)
+		}
+	}
+}
+
+// lookupClass resolves a class name, which may name an instance of a generic class
+func (a *Analyzer) lookupClass(name string) *Class {
+	if a.instance == nil && !strings.HasSuffix(name, ">") {
+		return a.declaredClass(name)
+	}
+
+	canonical, _ := a.canonicalType(name, false)
+	return a.classes[canonical]
+}
+
+// declaredClass resolves the name of a declared class, with the same visibility as structs
+func (a *Analyzer) declaredClass(name string) *Class {
+	if !a.visibleType(name) {
+		return nil
+	}
+
+	return a.classes[a.qualify(name)]
+}
+
+func isArray(name string) bool {
+	_, ok := ast.Element(name)
+	return ok
+}
+
+// isReference reports whether values of a type refer to memory on the heap, which classes, arrays and
+// interfaces do
+func (a *Analyzer) isReference(name string) bool {
+	return a.classes[name] != nil || isArray(name) || a.interfaces[name] != nil
+}
+
+// receivers is the number of parameters a call passes before its arguments, `this` for methods
+func receivers(fn *Symbol) int {
+	if fn.Class != "" {
+		return 1
+	}
+
+	return 0
+}
+
+// methodCall rewrites `a.b.method(args)`, parsed as a call to a dotted name, into a call of a method on
+// the value `a.b`
+func methodCall(node *ast.ASTNode) {
+	names := strings.Split(node.Name, ".")
+	receiver := &ast.ASTNode{Type: ast.Identifier, Name: names[0], Line: node.Line, Column: node.Column, File: node.File}
+
+	for _, name := range names[1 : len(names)-1] {
+		receiver = &ast.ASTNode{Type: ast.MemberAccess, Name: name, Children: []*ast.ASTNode{receiver}, Line: node.Line, Column: node.Column, File: node.File}
+	}
+
+	node.Type = ast.MethodCall
+	node.Name = names[len(names)-1]
+	node.Children = append([]*ast.ASTNode{receiver}, node.Children...)
+}
+
+// analyzeMethodCall checks `value.method(args)`: { receiver, args... }. The node is renamed to the
+// function implementing the method
+func (a *Analyzer) analyzeMethodCall(node *ast.ASTNode) string {
+	var receiverType string
+	if receiver := node.Children[0]; receiver.IsSuper() {
+		receiverType = a.analyzeSuper(node)
+	} else {
+		receiverType = a.analyzeExpression(receiver)
+	}
+
+	var argTypes []string
+	for _, arg := range node.Children[1:] {
+		argTypes = append(argTypes, a.analyzeExpression(arg))
+	}
+
+	if receiverType == "" {
+		return ""
+	}
+
+	var method *Symbol
+	kind := "Class"
+	switch c, s, i := a.classes[receiverType], a.structs[receiverType], a.interfaces[receiverType]; {
+	case c != nil && node.Children[0].IsSuper() && node.Name == "New":
+		if method = c.constructor(); method == nil {
+			a.errorf(node, "Class '%s' has no constructor", c.Name)
+			return ""
+		}
+	case c != nil:
+		method = c.method(node.Name)
+	case s != nil:
+		method, kind = s.Methods[node.Name], "Struct"
+	case i != nil:
+		method, kind = i.method(node.Name), "Interface"
+	default:
+		a.errorf(node, "%s has no method '%s'", receiverType, node.Name)
+		return ""
+	}
+
+	// A field holding a function value, such as a callback, is called like a method
+	if c, s := a.classes[receiverType], a.structs[receiverType]; method == nil && (c != nil && c.field(node.Name) != nil || s != nil && s.field(node.Name) != nil) {
+		return a.callField(node, receiverType, argTypes)
+	}
+
+	if method == nil {
+		a.errorf(node, "%s '%s' has no method '%s'", kind, receiverType, node.Name)
+		return ""
+	}
+
+	node.Name = method.Name
+	a.checkCall(node, method, argTypes)
+	return method.Type
+}
+
+// callField turns `value.field(args)` into a call of the function value held by the field: { field
+// access, args... }. The receiver and the arguments are already analyzed
+func (a *Analyzer) callField(node *ast.ASTNode, receiverType string, argTypes []string) string {
+	callee := &ast.ASTNode{Type: ast.MemberAccess, Name: node.Name, Children: []*ast.ASTNode{node.Children[0]}, Line: node.Line, Column: node.Column, File: node.File}
+	callee.ResolvedType = a.analyzeMember(callee, receiverType)
+
+	node.Type = ast.CallExpression
+	node.Name = targetName(callee)
+	node.Children[0] = callee
+
+	if callee.ResolvedType == "" {
+		return ""
+	}
+
+	return a.callValue(node, callee.ResolvedType, argTypes)
+}
+
+// analyzeSuper resolves the receiver of `super.method(args)`, which is `this` seen as an instance of the
+// base class. The call runs the base's implementation even when the method is virtual, and
+// `super.New(args)` runs the base's constructor
+func (a *Analyzer) analyzeSuper(node *ast.ASTNode) string {
+	var c *Class
+	if a.currentFunction != nil {
+		c = a.classes[a.currentFunction.Class]
+	}
+
+	switch {
+	case c == nil:
+		a.errorf(node.Children[0], "'super' can only be used in a method of a class")
+		return ""
+	case c.Base == nil:
+		a.errorf(node.Children[0], "Class '%s' has no base class", c.Name)
+		return ""
+	case node.Name == "New" && a.currentFunction != c.Constructor:
+		a.errorf(node, "'super.New(...)' can only be called from a constructor")
+	}
+
+	node.Children[0].ResolvedType = c.Base.Name
+	return c.Base.Name
+}
+
+// upcast reports whether from is a class inheriting from the class to, so its instances can be used
+// where one of to is expected
+func (a *Analyzer) upcast(from, to string) bool {
+	c, base := a.classes[from], a.classes[to]
+	return c != nil && base != nil && c != base && c.derives(base)
+}
+
+// analyzeNew checks `New Name(args)`, which allocates an instance and runs the constructor on it, and
+// `New T[length]`, which allocates an array of zeroed elements
+func (a *Analyzer) analyzeNew(node *ast.ASTNode) string {
+	if element, ok := ast.Element(node.Name); ok {
+		lengthType := a.analyzeExpression(node.Children[0])
+
+		a.site = node
+		canonical, ok := a.canonicalType(element, false)
+		if !ok {
+			a.errorf(node, "Unknown type '%s'", element)
+			return ""
+		}
+
+		if _, isInteger := integerBits[lengthType]; lengthType != "" && (!isInteger || lengthType == "bool") {
+			a.errorf(node.Children[0], "Length of an array must be an integer, got %s", lengthType)
+		}
+
+		node.Name = ast.ArrayType(canonical)
+		return node.Name
+	}
+
+	var argTypes []string
+	for _, arg := range node.Children {
+		argTypes = append(argTypes, a.analyzeExpression(arg))
+	}
+
+	a.site = node
+	c := a.lookupClass(node.Name)
+	if c == nil {
+		if a.lookupStruct(node.Name) != nil {
+			a.errorf(node, "Structs are values, write '%s{...}' instead of allocating one", node.Name)
+		} else {
+			a.errorf(node, "Unknown class '%s'", node.Name)
+		}
+		return ""
+	}
+
+	node.Name = c.Name
+	constructor := c.constructor()
+	if constructor == nil {
+		if len(node.Children) > 0 {
+			a.errorf(node, "Class '%s' has no constructor, so it takes no arguments", c.Name)
+		}
+		return c.Name
+	}
+
+	a.checkCall(node, constructor, argTypes)
+	return c.Name
+}
+
+// analyzeClassMember checks `value.field` on an instance. Methods are only reached by calling them
+func (a *Analyzer) analyzeClassMember(node *ast.ASTNode, c *Class) string {
+	if field := c.field(node.Name); field != nil {
+		return field.Type
+	}
+
+	if c.method(node.Name) != nil {
+		a.errorf(node, "Method '%s' of '%s' can only be called", node.Name, c.Name)
+		return ""
+	}
+
+	a.errorf(node, "Class '%s' has no field '%s'", c.Name, node.Name)
+	return ""
+}
+
+// analyzeArrayAccess checks `list[index]`: { array, index }
+func (a *Analyzer) analyzeArrayAccess(node *ast.ASTNode) string {
+	arrayType := a.analyzeExpression(node.Children[0])
+	indexType := a.analyzeExpression(node.Children[1])
+
+	if _, isInteger := integerBits[indexType]; indexType != "" && (!isInteger || indexType == "bool") {
+		a.errorf(node.Children[1], "Index of an array must be an integer, got %s", indexType)
+	}
+
+	if arrayType == "" {
+		return ""
+	}
+
+	element, ok := ast.Element(arrayType)
+	if !ok {
+		a.errorf(node, "Cannot index %s, it is not an array", arrayType)
+		return ""
+	}
+
+	return element
+}
+
+// analyzeArrayInitializer checks `{1, 2, 3}` stored as an array, which allocates one holding the values
+func (a *Analyzer) analyzeArrayInitializer(node *ast.ASTNode) string {
+	element, ok := ast.Element(node.Name)
+	if !ok {
+		a.errorf(node, "An array literal can only be stored in an array")
+		for _, value := range node.Children {
+			a.analyzeExpression(value)
+		}
+		return ""
+	}
+
+	for i, value := range node.Children {
+		if value.Type == ast.Assignment {
+			a.errorf(value, "Elements of an array literal cannot be named")
+			a.analyzeExpression(value.Children[1])
+			continue
+		}
+
+		valueType := a.analyzeValue(value, element)
+		a.checkConversion(value, valueType, element, fmt.Sprintf("element %d of the array", i+1))
+		a.escape(value, "is stored in an array")
+	}
+
+	return node.Name
+}
+
+// arrayLength reports whether node reads the length of an array, which cannot be written
+func arrayLength(node *ast.ASTNode) bool {
+	return node.Type == ast.MemberAccess && node.Name == "length" && isArray(node.Children[0].ResolvedType)
+}
+
+// analyzeDelete checks `delete target;`, which drops the reference a variable, field or element holds
+// by setting it to null. What it referred to is freed once nothing else refers to it
+func (a *Analyzer) analyzeDelete(node *ast.ASTNode) {
+	target := node.Children[0]
+	valueType := a.analyzeExpression(target)
+	if valueType == "" {
+		return
+	}
+
+	if !a.isReference(valueType) {
+		a.errorf(target, "Only class instances and arrays can be deleted, got %s", valueType)
+		return
+	}
+
+	symbol, ok := a.storage(target)
+	switch {
+	case !ok:
+		a.errorf(target, "'delete' needs a variable, a field or an element holding the reference")
+	case symbol == nil:
+	case symbol.Captured:
+		a.errorf(target, "'%s' is captured by value, capture it with [&%s] to delete it", symbol.Name, symbol.Name)
+	case symbol.Const:
+		a.errorf(target, "Cannot delete const '%s'", symbol.Name)
+	default:
+		written(symbol)
+	}
+}
+
+// written records that symbol changes after its declaration. A parameter that is never written can
+// rely on the caller to keep its reference alive
+func written(symbol *Symbol) {
+	if symbol.Node != nil {
+		symbol.Node.Written = true
+	}
+}
+
+// weakField reports whether node is a weak field of an instance
+func (a *Analyzer) weakField(node *ast.ASTNode) bool {
+	if node.Type != ast.MemberAccess {
+		return false
+	}
+
+	c := a.classes[node.Children[0].ResolvedType]
+	return c != nil && c.field(node.Name) != nil && c.field(node.Name).Node.HasModifier("weak")
+}
+
+// analyzeReferenceComparison checks `==` and `!=` on instances and arrays, which compare identity
+func (a *Analyzer) analyzeReferenceComparison(node *ast.ASTNode, left, right string) string {
+	if node.Name != "==" && node.Name != "!=" {
+		a.errorf(node, "Operator '%s' cannot be applied to %s", node.Name, left)
+		return ""
+	}
+
+	if left != right && left != "null" && right != "null" && !a.upcast(left, right) && !a.upcast(right, left) {
+		a.errorf(node, "Cannot compare %s with %s", left, right)
+		return ""
+	}
+
+	return "bool"
+}
@@ -0,0 +1,308 @@
+package sema
+
+import (
+	"velox.eparker.dev/src/ast"
+)
+
+// lambdaFrame tracks the captures of a lambda while its body is analyzed
+type lambdaFrame struct {
+	scope       int // The lambda's own scope, names found below it are captures
+	symbol      *Symbol
+	returned    bool // Whether a return has fixed the return type
+	byReference map[string]bool
+	captured    map[*Symbol]*Symbol // What each outer symbol resolves to inside the lambda
+	captures    []*ast.ASTNode
+}
+
+// sink is somewhere a value can outlive the function it was made in
+type sink struct {
+	node   *ast.ASTNode
+	from   []*Symbol
+	param  *Symbol // For arguments, the parameter they are passed to. The value only escapes if it does
+	what   string
+	opaque bool // Code the analysis cannot see, which is trusted with addresses but not with lambdas
+	depth  int  // Scope of the local the value is stored in, only borrows of variables declared deeper escape
+}
+
+func (a *Analyzer) currentLambda() *lambdaFrame {
+	if len(a.lambdas) == 0 || a.lambdas[len(a.lambdas)-1].symbol != a.currentFunction {
+		return nil
+	}
+
+	return a.lambdas[len(a.lambdas)-1]
+}
+
+// capture makes symbol available inside the lambda. By-value captures get a copy taken when the
+// lambda is created, by-reference captures share the variable
+func (f *lambdaFrame) capture(symbol *Symbol) *Symbol {
+	if captured, ok := f.captured[symbol]; ok {
+		return captured
+	}
+
+	captured := symbol
+	lambda := f.symbol.Node
+	capture := &ast.ASTNode{Type: ast.Identifier, Name: symbol.Name, ResolvedType: symbol.Type, Line: lambda.Line, Column: lambda.Column, File: lambda.File}
+
+	if f.byReference[symbol.Name] {
+		capture.Modifiers = []string{"ref"}
+		if f.symbol.Borrows == "" || symbol.Depth > f.symbol.Depth {
+			f.symbol.Borrows, f.symbol.Depth = symbol.Name, symbol.Depth
+		}
+	} else {
+		captured = &Symbol{Name: symbol.Name, Kind: Variable, Type: symbol.Type, Const: true, Captured: true, Node: symbol.Node, Flows: []*Symbol{symbol}}
+		f.symbol.Flows = append(f.symbol.Flows, captured)
+	}
+
+	f.captured[symbol] = captured
+	f.captures = append(f.captures, capture)
+	return captured
+}
+
+// analyzeLambda checks a lambda and infers its type. The capture list is rewritten to everything the
+// body uses from the functions around it, which is what the builder copies into the closure
+func (a *Analyzer) analyzeLambda(node *ast.ASTNode) string {
+	params, body, captures := node.Children[0], node.Children[1], node.Children[2]
+
+	frame := &lambdaFrame{
+		scope:       len(a.scopes),
+		symbol:      &Symbol{Name: "lambda", Kind: Lambda, Node: node},
+		byReference: make(map[string]bool),
+		captured:    make(map[*Symbol]*Symbol),
+	}
+
+	// Variables are captured by value unless listed with &, listing them without is only documentation
+	for _, capture := range captures.Children {
+		symbol, scope := a.find(capture.Name)
+
+		switch {
+		case symbol == nil:
+			a.errorf(capture, "Unknown identifier '%s' in capture list", capture.Name)
+		case scope == 0:
+			a.errorf(capture, "'%s' is global, lambdas use globals without capturing them", capture.Name)
+		case !capture.HasModifier("ref"):
+		case a.lookup(capture.Name).Captured:
+			a.errorf(capture, "'%s' is captured by value by the enclosing lambda, so it cannot be captured by reference here", capture.Name)
+		case symbol.Const:
+			a.errorf(capture, "'%s' is const and cannot be captured by reference", capture.Name)
+		default:
+			frame.byReference[capture.Name] = true
+			written(symbol)
+			a.aliased(capture, symbol, "captured by reference")
+		}
+	}
+
+	outerFunction, outerLoops, outerSwitches := a.currentFunction, a.loopDepth, a.switchDepth
+	a.currentFunction, a.loopDepth, a.switchDepth = frame.symbol, 0, 0
+	a.lambdas = append(a.lambdas, frame)
+	a.pushScope()
+
+	var paramTypes []string
+	for _, param := range params.Children {
+		if !a.resolveType(param.Children[0], false) {
+			a.errorf(param.Children[0], "Invalid parameter type '%s'", param.Children[0].Name)
+		}
+
+		paramType := param.Children[0].Name
+
+		if param.Name == "" {
+			a.errorf(param, "Lambda parameters need names")
+		}
+
+		if param.HasModifier("ref") {
+			a.errorf(param, "Lambda parameters cannot be ref, capture the variable with [&%s] instead", param.Name)
+		}
+
+		if param.HasModifier("weak") {
+			a.errorf(param, "Only fields of a class can be weak, '%s' is a parameter", param.Name)
+		}
+
+		param.ResolvedType = paramType
+		paramTypes = append(paramTypes, paramType)
+		a.declare(&Symbol{Name: param.Name, Kind: Parameter, Type: paramType, Const: param.HasModifier("const"), Node: param})
+	}
+
+	if body.Type == ast.Block {
+		a.analyzeStatements(body)
+
+		if !frame.returned {
+			frame.symbol.Type = "void"
+		} else if frame.symbol.Type != "void" && frame.symbol.Type != "" && !alwaysReturns(body) {
+			a.errorf(node, "Lambda does not return a value on every path")
+		}
+	} else {
+		frame.symbol.Type = a.analyzeExpression(body)
+		a.escape(body, "is returned from a lambda")
+	}
+
+	a.popScope()
+	a.lambdas = a.lambdas[:len(a.lambdas)-1]
+	a.currentFunction, a.loopDepth, a.switchDepth = outerFunction, outerLoops, outerSwitches
+
+	captures.Children = frame.captures
+	a.lambdaSymbols[node] = frame.symbol
+
+	if frame.symbol.Type == "" {
+		return ""
+	}
+
+	return ast.FunctionType(frame.symbol.Type, paramTypes)
+}
+
+// sources are the variables and lambdas an expression's value may come from
+func (a *Analyzer) sources(node *ast.ASTNode) []*Symbol {
+	switch node.Type {
+	case ast.Identifier:
+		if symbol := a.lookup(node.Name); symbol != nil {
+			return []*Symbol{symbol}
+		}
+	case ast.Lambda:
+		if symbol, ok := a.lambdaSymbols[node]; ok {
+			return []*Symbol{symbol}
+		}
+	case ast.MemberAccess:
+		return a.sources(node.Children[0])
+	case ast.UnaryExpression:
+		if symbol, ok := a.addresses[node]; ok {
+			return []*Symbol{symbol}
+		}
+	case ast.BinaryExpression, ast.CastExpression:
+		// Moving or converting a pointer keeps what it points to
+		if isPointer(node.ResolvedType) {
+			var from []*Symbol
+			for _, child := range node.Children {
+				from = append(from, a.sources(child)...)
+			}
+
+			return from
+		}
+	case ast.StructLiteral, ast.UnionLiteral:
+		var from []*Symbol
+		for _, element := range node.Children {
+			if element.Type == ast.Assignment {
+				element = element.Children[1]
+			}
+
+			from = append(from, a.sources(element)...)
+		}
+
+		return from
+	case ast.MatchExpression:
+		var from []*Symbol
+		for _, arm := range node.Children[1:] {
+			from = append(from, a.sources(arm.Children[0])...)
+		}
+
+		return from
+	}
+
+	return nil
+}
+
+// flow records that the value of node is stored in symbol. Globals outlive every function, a local
+// outlives the variables of the blocks nested in its own
+func (a *Analyzer) flow(symbol *Symbol, node *ast.ASTNode) {
+	if a.scopes[0][symbol.Name] == symbol {
+		a.escape(node, "is stored in global '"+symbol.Name+"'")
+		return
+	}
+
+	from := a.sources(node)
+	symbol.Flows = append(symbol.Flows, from...)
+
+	if len(from) > 0 {
+		a.sinks = append(a.sinks, sink{node: node, from: from, what: "is stored in '" + symbol.Name + "'", depth: symbol.Depth})
+	}
+}
+
+func (a *Analyzer) escape(node *ast.ASTNode, what string) {
+	if from := a.sources(node); len(from) > 0 {
+		a.sinks = append(a.sinks, sink{node: node, from: from, what: what})
+	}
+}
+
+// lend records a value handed to code the analysis cannot see into, an external function or a call
+// through a function pointer
+func (a *Analyzer) lend(node *ast.ASTNode, what string) {
+	if from := a.sources(node); len(from) > 0 {
+		a.sinks = append(a.sinks, sink{node: node, from: from, what: what, opaque: true})
+	}
+}
+
+func (a *Analyzer) pass(node *ast.ASTNode, param *Symbol, what string) {
+	if from := a.sources(node); len(from) > 0 {
+		a.sinks = append(a.sinks, sink{node: node, from: from, param: param, what: what})
+	}
+}
+
+// origins follows flows back to the parameters, lambdas and addresses a value may have come from
+func origins(from []*Symbol) []*Symbol {
+	var found []*Symbol
+	seen := make(map[*Symbol]bool)
+
+	var visit func(symbol *Symbol)
+	visit = func(symbol *Symbol) {
+		if seen[symbol] {
+			return
+		}
+
+		seen[symbol] = true
+		if symbol.Kind == Parameter || symbol.Kind == Lambda || symbol.Kind == Address {
+			found = append(found, symbol)
+		}
+
+		for _, next := range symbol.Flows {
+			visit(next)
+		}
+	}
+
+	for _, symbol := range from {
+		visit(symbol)
+	}
+
+	return found
+}
+
+// checkEscapes reports lambdas that capture by reference and addresses of locals that can outlive the
+// variables they borrow. A parameter escapes when its value reaches a sink, and then so does every
+// argument passed to it
+func (a *Analyzer) checkEscapes() {
+	escapes := make(map[*Symbol]bool)
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, s := range a.sinks {
+			if s.param != nil && !escapes[s.param] {
+				continue
+			}
+
+			for _, origin := range origins(s.from) {
+				if origin.Kind == Parameter && origin.Depth > s.depth && !escapes[origin] {
+					escapes[origin], changed = true, true
+				}
+			}
+		}
+	}
+
+	for _, s := range a.sinks {
+		if s.param != nil && !escapes[s.param] {
+			continue
+		}
+
+		for _, origin := range origins(s.from) {
+			if origin.Depth <= s.depth {
+				continue
+			}
+
+			if origin.Kind == Lambda && origin.Borrows != "" {
+				a.errorf(s.node, "Lambda captures '%s' by reference and %s, so it could outlive '%s'", origin.Borrows, s.what, origin.Borrows)
+				break
+			}
+
+			if origin.Kind == Address && !s.opaque {
+				a.errorf(s.node, "Address of '%s' %s, so it could outlive '%s'", origin.Borrows, s.what, origin.Borrows)
+				break
+			}
+		}
+	}
+}
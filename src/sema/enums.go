@@ -0,0 +1,207 @@
+package sema
+
+import (
+	"fmt"
+	"strconv"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// Enum is a declared enumeration. It is a type of its own, stored as its backing integer type
+type Enum struct {
+	Name     string
+	Backing  string
+	Variants []*Symbol // Constants named `Enum.Variant`, in declaration order
+	Node     *ast.ASTNode
+	analyzed bool // Whether the values of the variants are known yet
+}
+
+func (e *Enum) variant(name string) *Symbol {
+	for _, variant := range e.Variants {
+		if variant.Name == e.Name+"."+name {
+			return variant
+		}
+	}
+
+	return nil
+}
+
+// collectEnums registers every enum name and backing type, so signatures can use enums declared later
+func (a *Analyzer) collectEnums(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.EnumDeclaration:
+			child.Name = a.qualify(child.Name)
+
+			if existing, ok := a.enums[child.Name]; ok {
+				a.errorf(child, "Enum '%s' is already declared on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if existing, ok := a.structs[child.Name]; ok {
+				a.errorf(child, "'%s' is already declared as a struct on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			backing := child.Children[0].Name
+			if backing != "int" && backing != "char" {
+				a.errorf(child.Children[0], "Enum '%s' must be backed by int or char, got %s", child.Name, backing)
+				backing = "int"
+			}
+
+			a.enums[child.Name] = &Enum{Name: child.Name, Backing: backing, Node: child}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectEnums(child.Children) })
+		}
+	}
+}
+
+// analyzeEnum gives every variant its value. Values may use defines, so this happens in declaration
+// order like globals. Each value is folded into a literal for the builder
+func (a *Analyzer) analyzeEnum(node *ast.ASTNode) {
+	e := a.enums[node.Name]
+	if e == nil || e.Node != node {
+		return
+	}
+
+	next := int64(0)
+	for _, variant := range node.Children[1:] {
+		name := e.Name + "." + variant.Name
+
+		if e.variant(variant.Name) != nil {
+			a.errorf(variant, "Enum '%s' already has a variant '%s'", e.Name, variant.Name)
+			continue
+		}
+
+		if len(variant.Children) > 0 {
+			valueType := a.analyzeExpression(variant.Children[0])
+			if valueType == "" {
+				continue
+			}
+
+			if valueType != "int" && valueType != "char" {
+				a.errorf(variant.Children[0], "Value of '%s' must be an integer, got %s", name, valueType)
+				continue
+			}
+
+			value, err := ast.EvaluateConstant(variant.Children[0], a.constantLookup)
+			if err != nil {
+				a.errorf(variant.Children[0], "Value of '%s' must be a constant expression: %v", name, err)
+				continue
+			}
+
+			next = value.Int
+		}
+
+		if !fitsInteger(next, e.Backing) {
+			a.errorf(variant, "Value %d of '%s' does not fit in %s", next, name, e.Backing)
+		}
+
+		variant.Children = []*ast.ASTNode{{Type: ast.Literal, Name: strconv.FormatInt(next, 10), Line: variant.Line, Column: variant.Column, File: variant.File}}
+
+		symbol := &Symbol{Name: name, Kind: Constant, Type: e.Name, Value: &ast.ConstantValue{Type: e.Name, Int: next}, Node: variant}
+		e.Variants = append(e.Variants, symbol)
+		a.scopes[0][name] = symbol
+		next++
+	}
+
+	if len(node.Children) == 1 {
+		a.errorf(node, "Enum '%s' has no variants", e.Name)
+	}
+
+	e.analyzed = true
+}
+
+// lookupEnum resolves an enum type name, with the same visibility as structs
+func (a *Analyzer) lookupEnum(name string) *Enum {
+	if !a.visibleType(name) {
+		return nil
+	}
+
+	return a.enums[a.qualify(name)]
+}
+
+// enumOf returns the enum named by the base of `Color.Red` or `module.Color.Red`. Variables hide enums
+func (a *Analyzer) enumOf(base *ast.ASTNode) *Enum {
+	switch {
+	case base.Type == ast.Identifier && a.lookup(base.Name) == nil:
+		return a.lookupEnum(base.Name)
+	case base.Type == ast.MemberAccess && base.Children[0].Type == ast.Identifier && a.lookup(base.Children[0].Name) == nil:
+		return a.lookupEnum(base.Children[0].Name + "." + base.Name)
+	}
+
+	return nil
+}
+
+// analyzeEnumConstant rewrites `Color.Red` into the identifier of the variant's constant
+func (a *Analyzer) analyzeEnumConstant(node *ast.ASTNode, e *Enum) string {
+	if !e.analyzed {
+		a.errorf(node, "Enum '%s' is used before its declaration on line %d", e.Name, e.Node.Line)
+		return ""
+	}
+
+	variant := e.variant(node.Name)
+	if variant == nil {
+		a.errorf(node, "Enum '%s' has no variant '%s'", e.Name, node.Name)
+		return ""
+	}
+
+	node.Type = ast.Identifier
+	node.Name = variant.Name
+	node.Children = nil
+	return a.analyzeIdentifier(node)
+}
+
+// analyzeEnumComparison checks `==` and `!=` on enums, which compare two values of the same enum
+func (a *Analyzer) analyzeEnumComparison(node *ast.ASTNode, left, right string) string {
+	if node.Name != "==" && node.Name != "!=" {
+		a.errorf(node, "Operator '%s' cannot be applied to %s", node.Name, left)
+		return ""
+	}
+
+	if left != right {
+		a.errorf(node, "Cannot compare %s with %s", left, right)
+		return ""
+	}
+
+	return "bool"
+}
+
+// castable reports whether an explicit cast converts from one type to the other. Enums convert to and
+// from integers, pointers to other pointers and to and from integers, everything else numeric converts
+// freely
+func (a *Analyzer) castable(from, to string) bool {
+	_, fromEnum := a.enums[from]
+	_, toEnum := a.enums[to]
+	_, fromInteger := integerBits[from]
+	_, toInteger := integerBits[to]
+
+	switch {
+	case from == to || a.upcast(from, to) || a.implements(from, to):
+		return true
+	case fromEnum || toEnum:
+		return (fromEnum || fromInteger) && (toEnum || toInteger)
+	case isPointer(from) || isPointer(to) || from == "null":
+		return (isPointer(from) || fromInteger || from == "null") && (isPointer(to) || toInteger)
+	}
+
+	return isNumeric(from) && isNumeric(to)
+}
+
+// storageType is the type values of name are stored as, which for enums is their backing integer
+func (a *Analyzer) storageType(name string) string {
+	if e, ok := a.enums[name]; ok {
+		return e.Backing
+	}
+
+	return name
+}
+
+// enumHint suggests an explicit conversion when an enum is converted implicitly
+func (a *Analyzer) enumHint(from, to string) string {
+	if a.enums[from] != nil || a.enums[to] != nil {
+		return fmt.Sprintf(", write 'as %s' to convert it", to)
+	}
+
+	return ""
+}
@@ -0,0 +1,355 @@
+package sema
+
+import (
+	"fmt"
+	"slices"
+
+	"velox.eparker.dev/src/ast"
+	"velox.eparker.dev/src/tokenizer"
+)
+
+// Generic is a declared generic function or class. It is never compiled itself: every distinct list of
+// type arguments it is used with gets a copy of the declaration, analyzed with the arguments in place
+// of the parameters
+type Generic struct {
+	Name        string
+	Node        *ast.ASTNode
+	Module      string       // Prefix of the module declaring it, which its body is analyzed in
+	Parameters  []string     // Names of the type parameters
+	Constraints []*Interface // Interface each type argument must implement, nil where unconstrained
+}
+
+// instance is an instantiation of a generic, the copy of its declaration and the types it substitutes
+type instance struct {
+	generic   *Generic
+	node      *ast.ASTNode
+	arguments map[string]string // Type arguments keyed by the parameter they replace
+	notes     []string          // Where the instantiation was requested, innermost first
+}
+
+// maxInstantiationDepth bounds instantiations requesting further ones, which a generic using itself
+// with ever larger types would do forever
+const maxInstantiationDepth = 32
+
+// collectGenerics registers every generic function and class and resolves the interfaces constraining
+// their type parameters
+func (a *Analyzer) collectGenerics(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch {
+		case child.IsGeneric():
+			child.Name = a.qualify(child.Name)
+
+			if existing, ok := a.generics[child.Name]; ok {
+				a.errorf(child, "Generic '%s' is already declared on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if kind, line := a.declaredType(child.Name); kind != "" {
+				a.errorf(child, "'%s' is already declared as %s on line %d", child.Name, kind, line)
+				continue
+			}
+
+			g := &Generic{Name: child.Name, Node: child, Module: a.module}
+			for _, param := range child.TypeParameters {
+				if slices.Contains(g.Parameters, param.Name) {
+					a.errorf(param, "Type parameter '%s' of '%s' is already declared", param.Name, child.Name)
+				}
+
+				var constraint *Interface
+				if len(param.Children) > 0 {
+					if constraint = a.lookupInterface(param.Children[0].Name); constraint == nil {
+						a.errorf(param.Children[0], "Constraint '%s' of '%s' is not an interface", param.Children[0].Name, param.Name)
+					}
+				}
+
+				g.Parameters = append(g.Parameters, param.Name)
+				g.Constraints = append(g.Constraints, constraint)
+			}
+
+			a.generics[child.Name] = g
+		case child.Type == ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectGenerics(child.Children) })
+		}
+	}
+}
+
+// lookupGeneric resolves the name of a generic, with the same visibility as structs
+func (a *Analyzer) lookupGeneric(name string) *Generic {
+	if !a.visibleType(name) {
+		return nil
+	}
+
+	return a.generics[a.qualify(name)]
+}
+
+// within runs analyze on the instance inst, in the module declaring its generic
+func (a *Analyzer) within(inst *instance, analyze func()) {
+	outerModule, outerInstance := a.module, a.instance
+	a.module, a.instance = inst.generic.Module, inst
+	analyze()
+	a.module, a.instance = outerModule, outerInstance
+}
+
+// newInstance starts the instantiation of g named name for the canonical type arguments args, noting
+// the position that requested it
+func (a *Analyzer) newInstance(g *Generic, name string, args []string, site *ast.ASTNode) *instance {
+	inst := &instance{generic: g, node: g.Node.Clone(), arguments: make(map[string]string)}
+	inst.node.Name = name
+	inst.node.TypeParameters = nil
+
+	for i, param := range g.Parameters {
+		inst.arguments[param] = args[i]
+	}
+
+	inst.notes = []string{fmt.Sprintf("%s: note: in instantiation of '%s' requested here", tokenizer.FormatPosition(site.File, site.Line, site.Column), name)}
+	if a.instance != nil {
+		inst.notes = append(inst.notes, a.instance.notes...)
+	}
+
+	a.instances = append(a.instances, inst)
+	return inst
+}
+
+// typeSite is where a type is being resolved, which instantiations of generic classes report at
+func (a *Analyzer) typeSite(g *Generic) *ast.ASTNode {
+	if a.site != nil {
+		return a.site
+	}
+
+	return g.Node
+}
+
+// instantiateClass resolves `List<int>`, the instance of the generic class name for the type arguments
+// args, declaring it on first use. Its methods are checked once all declarations are analyzed
+func (a *Analyzer) instantiateClass(name string, args []string) (string, bool) {
+	g := a.lookupGeneric(name)
+	if g == nil {
+		return "", false
+	}
+
+	site := a.typeSite(g)
+	if g.Node.Type != ast.ClassDeclaration {
+		a.errorf(site, "Generic function '%s' is not a type", g.Name)
+		return "", false
+	}
+
+	args = slices.Clone(args)
+	for i, arg := range args {
+		canonical, ok := a.canonicalType(arg, false)
+		if !ok {
+			return "", false
+		}
+
+		args[i] = canonical
+	}
+
+	if len(args) != len(g.Parameters) {
+		a.errorf(site, "Class '%s' takes %d type argument(s), got %d", g.Name, len(g.Parameters), len(args))
+		return "", false
+	}
+
+	name = ast.GenericType(g.Name, args)
+	if _, ok := a.classes[name]; ok {
+		return name, true
+	}
+
+	if !a.checkConstraints(site, g, args) || !a.checkDepth(site, name) {
+		return "", false
+	}
+
+	inst := a.newInstance(g, name, args, site)
+	c := &Class{Name: name, Methods: make(map[string]*Symbol), Node: inst.node}
+	a.classes[name] = c
+
+	a.within(inst, func() {
+		a.inheritClass(c)
+		if c.Base != nil && c.Base.derives(c) {
+			a.errorf(inst.node, "Class '%s' inherits from itself", c.Name)
+			c.Base = nil
+		}
+
+		a.declareMembers(c)
+	})
+
+	return name, true
+}
+
+// instantiateFunction resolves a call of the generic function g to the instance for the type arguments
+// given by the call or inferred from the types of its arguments, argTypes
+func (a *Analyzer) instantiateFunction(node *ast.ASTNode, g *Generic, argTypes []string) *Symbol {
+	if g.Node.Type != ast.FunctionDeclaration {
+		a.errorf(node, "Instances of '%s' are allocated with 'New %s<...>(...)'", g.Name, node.Name)
+		return nil
+	}
+
+	args := make([]string, len(g.Parameters))
+	if len(node.TypeArguments) > 0 {
+		if len(node.TypeArguments) != len(g.Parameters) {
+			a.errorf(node, "Function '%s' takes %d type argument(s), got %d", g.Name, len(g.Parameters), len(node.TypeArguments))
+			return nil
+		}
+
+		for i, arg := range node.TypeArguments {
+			if !a.resolveType(arg, false) {
+				a.errorf(arg, "Unknown type '%s'", arg.Name)
+				return nil
+			}
+
+			args[i] = arg.Name
+		}
+	} else if !a.inferTypeArguments(node, g, argTypes, args) {
+		return nil
+	}
+
+	name := ast.GenericType(g.Name, args)
+	if fn, ok := a.functions[name]; ok {
+		return fn
+	}
+
+	if !a.checkConstraints(node, g, args) || !a.checkDepth(node, name) {
+		return nil
+	}
+
+	inst := a.newInstance(g, name, args, node)
+	var fn *Symbol
+	a.within(inst, func() { fn = a.functionSymbol(inst.node) })
+
+	a.functions[name] = fn
+	return fn
+}
+
+// inferTypeArguments fills args with the type arguments the arguments of a call of g imply
+func (a *Analyzer) inferTypeArguments(node *ast.ASTNode, g *Generic, argTypes, args []string) bool {
+	from := make([]int, len(args))
+	ok := true
+
+	for i, param := range g.Node.Children[1].Children {
+		if i >= len(argTypes) {
+			break
+		}
+
+		infer(g, param.Children[0].Name, argTypes[i], func(k int, t string) {
+			switch {
+			case args[k] == "":
+				args[k], from[k] = t, i+1
+			case args[k] != t && ok:
+				a.errorf(node, "Type parameter '%s' of '%s' is %s from argument %d but %s from argument %d", g.Parameters[k], g.Name, args[k], from[k], t, i+1)
+				ok = false
+			}
+		})
+	}
+
+	for k, arg := range args {
+		if arg == "" && ok {
+			a.errorf(node, "Cannot infer type parameter '%s' of '%s', give the type arguments explicitly", g.Parameters[k], g.Name)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// infer matches param, the type of a parameter of g as written, against arg, the type of the argument
+// passed for it, and binds each type parameter it uses to the part of arg in its place
+func infer(g *Generic, param, arg string, bind func(k int, t string)) {
+	if arg == "" || arg == "null" {
+		return
+	}
+
+	if k := slices.Index(g.Parameters, param); k >= 0 {
+		bind(k, arg)
+		return
+	}
+
+	if pointee, ok := ast.Pointee(param); ok {
+		if argPointee, ok := ast.Pointee(arg); ok {
+			infer(g, pointee, argPointee, bind)
+		}
+		return
+	}
+
+	if element, ok := ast.Element(param); ok {
+		if argElement, ok := ast.Element(arg); ok {
+			infer(g, element, argElement, bind)
+		}
+		return
+	}
+
+	if ret, params, ok := ast.ParseFunctionType(param); ok {
+		if argRet, argParams, ok := ast.ParseFunctionType(arg); ok && len(params) == len(argParams) {
+			infer(g, ret, argRet, bind)
+			for i := range params {
+				infer(g, params[i], argParams[i], bind)
+			}
+		}
+		return
+	}
+
+	if _, params, ok := ast.ParseGenericType(param); ok {
+		if _, argParams, ok := ast.ParseGenericType(arg); ok && len(params) == len(argParams) {
+			for i := range params {
+				infer(g, params[i], argParams[i], bind)
+			}
+		}
+	}
+}
+
+// checkConstraints checks that every type argument of an instantiation of g implements the interface
+// constraining its parameter. Calls of its methods in the instance then go straight to the implementation
+func (a *Analyzer) checkConstraints(site *ast.ASTNode, g *Generic, args []string) bool {
+	ok := true
+	for k, constraint := range g.Constraints {
+		if constraint != nil && args[k] != constraint.Name && !a.implements(args[k], constraint.Name) {
+			a.errorf(site, "Type argument %s of '%s' does not implement '%s', which constrains '%s'", args[k], g.Name, constraint.Name, g.Parameters[k])
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// checkDepth stops instantiations nested beyond maxInstantiationDepth
+func (a *Analyzer) checkDepth(site *ast.ASTNode, name string) bool {
+	if a.instance != nil && len(a.instance.notes) >= maxInstantiationDepth {
+		a.errorf(site, "Instantiation of '%s' is nested more than %d deep", name, maxInstantiationDepth)
+		return false
+	}
+
+	return true
+}
+
+// instanceType resolves a type named inside an instance. The type arguments are already canonical and
+// may name types of any module, and the generic's own name means the instance being analyzed
+func (a *Analyzer) instanceType(name string) (string, bool) {
+	if a.instance.node.Type == ast.ClassDeclaration && a.qualify(name) == a.instance.generic.Name {
+		return a.instance.node.Name, true
+	}
+
+	switch {
+	case a.structs[name] != nil, a.enums[name] != nil, a.unions[name] != nil, a.classes[name] != nil, a.interfaces[name] != nil:
+		return name, true
+	}
+
+	return "", false
+}
+
+// analyzeInstances checks the bodies of every instance, including those they instantiate in turn, and
+// adds them to the program in place of their generic
+func (a *Analyzer) analyzeInstances() {
+	for len(a.instances) > 0 {
+		inst := a.instances[0]
+		a.instances = a.instances[1:]
+
+		a.within(inst, func() {
+			switch inst.node.Type {
+			case ast.ClassDeclaration:
+				a.checkMembers(a.classes[inst.node.Name])
+				a.analyzeClass(inst.node)
+			case ast.FunctionDeclaration:
+				a.analyzeFunction(inst.node)
+			}
+		})
+
+		a.program.Children = append(a.program.Children, inst.node)
+	}
+}
@@ -0,0 +1,202 @@
+package sema
+
+import (
+	"slices"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// Interface is a declared interface, methods that the classes and structs implementing it provide. A
+// value of the interface refers to an instance of any of them
+type Interface struct {
+	Name    string
+	Methods []*Symbol // In declaration order, which is the order of the method table. Named `Interface.method`
+	Node    *ast.ASTNode
+}
+
+func (i *Interface) method(name string) *Symbol {
+	for _, method := range i.Methods {
+		if method.Name == i.Name+"."+name {
+			return method
+		}
+	}
+
+	return nil
+}
+
+// collectInterfaces registers every interface name, so signatures can use interfaces declared later
+func (a *Analyzer) collectInterfaces(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.InterfaceDeclaration:
+			child.Name = a.qualify(child.Name)
+
+			if existing, ok := a.interfaces[child.Name]; ok {
+				a.errorf(child, "Interface '%s' is already declared on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if kind, line := a.declaredType(child.Name); kind != "" {
+				a.errorf(child, "'%s' is already declared as %s on line %d", child.Name, kind, line)
+				continue
+			}
+
+			a.interfaces[child.Name] = &Interface{Name: child.Name, Node: child}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectInterfaces(child.Children) })
+		}
+	}
+}
+
+// declaredType finds a struct, enum, union or class named name, returning what it is and where
+func (a *Analyzer) declaredType(name string) (string, int) {
+	switch {
+	case a.structs[name] != nil:
+		return "a struct", a.structs[name].Node.Line
+	case a.enums[name] != nil:
+		return "an enum", a.enums[name].Node.Line
+	case a.unions[name] != nil:
+		return "a union", a.unions[name].Node.Line
+	case a.classes[name] != nil:
+		return "a class", a.classes[name].Node.Line
+	}
+
+	return "", 0
+}
+
+// analyzeInterfaces resolves the signatures of the methods of every interface. Like methods of classes
+// they receive the value as `this` before their parameters
+func (a *Analyzer) analyzeInterfaces(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.InterfaceDeclaration:
+			i := a.interfaces[child.Name]
+			if i == nil || i.Node != child {
+				continue
+			}
+
+			for _, method := range child.Children {
+				if i.method(method.Name) != nil {
+					a.errorf(method, "Interface '%s' already has a method '%s'", i.Name, method.Name)
+					continue
+				}
+
+				params := method.Children[1]
+				params.Children = append([]*ast.ASTNode{receiverParameter(i.Name, method)}, params.Children...)
+				method.Name = i.Name + "." + method.Name
+
+				symbol := a.functionSymbol(method)
+				symbol.Class = i.Name
+				i.Methods = append(i.Methods, symbol)
+			}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.analyzeInterfaces(child.Children) })
+		}
+	}
+}
+
+// lookupInterface resolves an interface name, with the same visibility as structs
+func (a *Analyzer) lookupInterface(name string) *Interface {
+	if !a.visibleType(name) {
+		return nil
+	}
+
+	return a.interfaces[a.qualify(name)]
+}
+
+// supertypes lists the base and the interfaces named by the declaration of a class or struct, the
+// identifiers before its members
+func supertypes(node *ast.ASTNode) []*ast.ASTNode {
+	n := 0
+	for n < len(node.Children) && node.Children[n].Type == ast.Identifier {
+		n++
+	}
+
+	return node.Children[:n]
+}
+
+// implementedInterface resolves an interface listed by the declaration of kind owner, rewriting the name
+// to the qualified one. Nothing is returned when it is no interface or listed twice
+func (a *Analyzer) implementedInterface(kind, owner string, supertype *ast.ASTNode, listed []*Interface) *Interface {
+	i := a.lookupInterface(supertype.Name)
+	if i == nil {
+		return nil
+	}
+
+	supertype.Name = i.Name
+	if slices.Contains(listed, i) {
+		a.errorf(supertype, "%s '%s' already implements '%s'", kind, owner, i.Name)
+		return nil
+	}
+
+	return i
+}
+
+// interfacesOf lists the interfaces a class, including those its bases implement, or a struct implements
+func (a *Analyzer) interfacesOf(name string) []*Interface {
+	if s, ok := a.structs[name]; ok {
+		return s.Interfaces
+	}
+
+	var interfaces []*Interface
+	for c := a.classes[name]; c != nil; c = c.Base {
+		interfaces = append(interfaces, c.Interfaces...)
+	}
+
+	return interfaces
+}
+
+// implements reports whether values of from can be used as values of the interface to
+func (a *Analyzer) implements(from, to string) bool {
+	i := a.interfaces[to]
+	return i != nil && slices.Contains(a.interfacesOf(from), i)
+}
+
+// checkImplementations checks that the class or struct declared by node provides every method of the
+// interfaces it lists, with the signature the interface gives it. method finds an implementation by name
+func (a *Analyzer) checkImplementations(kind string, node *ast.ASTNode, method func(name string) *Symbol) {
+	var checked []*Interface
+	for _, supertype := range supertypes(node) {
+		i := a.interfaces[supertype.Name]
+		if i == nil || slices.Contains(checked, i) {
+			continue
+		}
+
+		checked = append(checked, i)
+
+		for _, required := range i.Methods {
+			name := strings.TrimPrefix(required.Name, i.Name+".")
+
+			switch fn := method(name); {
+			case fn == nil:
+				a.errorf(supertype, "%s '%s' does not implement '%s' of interface '%s', declared as %s", kind, node.Name, name, i.Name, signature(required))
+			case !sameMethodSignature(fn, required):
+				a.errorf(fn.Node, "Method '%s' is %s, but interface '%s' declares '%s' as %s", fn.Name, signature(fn), i.Name, name, signature(required))
+			}
+		}
+	}
+}
+
+// signature spells the type of a method without its `this`, e.g. `int(float)`
+func signature(method *Symbol) string {
+	params := slices.Clone(method.ParamTypes[1:])
+	for i := range params {
+		if refParam(method, i+1) {
+			params[i] = "ref " + params[i]
+		}
+	}
+
+	return ast.FunctionType(method.Type, params)
+}
+
+// dispatched reports whether a call of fn may run another implementation chosen at runtime, an override
+// of a virtual method or the method of whatever an interface value refers to
+func (a *Analyzer) dispatched(fn *Symbol) bool {
+	if a.interfaces[fn.Class] != nil {
+		return true
+	}
+
+	c := a.classes[fn.Class]
+	return c != nil && c.virtual(strings.TrimPrefix(fn.Name, fn.Class+"."))
+}
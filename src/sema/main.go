@@ -0,0 +1,1529 @@
+package sema
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+	"velox.eparker.dev/src/tokenizer"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+var SeverityNames map[Severity]string = map[Severity]string{
+	Error:   "error",
+	Warning: "warning",
+}
+
+type Diagnostic struct {
+	Severity     Severity
+	Message      string
+	Line, Column int
+	File         string
+	Expansion    *tokenizer.Expansion
+	Notes        []string // Instantiations of generics the diagnostic was reported in, innermost first
+}
+
+func (d Diagnostic) String() string {
+	result := fmt.Sprintf("%s: %s: %s", tokenizer.FormatPosition(d.File, d.Line, d.Column), SeverityNames[d.Severity], d.Message)
+
+	for _, note := range append(d.Expansion.Notes(), d.Notes...) {
+		result += "\n" + note
+	}
+
+	return result
+}
+
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+type SymbolKind int
+
+const (
+	Variable SymbolKind = iota
+	Parameter
+	Constant
+	Function
+	Lambda
+	Address // The address of a local, which borrows it like a lambda capturing it by reference
+)
+
+type Symbol struct {
+	Name       string
+	Kind       SymbolKind
+	Type       string   // Value type, or return type for functions
+	ParamTypes []string // Only set for functions
+	Variadic   bool
+	Const      bool
+	Defined    bool               // False for functions that only have a prototype so far
+	Assigned   bool               // Whether a write to the variable has been seen yet
+	Aliased    *ast.ASTNode       // Where the variable was first handed out by address, by reference or to a [&] capture
+	Value      *ast.ConstantValue // Compile-time value of constants
+	Node       *ast.ASTNode
+	Params     []*Symbol // Parameters of defined functions, shared with the body's scope
+	Captured   bool      // A lambda's copy of a variable it captures by value
+	Borrows    string    // For lambdas, a variable they capture by reference
+	Flows      []*Symbol // Variables and lambdas whose value may have been stored in this one
+	Class      string    // For methods and constructors, the class, struct or interface of the `this` they receive first
+	Depth      int       // Scope declaring the symbol, for lambdas and addresses that of the variable they borrow
+}
+
+type Analyzer struct {
+	program         *ast.ASTNode
+	scopes          []map[string]*Symbol
+	functions       map[string]*Symbol
+	currentFunction *Symbol
+	loopDepth       int
+	switchDepth     int
+	unsafeDepth     int
+	warnShadowing   bool
+	allowMaybe      bool
+	diagnostics     []Diagnostic
+	module          string                     // Prefix of the module being analyzed, e.g. "math.", empty for the main file
+	imports         map[string]map[string]bool // Modules imported by each module, keyed by prefix
+	structs         map[string]*Struct
+	enums           map[string]*Enum
+	unions          map[string]*Union
+	classes         map[string]*Class
+	interfaces      map[string]*Interface
+	generics        map[string]*Generic
+	instances       []*instance    // Instances of generics whose bodies are not analyzed yet
+	instance        *instance      // Instance being analyzed, nil outside of generics
+	site            *ast.ASTNode   // Where the type being resolved is written, for errors instantiating it
+	lambdas         []*lambdaFrame // Lambdas being analyzed, outermost first
+	lambdaSymbols   map[*ast.ASTNode]*Symbol
+	addresses       map[*ast.ASTNode]*Symbol // The `&x` expressions taking the address of a local
+	sinks           []sink
+}
+
+func NewAnalyzer(program *ast.ASTNode) *Analyzer {
+	return &Analyzer{
+		program:       program,
+		scopes:        []map[string]*Symbol{make(map[string]*Symbol)},
+		functions:     make(map[string]*Symbol),
+		imports:       make(map[string]map[string]bool),
+		structs:       make(map[string]*Struct),
+		enums:         make(map[string]*Enum),
+		unions:        make(map[string]*Union),
+		classes:       make(map[string]*Class),
+		interfaces:    make(map[string]*Interface),
+		generics:      make(map[string]*Generic),
+		lambdaSymbols: make(map[*ast.ASTNode]*Symbol),
+		addresses:     make(map[*ast.ASTNode]*Symbol),
+		allowMaybe:    true,
+	}
+}
+
+// SetWarnShadowing enables warnings when a declaration in an inner block hides an outer one
+func (a *Analyzer) SetWarnShadowing(enabled bool) *Analyzer {
+	a.warnShadowing = enabled
+	return a
+}
+
+// Analyze resolves every symbol in the program, checks types and annotates expressions with their resolved type
+func (a *Analyzer) Analyze() []Diagnostic {
+	a.declareBuiltins()
+
+	// Types and functions are collected first so that bodies can reference each other regardless of
+	// order, which also makes mutual recursion work without prototypes
+	a.collectImports(a.program.Children)
+	a.collectStructs(a.program.Children)
+	a.collectEnums(a.program.Children)
+	a.collectUnions(a.program.Children)
+	a.collectClasses(a.program.Children)
+	a.collectInterfaces(a.program.Children)
+	a.collectGenerics(a.program.Children)
+	a.inheritClasses(a.program.Children)
+	a.analyzeStructs(a.program.Children)
+	a.analyzeUnions(a.program.Children)
+	a.analyzeClasses(a.program.Children)
+	a.analyzeInterfaces(a.program.Children)
+	a.checkInheritance(a.program.Children)
+	a.checkRecursion(a.program.Children)
+	a.collectFunctions(a.program.Children)
+	a.analyzeDeclarations(a.program.Children)
+	a.analyzeInstances()
+	a.checkEscapes()
+
+	// Files keep the order their first diagnostic was reported in
+	files := make(map[string]int)
+	for _, d := range a.diagnostics {
+		if _, ok := files[d.File]; !ok {
+			files[d.File] = len(files)
+		}
+	}
+
+	sort.SliceStable(a.diagnostics, func(i, j int) bool {
+		if a.diagnostics[i].File != a.diagnostics[j].File {
+			return files[a.diagnostics[i].File] < files[a.diagnostics[j].File]
+		}
+
+		if a.diagnostics[i].Line != a.diagnostics[j].Line {
+			return a.diagnostics[i].Line < a.diagnostics[j].Line
+		}
+
+		return a.diagnostics[i].Column < a.diagnostics[j].Column
+	})
+
+	return a.diagnostics
+}
+
+func (a *Analyzer) collectFunctions(declarations []*ast.ASTNode) {
+	// Only functions the module defines get its prefix, prototypes of anything else refer to external code
+	defined := make(map[string]bool)
+	for _, child := range declarations {
+		if child.Type == ast.FunctionDeclaration && !child.IsPrototype() {
+			defined[child.Name] = true
+		}
+	}
+
+	for _, child := range declarations {
+		switch {
+		case child.IsGeneric():
+		case child.Type == ast.FunctionDeclaration:
+			if defined[child.Name] {
+				child.Name = a.qualify(child.Name)
+			}
+
+			if g, ok := a.generics[child.Name]; ok {
+				a.errorf(child, "Function '%s' is already declared as a generic on line %d", child.Name, g.Node.Line)
+				continue
+			}
+
+			a.declareFunction(child)
+		case child.Type == ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectFunctions(child.Children) })
+		}
+	}
+}
+
+// collectImports records the modules every module imports, which makes their types and functions visible
+func (a *Analyzer) collectImports(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		if child.Type == ast.ImportDeclaration {
+			if a.imports[a.module] == nil {
+				a.imports[a.module] = make(map[string]bool)
+			}
+
+			a.imports[a.module][child.Name] = true
+			a.inModule(child, func() { a.collectImports(child.Children) })
+		}
+	}
+}
+
+func (a *Analyzer) analyzeDeclarations(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.PreprocessorDirective:
+			a.analyzePreprocessorDirective(child)
+		case ast.VariableDeclaration:
+			a.analyzeGlobalVariable(child)
+		case ast.EnumDeclaration:
+			a.analyzeEnum(child)
+		case ast.ClassDeclaration:
+			a.analyzeClass(child)
+		case ast.StructDeclaration:
+			a.analyzeStruct(child)
+		case ast.FunctionDeclaration:
+			if !child.IsPrototype() && !child.IsGeneric() {
+				a.analyzeFunction(child)
+			}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.analyzeDeclarations(child.Children) })
+		}
+	}
+}
+
+// inModule runs analyze with the top-level names of the imported module in scope
+func (a *Analyzer) inModule(module *ast.ASTNode, analyze func()) {
+	outer := a.module
+	a.module = module.Name + "."
+	analyze()
+	a.module = outer
+}
+
+// qualify returns the global name of a top-level declaration of the current module. Declarations of
+// imported modules are renamed in place, so later stages see `math.sqrt` rather than `sqrt`
+func (a *Analyzer) qualify(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+
+	return a.module + name
+}
+
+// lookupFunction resolves a call. Builtins and external prototypes are visible from every module
+func (a *Analyzer) lookupFunction(node *ast.ASTNode, name string) *Symbol {
+	if module, member, qualified := strings.Cut(name, "."); qualified {
+		if !a.imports[a.module][module] {
+			a.errorf(node, "Module '%s' is not imported", module)
+			return nil
+		}
+
+		if fn, ok := a.functions[name]; ok {
+			return fn
+		}
+
+		a.errorf(node, "Module '%s' has no function '%s'", module, member)
+		return nil
+	}
+
+	if fn, ok := a.functions[a.qualify(name)]; ok {
+		return fn
+	}
+
+	if fn, ok := a.functions[name]; ok && (a.module == "" || !fn.Defined) {
+		return fn
+	}
+
+	return nil
+}
+
+func (a *Analyzer) errorf(node *ast.ASTNode, format string, args ...any) {
+	a.diagnostics = append(a.diagnostics, Diagnostic{Error, fmt.Sprintf(format, args...), node.Line, node.Column, node.File, node.Expansion, a.instanceNotes()})
+}
+
+func (a *Analyzer) warnf(node *ast.ASTNode, format string, args ...any) {
+	a.diagnostics = append(a.diagnostics, Diagnostic{Warning, fmt.Sprintf(format, args...), node.Line, node.Column, node.File, node.Expansion, a.instanceNotes()})
+}
+
+func (a *Analyzer) instanceNotes() []string {
+	if a.instance == nil {
+		return nil
+	}
+
+	return a.instance.notes
+}
+
+func (a *Analyzer) declareBuiltins() {
+	a.functions["printf"] = &Symbol{Name: "printf", Kind: Function, Type: "void", Variadic: true}
+}
+
+func (a *Analyzer) pushScope() {
+	a.scopes = append(a.scopes, make(map[string]*Symbol))
+}
+
+func (a *Analyzer) popScope() {
+	a.scopes = a.scopes[:len(a.scopes)-1]
+}
+
+func (a *Analyzer) declare(symbol *Symbol) {
+	scope := a.scopes[len(a.scopes)-1]
+
+	if _, exists := scope[symbol.Name]; exists {
+		a.errorf(symbol.Node, "'%s' is already declared in this scope", symbol.Name)
+		return
+	}
+
+	if outer, _ := a.find(symbol.Name); outer != nil && a.warnShadowing {
+		a.warnf(symbol.Node, "'%s' shadows the declaration on line %d", symbol.Name, outer.Node.Line)
+	}
+
+	symbol.Depth = len(a.scopes) - 1
+	scope[symbol.Name] = symbol
+}
+
+// lookup resolves a name. A local of the function around a lambda becomes one of the lambda's captures
+func (a *Analyzer) lookup(name string) *Symbol {
+	symbol, scope := a.find(name)
+
+	for _, frame := range a.lambdas {
+		if symbol != nil && scope > 0 && frame.scope > scope {
+			symbol = frame.capture(symbol)
+		}
+	}
+
+	return symbol
+}
+
+// find resolves a name without capturing it, returning the index of the scope it was found in
+func (a *Analyzer) find(name string) (*Symbol, int) {
+	for i := len(a.scopes) - 1; i > 0; i-- {
+		if symbol, ok := a.scopes[i][name]; ok {
+			return symbol, i
+		}
+	}
+
+	// Globals only see the current module's own names
+	return a.scopes[0][a.qualify(name)], 0
+}
+
+func (a *Analyzer) analyzePreprocessorDirective(node *ast.ASTNode) {
+	name := a.qualify(node.Children[0].Name)
+	node.Children[0].Name = name
+
+	switch node.Name {
+	case "#define":
+		symbol := &Symbol{Name: name, Kind: Constant, Node: node.Children[0]}
+
+		// Defines keep the type of their value, a define without one can only be tested for existence
+		if len(node.Children) > 1 {
+			valueType := a.analyzeExpression(node.Children[1])
+
+			value, err := ast.EvaluateConstant(node.Children[1], a.constantLookup)
+			if err != nil {
+				if valueType != "" {
+					a.errorf(node.Children[1], "Value of '%s' must be a constant expression: %v", name, err)
+				}
+				return
+			}
+
+			symbol.Type = value.Type
+			symbol.Value = &value
+		}
+
+		// A define lasts to the end of the file wherever it is written, so it always joins the global scope
+		if _, exists := a.scopes[0][name]; exists {
+			a.errorf(symbol.Node, "'%s' is already declared in this scope", name)
+			return
+		}
+
+		a.scopes[0][name] = symbol
+	case "#undef":
+		if symbol, ok := a.scopes[0][name]; !ok || symbol.Kind != Constant {
+			a.warnf(node, "'%s' is not defined", name)
+			return
+		}
+
+		delete(a.scopes[0], name)
+	default:
+		a.errorf(node, "Unsupported preprocessor directive %s", node.Name)
+	}
+}
+
+// analyzeGlobalVariable checks a top-level variable, whose initializer must be known at compile time
+func (a *Analyzer) analyzeGlobalVariable(node *ast.ASTNode) {
+	varType := node.Children[0].Name
+	node.Name = a.qualify(node.Name)
+	if node.HasModifier("ref") {
+		a.errorf(node, "Only parameters can be ref, '%s' is a variable", node.Name)
+	}
+
+	if node.HasModifier("weak") {
+		a.errorf(node, "Only fields of a class can be weak, '%s' is a variable", node.Name)
+	}
+
+	if a.resolveType(node.Children[0], false) {
+		varType = node.Children[0].Name
+	} else {
+		a.errorf(node.Children[0], "Invalid variable type '%s'", varType)
+		varType = ""
+	}
+
+	if _, isFunction := a.functions[node.Name]; isFunction {
+		a.errorf(node, "'%s' is already declared as a function", node.Name)
+	}
+
+	symbol := &Symbol{Name: node.Name, Kind: Variable, Type: varType, Const: node.HasModifier("const"), Node: node}
+
+	if len(node.Children) > 1 {
+		valueType := a.analyzeValue(node.Children[1], varType)
+
+		// A function pointer starts out as the address of a function, which is only known once linked
+		if _, _, isFunction := ast.ParseFunctionType(varType); isFunction && valueType != "" {
+			if node.Children[1].Type != ast.Identifier || a.lookup(node.Children[1].Name) != nil {
+				a.errorf(node.Children[1], "Initializer of global '%s' must be a function name", node.Name)
+			} else {
+				a.checkConversion(node.Children[1], valueType, varType, fmt.Sprintf("initialization of '%s'", node.Name))
+			}
+		} else if (a.structs[varType] != nil || a.unions[varType] != nil) && valueType != "" {
+			if err := a.constantLiteral(node.Children[1]); err != nil {
+				a.errorf(node.Children[1], "Initializer of global '%s' must be a constant expression: %v", node.Name, err)
+			} else {
+				a.checkConversion(node.Children[1], valueType, varType, fmt.Sprintf("initialization of '%s'", node.Name))
+			}
+		} else if valueType != "" {
+			if value, err := ast.EvaluateConstant(node.Children[1], a.constantLookup); err != nil {
+				a.errorf(node.Children[1], "Initializer of global '%s' must be a constant expression: %v", node.Name, err)
+			} else {
+				a.checkConversion(node.Children[1], valueType, varType, fmt.Sprintf("initialization of '%s'", node.Name))
+
+				// const globals can appear in later constant expressions
+				if symbol.Const && varType != "" {
+					value = value.Convert(varType)
+					symbol.Value = &value
+				}
+			}
+		}
+	} else if symbol.Const {
+		a.errorf(node, "const '%s' must be initialized", node.Name)
+	}
+
+	node.ResolvedType = varType
+	a.declare(symbol)
+}
+
+func (a *Analyzer) constantLookup(name string) (ast.ConstantValue, bool) {
+	if symbol := a.lookup(name); symbol != nil && symbol.Value != nil {
+		return *symbol.Value, true
+	}
+
+	return ast.ConstantValue{}, false
+}
+
+// functionSymbol resolves the signature of a function declaration
+func (a *Analyzer) functionSymbol(node *ast.ASTNode) *Symbol {
+	if !a.resolveType(node.Children[0], true) {
+		a.errorf(node.Children[0], "Unknown return type '%s'", node.Children[0].Name)
+	}
+
+	symbol := &Symbol{Name: node.Name, Kind: Function, Type: node.Children[0].Name, Defined: !node.IsPrototype(), Node: node}
+
+	for _, param := range node.Children[1].Children {
+		// Parameter types are reported when the body is checked
+		a.resolveType(param.Children[0], false)
+		paramType := param.Children[0].Name
+		symbol.ParamTypes = append(symbol.ParamTypes, paramType)
+
+		if param.Name == "" && symbol.Defined {
+			a.errorf(param, "Parameter of '%s' needs a name in its definition", node.Name)
+		}
+
+		if param.HasModifier("ref") && param.HasModifier("const") {
+			a.errorf(param, "A ref parameter cannot be const, take the value instead")
+		}
+
+		if param.HasModifier("weak") {
+			a.errorf(param, "Only fields of a class can be weak, '%s' is a parameter", param.Name)
+		}
+
+		if symbol.Defined {
+			symbol.Params = append(symbol.Params, &Symbol{Name: param.Name, Kind: Parameter, Type: paramType, Const: param.HasModifier("const"), Node: param})
+		}
+	}
+
+	return symbol
+}
+
+func (a *Analyzer) declareFunction(node *ast.ASTNode) {
+	symbol := a.functionSymbol(node)
+
+	existing, exists := a.functions[node.Name]
+	if !exists {
+		a.functions[node.Name] = symbol
+		return
+	}
+
+	if existing.Node == nil {
+		a.errorf(node, "'%s' is a builtin function and cannot be redeclared", node.Name)
+		return
+	}
+
+	if existing.Defined && symbol.Defined {
+		a.errorf(node, "Function '%s' is already defined on line %d", node.Name, existing.Node.Line)
+		return
+	}
+
+	if !sameSignature(existing, symbol) {
+		a.errorf(node, "Declaration of '%s' does not match the one on line %d", node.Name, existing.Node.Line)
+		return
+	}
+
+	// The definition replaces the prototype so later diagnostics point at the body
+	if symbol.Defined {
+		a.functions[node.Name] = symbol
+	}
+}
+
+func sameSignature(a, b *Symbol) bool {
+	if a.Type != b.Type || len(a.ParamTypes) != len(b.ParamTypes) {
+		return false
+	}
+
+	for i := range a.ParamTypes {
+		if a.ParamTypes[i] != b.ParamTypes[i] || refParam(a, i) != refParam(b, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a *Analyzer) analyzeFunction(node *ast.ASTNode) {
+	// A definition rejected by declareFunction has no symbol of its own, the error is already reported
+	if fn := a.functions[node.Name]; fn == nil || fn.Node != node {
+		return
+	}
+
+	a.currentFunction = a.functions[node.Name]
+	a.pushScope()
+
+	for i, param := range node.Children[1].Children {
+		paramType := param.Children[0].Name
+
+		if !a.resolveType(param.Children[0], false) {
+			a.errorf(param.Children[0], "Invalid parameter type '%s'", paramType)
+		}
+
+		param.ResolvedType = paramType
+		a.declare(a.currentFunction.Params[i])
+	}
+
+	// The body shares the parameters' scope, so redeclaring a parameter is an error rather than shadowing
+	body := node.Children[2]
+	a.analyzeStatements(body)
+
+	if node.Children[0].Name != "void" && !alwaysReturns(body) {
+		a.errorf(node, "Function '%s' does not return a value on every path", node.Name)
+	}
+
+	a.popScope()
+	a.currentFunction = nil
+}
+
+// alwaysReturns reports whether every path through the block ends in a return statement
+func alwaysReturns(block *ast.ASTNode) bool {
+	if len(block.Children) == 0 {
+		return false
+	}
+
+	last := block.Children[len(block.Children)-1]
+
+	switch {
+	case last.Type == ast.ReturnStatement:
+		return true
+	case last.Type == ast.Statement && last.Name == "if":
+		return conditionalAlwaysReturns(last)
+	case last.Type == ast.SwitchStatement:
+		return switchAlwaysReturns(last)
+	case last.Type == ast.Statement && last.Name == "unsafe":
+		return alwaysReturns(last.Children[0])
+	}
+
+	return false
+}
+
+func conditionalAlwaysReturns(node *ast.ASTNode) bool {
+	if len(node.Children) < 3 || !alwaysReturns(node.Children[1]) {
+		return false
+	}
+
+	if node.Children[2].Type == ast.Statement {
+		return conditionalAlwaysReturns(node.Children[2])
+	}
+
+	return alwaysReturns(node.Children[2])
+}
+
+// switchAlwaysReturns needs a default, since a switch without one may run no case at all
+func switchAlwaysReturns(node *ast.ASTNode) bool {
+	hasDefault := false
+
+	for _, c := range node.Children[1:] {
+		if !alwaysReturns(c.Children[0]) || breaksOut(c.Children[0]) {
+			return false
+		}
+
+		hasDefault = hasDefault || c.Name == "default"
+	}
+
+	return hasDefault
+}
+
+// analyzeBlock checks the statements of node inside a new lexical scope
+func (a *Analyzer) analyzeBlock(node *ast.ASTNode) {
+	a.pushScope()
+	a.analyzeStatements(node)
+	a.popScope()
+}
+
+func (a *Analyzer) analyzeStatements(node *ast.ASTNode) {
+	for _, child := range node.Children {
+		a.analyzeStatement(child)
+	}
+}
+
+func (a *Analyzer) analyzeStatement(node *ast.ASTNode) {
+	switch node.Type {
+	case ast.ReturnStatement:
+		a.analyzeReturn(node)
+	case ast.VariableDeclaration:
+		a.analyzeVariableDeclaration(node)
+	case ast.FunctionCall, ast.MethodCall, ast.CallExpression, ast.UnaryExpression, ast.PostfixExpression:
+		a.analyzeExpression(node)
+	case ast.Assignment:
+		a.analyzeAssignment(node)
+	case ast.WhileStatement:
+		a.analyzeCondition(node.Children[0])
+		a.loopDepth++
+		a.analyzeBlock(node.Children[1])
+		a.loopDepth--
+	case ast.SwitchStatement:
+		a.analyzeSwitch(node)
+	case ast.PreprocessorDirective:
+		a.analyzePreprocessorDirective(node)
+	case ast.Statement:
+		switch node.Name {
+		case "if":
+			a.analyzeConditional(node)
+		case "unsafe":
+			a.analyzeUnsafe(node)
+		case "delete":
+			a.analyzeDelete(node)
+		case "continue":
+			if a.loopDepth == 0 {
+				a.errorf(node, "'continue' outside of a loop")
+			}
+		case "break":
+			// A break inside a switch leaves the switch
+			if a.loopDepth == 0 && a.switchDepth == 0 {
+				a.errorf(node, "'break' outside of a loop or switch")
+			}
+		default:
+			a.errorf(node, "Unsupported statement '%s'", node.Name)
+		}
+	default:
+		a.errorf(node, "Unsupported statement %s", ast.ASTNodeTypeNames[node.Type])
+	}
+}
+
+func (a *Analyzer) analyzeConditional(node *ast.ASTNode) {
+	a.analyzeCondition(node.Children[0])
+	a.analyzeBlock(node.Children[1])
+
+	if len(node.Children) > 2 {
+		if node.Children[2].Type == ast.Statement {
+			a.analyzeConditional(node.Children[2])
+		} else {
+			a.analyzeBlock(node.Children[2])
+		}
+	}
+}
+
+func (a *Analyzer) analyzeCondition(node *ast.ASTNode) {
+	condType := a.analyzeExpression(node)
+
+	if condType != "" && condType != "bool" {
+		a.errorf(node, "Condition must be a comparison, got %s", condType)
+	}
+}
+
+func (a *Analyzer) analyzeReturn(node *ast.ASTNode) {
+	// A lambda takes its return type from its first return
+	if frame := a.currentLambda(); frame != nil && !frame.returned {
+		frame.returned = true
+		frame.symbol.Type = "void"
+
+		if len(node.Children) > 0 {
+			frame.symbol.Type = a.analyzeExpression(node.Children[0])
+			a.escape(node.Children[0], "is returned from a lambda")
+		}
+		return
+	}
+
+	retType := a.currentFunction.Type
+	if retType == "" {
+		return
+	}
+
+	if len(node.Children) == 0 {
+		if retType != "void" {
+			a.errorf(node, "Function '%s' must return a %s value", a.currentFunction.Name, retType)
+		}
+		return
+	}
+
+	valueType := a.analyzeValue(node.Children[0], retType)
+
+	if retType == "void" {
+		a.errorf(node, "Cannot return a value from void function '%s'", a.currentFunction.Name)
+		return
+	}
+
+	a.checkConversion(node.Children[0], valueType, retType, fmt.Sprintf("return from '%s'", a.currentFunction.Name))
+	a.escape(node.Children[0], fmt.Sprintf("is returned from '%s'", a.currentFunction.Name))
+}
+
+func (a *Analyzer) analyzeVariableDeclaration(node *ast.ASTNode) {
+	varType := node.Children[0].Name
+	if node.HasModifier("ref") {
+		a.errorf(node, "Only parameters can be ref, '%s' is a variable", node.Name)
+	}
+
+	if node.HasModifier("weak") {
+		a.errorf(node, "Only fields of a class can be weak, '%s' is a variable", node.Name)
+	}
+
+	if a.resolveType(node.Children[0], false) {
+		varType = node.Children[0].Name
+	} else {
+		a.errorf(node.Children[0], "Invalid variable type '%s'", varType)
+		varType = ""
+	}
+
+	if len(node.Children) > 1 {
+		valueType := a.analyzeValue(node.Children[1], varType)
+		a.checkConversion(node.Children[1], valueType, varType, fmt.Sprintf("initialization of '%s'", node.Name))
+	} else if node.HasModifier("const") {
+		a.errorf(node, "const '%s' must be initialized", node.Name)
+	}
+
+	node.ResolvedType = varType
+	symbol := &Symbol{Name: node.Name, Kind: Variable, Type: varType, Const: node.HasModifier("const"), Node: node, Depth: len(a.scopes) - 1}
+	if len(node.Children) > 1 {
+		a.flow(symbol, node.Children[1])
+	}
+
+	a.declare(symbol)
+}
+
+func (a *Analyzer) analyzeAssignment(node *ast.ASTNode) {
+	operator := node.Children[0].Name
+
+	// `module.count = 1` writes the module's global, which leaves no field access behind
+	if len(node.Children) > 2 && node.Name != "" {
+		root := a.qualifyTarget(node.Children[2])
+		if root == nil {
+			a.analyzeExpression(node.Children[1])
+			return
+		}
+
+		node.Name = root.Name
+		if root == node.Children[2] {
+			node.Children = node.Children[:2]
+		}
+	}
+
+	// A write through a pointer only reads the variable holding it
+	var symbol *Symbol
+	var targetType, target string
+	if len(node.Children) > 2 && (node.Name == "" || a.throughPointer(node.Children[2])) {
+		targetType, target = a.analyzeExpression(node.Children[2]), targetName(node.Children[2])
+		if targetType == "" {
+			a.analyzeExpression(node.Children[1])
+			return
+		}
+
+		if arrayLength(node.Children[2]) {
+			a.errorf(node.Children[2], "Cannot assign to '%s', the length of an array is fixed", target)
+			a.analyzeExpression(node.Children[1])
+			return
+		}
+
+		node.Name = ""
+	} else {
+		if symbol = a.assignableSymbol(node, node.Name); symbol == nil {
+			a.analyzeExpression(node.Children[1])
+			return
+		}
+
+		node.Name = symbol.Name
+		targetType, target = symbol.Type, node.Name
+
+		// Writing a field leaves the rest of the struct alone, so it is no new value for ::prev
+		if len(node.Children) > 2 {
+			targetType, target = a.analyzeExpression(node.Children[2]), targetName(node.Children[2])
+		} else {
+			symbol.Assigned = true
+			written(symbol)
+		}
+	}
+
+	node.ResolvedType = targetType
+	valueType := a.analyzeValue(node.Children[1], targetType)
+
+	switch operator {
+	case "=":
+		a.checkConversion(node.Children[1], valueType, targetType, fmt.Sprintf("assignment to '%s'", target))
+		if symbol != nil {
+			a.flow(symbol, node.Children[1])
+		} else {
+			a.escape(node.Children[1], fmt.Sprintf("is stored through '%s'", target))
+		}
+	case "+=", "-=", "*=", "/=", "%=":
+		if valueType == "" || targetType == "" {
+			return
+		}
+
+		if isPointer(targetType) && (operator == "+=" || operator == "-=") {
+			if _, ok := integerBits[valueType]; !ok || targetType == "void*" {
+				a.errorf(node.Children[0], "Operator '%s' cannot be applied to %s and %s", operator, targetType, valueType)
+				return
+			}
+
+			a.requireUnsafe(node, "Pointer arithmetic")
+			return
+		}
+
+		if !isNumeric(valueType) {
+			a.errorf(node.Children[1], "Cannot use %s in compound assignment", valueType)
+			return
+		}
+
+		if !isNumeric(targetType) {
+			a.errorf(node.Children[0], "Operator '%s' cannot be applied to %s", operator, targetType)
+			return
+		}
+
+		// The operation happens in the common type and is then narrowed back into the variable
+		a.checkConversion(node, arithmeticType(targetType, valueType), targetType, fmt.Sprintf("assignment to '%s'", target))
+	default:
+		a.errorf(node.Children[0], "Unsupported assignment operator '%s'", operator)
+	}
+}
+
+// assignableSymbol resolves the target of a write, reporting unknown names and read-only bindings
+func (a *Analyzer) assignableSymbol(node *ast.ASTNode, name string) *Symbol {
+	symbol := a.lookup(name)
+	if symbol == nil {
+		a.errorf(node, "Unknown identifier '%s'", name)
+		return nil
+	}
+
+	if symbol.Captured {
+		a.errorf(node, "'%s' is captured by value, capture it with [&%s] to assign to it", name, name)
+		return nil
+	}
+
+	if symbol.Const {
+		a.errorf(node, "Cannot assign to const '%s'", name)
+		return nil
+	}
+
+	if symbol.Kind == Constant || symbol.Kind == Function {
+		a.errorf(node, "Cannot assign to '%s'", name)
+		return nil
+	}
+
+	return symbol
+}
+
+// analyzeExpression annotates node and returns its type, or "" if the type could not be determined
+func (a *Analyzer) analyzeExpression(node *ast.ASTNode) string {
+	var resolved string
+
+	switch node.Type {
+	case ast.Literal:
+		resolved = a.analyzeLiteral(node)
+	case ast.Identifier:
+		resolved = a.analyzeIdentifier(node)
+	case ast.BinaryExpression:
+		resolved = a.analyzeBinaryExpression(node)
+	case ast.FunctionCall:
+		resolved = a.analyzeFunctionCall(node)
+	case ast.CastExpression:
+		resolved = a.analyzeCast(node)
+	case ast.MemberAccess:
+		resolved = a.analyzeMemberAccess(node)
+	case ast.MacroExpansion:
+		resolved = a.analyzeMacroExpansion(node)
+	case ast.Lambda:
+		resolved = a.analyzeLambda(node)
+	case ast.StructLiteral:
+		resolved = a.analyzeStructLiteral(node)
+	case ast.MatchExpression:
+		resolved = a.analyzeMatch(node)
+	case ast.UnaryExpression, ast.PostfixExpression:
+		resolved = a.analyzeUnaryExpression(node)
+	case ast.NewExpression:
+		resolved = a.analyzeNew(node)
+	case ast.MethodCall:
+		resolved = a.analyzeMethodCall(node)
+	case ast.CallExpression:
+		resolved = a.analyzeCallExpression(node)
+	case ast.ArrayAccess:
+		resolved = a.analyzeArrayAccess(node)
+	case ast.ArrayInitializer:
+		resolved = a.analyzeArrayInitializer(node)
+	default:
+		a.errorf(node, "Unsupported expression %s", ast.ASTNodeTypeNames[node.Type])
+	}
+
+	node.ResolvedType = resolved
+	return resolved
+}
+
+// SetAllowMaybe turns every `maybe` into an error when disabled, so test suites can ban the feature
+func (a *Analyzer) SetAllowMaybe(enabled bool) *Analyzer {
+	a.allowMaybe = enabled
+	return a
+}
+
+func (a *Analyzer) analyzeLiteral(node *ast.ASTNode) string {
+	if node.Name == "maybe" {
+		if !a.allowMaybe {
+			a.errorf(node, "'maybe' is disabled by --no-maybe")
+			return ""
+		}
+
+		return "bool"
+	}
+
+	// Evaluating the literal validates escapes in strings and characters
+	if _, err := ast.EvaluateConstant(node, nil); err != nil {
+		a.errorf(node, "%v", err)
+		return ""
+	}
+
+	return ast.LiteralType(node.Name)
+}
+
+func (a *Analyzer) analyzeIdentifier(node *ast.ASTNode) string {
+	// __FUNC__ is resolved here rather than in the preprocessor, which does not know about functions
+	if node.Name == "__FUNC__" {
+		if a.currentFunction == nil {
+			a.errorf(node, "__FUNC__ used outside of a function")
+			return ""
+		}
+
+		node.Type = ast.Literal
+		node.Name = strconv.Quote(a.currentFunction.Name)
+		return "string"
+	}
+
+	if node.IsSuper() {
+		a.errorf(node, "'super' can only be used to call a method of the base class")
+		return ""
+	}
+
+	symbol := a.lookup(node.Name)
+
+	if symbol == nil {
+		// A function name on its own is a pointer to the function
+		if fn := a.lookupFunction(node, node.Name); fn != nil {
+			if fn.Variadic {
+				a.errorf(node, "Function '%s' can only be called", node.Name)
+				return ""
+			}
+
+			if hasRefParams(fn) {
+				a.errorf(node, "Function '%s' takes ref parameters and can only be called", node.Name)
+				return ""
+			}
+
+			node.Name = fn.Name
+			return functionType(fn)
+		}
+
+		if g := a.lookupGeneric(node.Name); g != nil && g.Node.Type == ast.FunctionDeclaration {
+			a.errorf(node, "Generic function '%s' can only be called", node.Name)
+			return ""
+		}
+
+		a.errorf(node, "Unknown identifier '%s'", node.Name)
+		return ""
+	}
+
+	if symbol.Kind == Constant && symbol.Value == nil {
+		a.errorf(node, "'%s' is defined without a value", node.Name)
+		return ""
+	}
+
+	node.Name = symbol.Name
+	return symbol.Type
+}
+
+// analyzeMemberAccess resolves `module.name`, which is rewritten into the qualified identifier, an enum
+// constant or a struct field
+func (a *Analyzer) analyzeMemberAccess(node *ast.ASTNode) string {
+	base := node.Children[0]
+
+	if e := a.enumOf(base); e != nil {
+		return a.analyzeEnumConstant(node, e)
+	}
+
+	// `Shape.Empty` is a variant without fields
+	if name, ok := dottedName(base); ok {
+		if u, v := a.unionVariant(name + "." + node.Name); u != nil {
+			node.Name = name + "." + node.Name
+			return a.analyzeUnionLiteral(node, u, v)
+		}
+	}
+
+	if isModule, ok := a.qualifyModuleAccess(node); isModule {
+		if !ok {
+			return ""
+		}
+
+		return a.analyzeIdentifier(node)
+	}
+
+	return a.analyzeFieldAccess(node)
+}
+
+// qualifyModuleAccess rewrites node, when it is `module.name`, into the qualified identifier of the
+// module's global or function. ok is false when the module has no such global, which is reported
+func (a *Analyzer) qualifyModuleAccess(node *ast.ASTNode) (isModule, ok bool) {
+	base := node.Children[0]
+	if base.Type != ast.Identifier || a.lookup(base.Name) != nil || !a.imports[a.module][base.Name] {
+		return false, false
+	}
+
+	qualified := base.Name + "." + node.Name
+	_, isGlobal := a.scopes[0][qualified]
+	if _, isFunction := a.functions[qualified]; !isGlobal && !isFunction {
+		a.errorf(node, "Module '%s' has no global '%s'", base.Name, node.Name)
+		return true, false
+	}
+
+	node.Type = ast.Identifier
+	node.Name = qualified
+	node.Children = nil
+	return true, true
+}
+
+// qualifyTarget resolves `module.name` at the root of target, the variable or field chain written by an
+// assignment or increment, and returns the root. It returns nil when the module has no such global
+func (a *Analyzer) qualifyTarget(target *ast.ASTNode) *ast.ASTNode {
+	inner := target
+	for inner.Type == ast.MemberAccess && inner.Children[0].Type == ast.MemberAccess {
+		inner = inner.Children[0]
+	}
+
+	if inner.Type == ast.MemberAccess {
+		if isModule, ok := a.qualifyModuleAccess(inner); isModule && !ok {
+			return nil
+		}
+	}
+
+	root := target
+	for root.Type == ast.MemberAccess {
+		root = root.Children[0]
+	}
+
+	return root
+}
+
+// analyzeMacroExpansion checks a postfix `::` macro
+func (a *Analyzer) analyzeMacroExpansion(node *ast.ASTNode) string {
+	operand := node.Children[0]
+
+	switch node.Name {
+	case "prev":
+		if operand.Type != ast.Identifier {
+			a.errorf(node, "::prev needs a variable")
+			return ""
+		}
+
+		symbol := a.lookup(operand.Name)
+		if symbol == nil {
+			a.errorf(operand, "Unknown identifier '%s'", operand.Name)
+			return ""
+		}
+
+		if (symbol.Kind != Variable && symbol.Kind != Parameter) || a.scopes[0][symbol.Name] == symbol {
+			a.errorf(node, "::prev is only supported on local variables and parameters")
+			return ""
+		}
+
+		if symbol.Const {
+			a.errorf(node, "'%s' is const, so it has no previous value", operand.Name)
+			return ""
+		}
+
+		if a.isReference(symbol.Type) {
+			a.errorf(node, "'%s' holds a reference, which is released once replaced, so it has no previous value", operand.Name)
+			return ""
+		}
+
+		if symbol.Aliased != nil {
+			a.errorf(node, "'%s' can be written through the reference taken on line %d, which ::prev cannot follow", operand.Name, symbol.Aliased.Line)
+			return ""
+		}
+
+		// Statements are checked in source order, so this catches reads that come before every write
+		if !symbol.Assigned {
+			a.errorf(node, "'%s::prev' is read before '%s' is ever reassigned", operand.Name, operand.Name)
+			return ""
+		}
+
+		symbol.Node.TracksPrevious = true
+		operand.ResolvedType = symbol.Type
+		return symbol.Type
+	case "slef":
+		operandType := a.analyzeExpression(operand)
+		if operandType == "" {
+			return ""
+		}
+
+		if operandType != "int" && operandType != "char" {
+			a.errorf(node, "::slef needs an integer, got %s", operandType)
+			return ""
+		}
+
+		return "int"
+	}
+
+	a.errorf(node, "Unknown macro '::%s'", node.Name)
+	return ""
+}
+
+func (a *Analyzer) analyzeBinaryExpression(node *ast.ASTNode) string {
+	left := a.analyzeExpression(node.Children[0])
+	right := a.analyzeExpression(node.Children[1])
+
+	if left == "" || right == "" {
+		return ""
+	}
+
+	if a.structs[left] != nil || a.structs[right] != nil {
+		return a.analyzeStructComparison(node, left, right)
+	}
+
+	if a.isReference(left) || a.isReference(right) {
+		return a.analyzeReferenceComparison(node, left, right)
+	}
+
+	if isPointer(left) || isPointer(right) || left == "null" || right == "null" {
+		return a.analyzePointerOperation(node, left, right)
+	}
+
+	if a.enums[left] != nil || a.enums[right] != nil {
+		return a.analyzeEnumComparison(node, left, right)
+	}
+
+	for i, operandType := range []string{left, right} {
+		if !isNumeric(operandType) {
+			a.errorf(node.Children[i], "Operator '%s' cannot be applied to %s", node.Name, operandType)
+			return ""
+		}
+	}
+
+	switch node.Name {
+	case "+", "-", "*", "/", "%":
+		return arithmeticType(left, right)
+	case "==", "!=", "<", "<=", ">", ">=":
+		return "bool"
+	}
+
+	a.errorf(node, "Unsupported binary operator '%s'", node.Name)
+	return ""
+}
+
+func (a *Analyzer) analyzeUnaryExpression(node *ast.ASTNode) string {
+	operand := node.Children[0]
+
+	switch node.Name {
+	case "&":
+		return a.analyzeAddressOf(node)
+	case "*":
+		return a.analyzeDereference(node)
+	}
+
+	if node.Name == "++" || node.Name == "--" {
+		targetType := ""
+		if a.throughPointer(operand) {
+			targetType = a.analyzeExpression(operand)
+			if arrayLength(operand) {
+				a.errorf(node, "Cannot change '%s', the length of an array is fixed", targetName(operand))
+				return ""
+			}
+		} else if targetType = a.incrementTarget(node, operand); targetType == "" {
+			return ""
+		}
+
+		if isPointer(targetType) && targetType != "void*" {
+			a.requireUnsafe(node, "Pointer arithmetic")
+			return targetType
+		}
+
+		if targetType != "" && !isNumeric(targetType) {
+			a.errorf(node, "Operator '%s' cannot be applied to %s", node.Name, targetType)
+			return ""
+		}
+
+		return targetType
+	}
+
+	operandType := a.analyzeExpression(operand)
+	if operandType == "" {
+		return ""
+	}
+
+	if !isNumeric(operandType) {
+		a.errorf(node, "Operator '%s' cannot be applied to %s", node.Name, operandType)
+		return ""
+	}
+
+	if node.Name == "!" {
+		return "bool"
+	}
+
+	return arithmeticType(operandType, "int")
+}
+
+// incrementTarget resolves the variable or field written by `++` or `--`
+func (a *Analyzer) incrementTarget(node, operand *ast.ASTNode) string {
+	root := a.qualifyTarget(operand)
+	if root == nil {
+		return ""
+	}
+
+	if root.Type != ast.Identifier {
+		a.errorf(node, "Operator '%s' needs a variable", node.Name)
+		return ""
+	}
+
+	symbol := a.assignableSymbol(node, root.Name)
+	if symbol == nil {
+		return ""
+	}
+
+	if operand != root {
+		return a.analyzeExpression(operand)
+	}
+
+	operand.Name = symbol.Name
+	operand.ResolvedType = symbol.Type
+	symbol.Assigned = true
+	written(symbol)
+	return symbol.Type
+}
+
+func (a *Analyzer) analyzeFunctionCall(node *ast.ASTNode) string {
+	// `Shape.Circle(1.5)` builds a union
+	if u, v := a.unionVariant(node.Name); u != nil {
+		return a.analyzeUnionLiteral(node, u, v)
+	}
+
+	// `c.get()` calls a method of the instance held by c
+	if root, _, dotted := strings.Cut(node.Name, "."); dotted && (a.lookup(root) != nil || root == "super") {
+		methodCall(node)
+		return a.analyzeMethodCall(node)
+	}
+
+	a.site = node
+	if c := a.lookupClass(node.Name); c != nil {
+		a.errorf(node, "Instances of '%s' are allocated with 'New %s(...)'", c.Name, node.Name)
+		return ""
+	}
+
+	var argTypes []string
+	for _, arg := range node.Children {
+		argTypes = append(argTypes, a.analyzeExpression(arg))
+	}
+
+	// A generic function is called through its instance for the type arguments
+	if g := a.lookupGeneric(node.Name); g != nil && (a.lookup(node.Name) == nil || strings.Contains(node.Name, ".")) {
+		fn := a.instantiateFunction(node, g, argTypes)
+		if fn == nil {
+			return ""
+		}
+
+		node.Name = fn.Name
+		a.checkCall(node, fn, argTypes)
+		return fn.Type
+	}
+
+	if len(node.TypeArguments) > 0 {
+		a.errorf(node, "'%s' is not a generic function, so it takes no type arguments", node.Name)
+		return ""
+	}
+
+	// A variable holding a function is called through the pointer, it hides any function of that name
+	if symbol := a.lookup(node.Name); symbol != nil && !strings.Contains(node.Name, ".") {
+		if symbol.Type == "" {
+			return ""
+		}
+
+		ret, params, ok := ast.ParseFunctionType(symbol.Type)
+		if symbol.Kind == Constant || !ok {
+			a.errorf(node, "'%s' is not a function", node.Name)
+			return ""
+		}
+
+		node.Name = symbol.Name
+		a.checkArguments(node, argTypes, params)
+
+		for _, arg := range node.Children {
+			a.lend(arg, "is passed through a function pointer, which may keep it")
+		}
+		return ret
+	}
+
+	fn := a.lookupFunction(node, node.Name)
+	if fn == nil {
+		if !strings.Contains(node.Name, ".") {
+			a.errorf(node, "Unknown function '%s'", node.Name)
+		}
+		return ""
+	}
+
+	node.Name = fn.Name
+
+	if fn.Variadic {
+		for i, argType := range argTypes {
+			// Enums are printed as their value
+			if a.enums[argType] != nil {
+				arg := node.Children[i]
+				node.Children[i] = &ast.ASTNode{Type: ast.CastExpression, Name: "int", Children: []*ast.ASTNode{arg}, ResolvedType: "int", Line: arg.Line, Column: arg.Column, File: arg.File}
+				continue
+			}
+
+			if argType != "" && !isNumeric(argType) && argType != "string" && !isPointer(argType) {
+				a.errorf(node.Children[i], "Cannot pass %s to '%s'", argType, node.Name)
+			}
+		}
+
+		return fn.Type
+	}
+
+	a.checkCall(node, fn, argTypes)
+	return fn.Type
+}
+
+// checkCall checks the arguments of a call to fn, which are the last children of node. Methods and
+// constructors receive `this` before them
+func (a *Analyzer) checkCall(node *ast.ASTNode, fn *Symbol, argTypes []string) {
+	args := node.Children[len(node.Children)-len(argTypes):]
+	first := receivers(fn)
+
+	// Arguments passed by reference are never converted, so they are checked on their own
+	paramTypes := fn.ParamTypes[first:]
+	if hasRefParams(fn) && len(argTypes) == len(paramTypes) {
+		paramTypes = slices.Clone(paramTypes)
+		for i := range paramTypes {
+			if refParam(fn, first+i) {
+				a.checkRefArgument(fn, first+i, args[i], argTypes[i])
+				paramTypes[i] = argTypes[i]
+			}
+		}
+	}
+
+	a.checkArguments(node, argTypes, paramTypes)
+
+	for i, arg := range args {
+		if a.dispatched(fn) {
+			a.lend(arg, fmt.Sprintf("is passed to '%s', whose implementation is chosen at runtime", node.Name))
+		} else if !fn.Defined {
+			a.lend(arg, fmt.Sprintf("is passed to external function '%s'", node.Name))
+		} else if first+i < len(fn.Params) {
+			a.pass(arg, fn.Params[first+i], fmt.Sprintf("is passed to '%s', which keeps it", node.Name))
+		}
+	}
+}
+
+// analyzeCallExpression checks a call of a value other than a named function or variable, such as
+// `pick(1)(3, 3)`: { callee, args... }
+func (a *Analyzer) analyzeCallExpression(node *ast.ASTNode) string {
+	if a.castCall(node) {
+		return a.analyzeCast(node)
+	}
+
+	calleeType := a.analyzeExpression(node.Children[0])
+	node.Name = targetName(node.Children[0])
+
+	var argTypes []string
+	for _, arg := range node.Children[1:] {
+		argTypes = append(argTypes, a.analyzeExpression(arg))
+	}
+
+	if calleeType == "" {
+		return ""
+	}
+
+	return a.callValue(node, calleeType, argTypes)
+}
+
+// castCall turns `(Name)(x)` into a cast when Name is a type rather than a value, which the parser can't tell
+func (a *Analyzer) castCall(node *ast.ASTNode) bool {
+	name, ok := dottedName(node.Children[0])
+	if root, _, _ := strings.Cut(name, "."); !ok || len(node.Children) != 2 || a.lookup(root) != nil {
+		return false
+	}
+
+	if _, ok := a.canonicalType(name, false); !ok {
+		return false
+	}
+
+	node.Type = ast.CastExpression
+	node.Name = name
+	node.Children = node.Children[1:]
+	return true
+}
+
+// callValue checks the arguments of a call expression against calleeType, the type of the function
+// value it calls, and returns its result type
+func (a *Analyzer) callValue(node *ast.ASTNode, calleeType string, argTypes []string) string {
+	ret, params, ok := ast.ParseFunctionType(calleeType)
+	if !ok {
+		a.errorf(node, "'%s' is %s, not a function", node.Name, calleeType)
+		return ""
+	}
+
+	a.checkArguments(node, argTypes, params)
+
+	for _, arg := range node.Children[1:] {
+		a.lend(arg, "is passed through a function pointer, which may keep it")
+	}
+	return ret
+}
+
+// callees spells what is called by each kind of call in diagnostics
+var callees map[ast.ASTNodeType]string = map[ast.ASTNodeType]string{
+	ast.FunctionCall:   "Function",
+	ast.MethodCall:     "Method",
+	ast.NewExpression:  "Constructor of",
+	ast.CallExpression: "Function",
+}
+
+// checkArguments checks the arguments of a call, the last children of node, against the parameter types
+// of its target
+func (a *Analyzer) checkArguments(node *ast.ASTNode, argTypes, paramTypes []string) {
+	if len(argTypes) != len(paramTypes) {
+		a.errorf(node, "%s '%s' expects %d argument(s), got %d", callees[node.Type], node.Name, len(paramTypes), len(argTypes))
+		return
+	}
+
+	args := node.Children[len(node.Children)-len(argTypes):]
+	for i, argType := range argTypes {
+		a.checkConversion(args[i], argType, paramTypes[i], fmt.Sprintf("argument %d of '%s'", i+1, node.Name))
+	}
+}
+
+// functionType is the type of a pointer to fn
+func functionType(fn *Symbol) string {
+	return ast.FunctionType(fn.Type, fn.ParamTypes)
+}
+
+func (a *Analyzer) analyzeCast(node *ast.ASTNode) string {
+	operand := a.analyzeExpression(node.Children[0])
+	a.site = node
+
+	target, ok := a.canonicalType(node.Name, false)
+	if !ok {
+		a.errorf(node, "Unknown type '%s'", node.Name)
+		return ""
+	}
+
+	node.Name = target
+	if operand != "" && !a.castable(operand, target) {
+		a.errorf(node, "Cannot cast %s to %s", operand, target)
+	} else if operand != "" && (isPointer(operand) || isPointer(target)) {
+		a.pointerCast(node, operand, target)
+	}
+
+	return target
+}
+
+// checkConversion validates an implicit conversion and warns when it may lose data
+func (a *Analyzer) checkConversion(node *ast.ASTNode, from, to, context string) {
+	if from == "" || to == "" || from == to || pointerConvertible(from, to) || from == "null" && a.isReference(to) || a.upcast(from, to) ||
+		a.implements(from, to) {
+		return
+	}
+
+	if !isNumeric(from) || !isNumeric(to) {
+		a.errorf(node, "Cannot convert %s to %s in %s%s", from, to, context, a.enumHint(from, to))
+		return
+	}
+
+	if isLossy(node, from, to) {
+		a.warnf(node, "Implicit conversion from %s to %s in %s may lose data", from, to, context)
+	}
+}
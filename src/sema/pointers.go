@@ -0,0 +1,262 @@
+package sema
+
+import (
+	"fmt"
+
+	"velox.eparker.dev/src/ast"
+)
+
+func isPointer(name string) bool {
+	_, ok := ast.Pointee(name)
+	return ok
+}
+
+// pointerConvertible reports whether a pointer converts implicitly, which is only to itself, from null,
+// or to void*
+func pointerConvertible(from, to string) bool {
+	if !isPointer(to) {
+		return false
+	}
+
+	return from == to || from == "null" || to == "void*" && isPointer(from)
+}
+
+// requireUnsafe reports what is done at node unless it happens in an unsafe block
+func (a *Analyzer) requireUnsafe(node *ast.ASTNode, what string) bool {
+	if a.unsafeDepth == 0 {
+		a.errorf(node, "%s is only allowed in an unsafe block", what)
+		return false
+	}
+
+	return true
+}
+
+// analyzeUnsafe checks `unsafe { ... }`. Lambdas written inside the block are unsafe as well
+func (a *Analyzer) analyzeUnsafe(node *ast.ASTNode) {
+	a.unsafeDepth++
+	a.analyzeBlock(node.Children[0])
+	a.unsafeDepth--
+}
+
+// storage finds where the value of node lives: in a variable, possibly in one of its fields, or behind
+// a pointer or a reference to the heap, for which the symbol is nil. Other expressions have no address
+func (a *Analyzer) storage(node *ast.ASTNode) (*Symbol, bool) {
+	switch node.Type {
+	case ast.Identifier:
+		symbol := a.lookup(node.Name)
+		return symbol, symbol != nil && (symbol.Kind == Variable || symbol.Kind == Parameter)
+	case ast.MemberAccess:
+		if isPointer(node.Children[0].ResolvedType) || a.isReference(node.Children[0].ResolvedType) {
+			return nil, !arrayLength(node)
+		}
+
+		return a.storage(node.Children[0])
+	case ast.UnaryExpression:
+		return nil, node.Name == "*"
+	case ast.ArrayAccess:
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// analyzeAddressOf checks `&x`, which points to a variable, a field or what another pointer points to.
+// The address of a local may not outlive it, which checkEscapes verifies
+func (a *Analyzer) analyzeAddressOf(node *ast.ASTNode) string {
+	operandType := a.analyzeExpression(node.Children[0])
+	if operandType == "" {
+		return ""
+	}
+
+	symbol, ok := a.storage(node.Children[0])
+	switch {
+	case !ok:
+		a.errorf(node, "Operator '&' needs a variable, a field or a dereference")
+		return ""
+	case a.weakField(node.Children[0]):
+		a.errorf(node, "Cannot take the address of weak field '%s'", targetName(node.Children[0]))
+		return ""
+	case symbol == nil:
+	case symbol.Const:
+		a.errorf(node, "Cannot take the address of const '%s'", symbol.Name)
+		return ""
+	case symbol.Captured:
+		a.errorf(node, "'%s' is captured by value, capture it with [&%s] to take its address", symbol.Name, symbol.Name)
+		return ""
+	case a.scopes[0][symbol.Name] != symbol:
+		a.addresses[node] = &Symbol{Name: "&" + symbol.Name, Kind: Address, Borrows: symbol.Name, Node: node, Depth: symbol.Depth}
+	}
+
+	if symbol != nil {
+		written(symbol)
+		a.aliased(node, symbol, "handed out by address")
+	}
+
+	return ast.PointerType(operandType)
+}
+
+// analyzeDereference checks `*p`, the value p points to
+func (a *Analyzer) analyzeDereference(node *ast.ASTNode) string {
+	operandType := a.analyzeExpression(node.Children[0])
+	if operandType == "" {
+		return ""
+	}
+
+	pointee, ok := ast.Pointee(operandType)
+	switch {
+	case !ok:
+		a.errorf(node, "Cannot dereference %s", operandType)
+		return ""
+	case pointee == "void":
+		a.errorf(node, "Cannot dereference void*, cast it to a pointer of another type first")
+		return ""
+	}
+
+	return pointee
+}
+
+// throughPointer reports whether a write to target goes through a pointer, as in `*p = 1` or `p.x = 1`
+// with p pointing to a struct, or through a reference, as in `list[0] = 1`. The variable holding the
+// pointer is then only read
+func (a *Analyzer) throughPointer(target *ast.ASTNode) bool {
+	_, through := a.targetType(target)
+	return through
+}
+
+// targetType works out the type of an assignment target before it is analyzed
+func (a *Analyzer) targetType(target *ast.ASTNode) (string, bool) {
+	switch target.Type {
+	case ast.Identifier:
+		if symbol := a.lookup(target.Name); symbol != nil {
+			return symbol.Type, false
+		}
+	case ast.UnaryExpression:
+		return "", target.Name == "*"
+	case ast.ArrayAccess:
+		return "", true
+	case ast.MemberAccess:
+		baseType, through := a.targetType(target.Children[0])
+		if through || isPointer(baseType) || a.isReference(baseType) {
+			return "", true
+		}
+
+		if s, ok := a.structs[baseType]; ok && s.field(target.Name) != nil {
+			return s.field(target.Name).Type, false
+		}
+	}
+
+	return "", false
+}
+
+// analyzePointerOperation checks a binary operator with a pointer operand. Pointers compare for
+// equality anywhere, ordering them and arithmetic, which moves them by whole elements, need unsafe
+func (a *Analyzer) analyzePointerOperation(node *ast.ASTNode, left, right string) string {
+	_, leftInteger := integerBits[left]
+	_, rightInteger := integerBits[right]
+
+	switch node.Name {
+	case "==", "!=":
+		if !pointerConvertible(left, right) && !pointerConvertible(right, left) {
+			a.errorf(node, "Cannot compare %s with %s", left, right)
+			return ""
+		}
+
+		return "bool"
+	case "<", "<=", ">", ">=":
+		if left != right {
+			a.errorf(node, "Cannot compare %s with %s", left, right)
+			return ""
+		}
+
+		a.requireUnsafe(node, "Ordering pointers")
+		return "bool"
+	case "+", "-":
+		result := ""
+		switch {
+		case isPointer(left) && rightInteger:
+			result = left
+		case leftInteger && isPointer(right) && node.Name == "+":
+			result = right
+		case left == right && node.Name == "-":
+			result = "int"
+		default:
+			a.errorf(node, "Operator '%s' cannot be applied to %s and %s", node.Name, left, right)
+			return ""
+		}
+
+		if left == "void*" || right == "void*" {
+			a.errorf(node, "Pointer arithmetic on void* has no element size, cast it to a pointer of another type first")
+			return ""
+		}
+
+		a.requireUnsafe(node, "Pointer arithmetic")
+		return result
+	}
+
+	a.errorf(node, "Operator '%s' cannot be applied to %s", node.Name, left)
+	return ""
+}
+
+// refParam reports whether parameter i of fn is declared `ref`, passing the caller's variable rather
+// than a copy of its value
+func refParam(fn *Symbol, i int) bool {
+	return fn.Node != nil && i < len(fn.Node.Children[1].Children) && fn.Node.Children[1].Children[i].HasModifier("ref")
+}
+
+func hasRefParams(fn *Symbol) bool {
+	for i := range fn.ParamTypes {
+		if refParam(fn, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkRefArgument checks the argument passed to a ref parameter, which must be a variable or field of
+// exactly the parameter's type that is not const
+func (a *Analyzer) checkRefArgument(fn *Symbol, i int, arg *ast.ASTNode, argType string) {
+	if argType == "" {
+		return
+	}
+
+	symbol, ok := a.storage(arg)
+	switch {
+	case !ok:
+		a.errorf(arg, "Argument %d of '%s' is passed by reference and must be a variable or a field", i+1-receivers(fn), fn.Name)
+	case argType != fn.ParamTypes[i]:
+		a.errorf(arg, "Argument %d of '%s' is passed by reference and must be %s, got %s", i+1-receivers(fn), fn.Name, fn.ParamTypes[i], argType)
+	case a.weakField(arg):
+		a.errorf(arg, "'%s' is weak and cannot be passed by reference", targetName(arg))
+	case symbol == nil:
+	case symbol.Captured:
+		a.errorf(arg, "'%s' is captured by value, capture it with [&%s] to pass it by reference", symbol.Name, symbol.Name)
+	case symbol.Const:
+		a.errorf(arg, "Cannot pass const '%s' by reference", symbol.Name)
+	default:
+		written(symbol)
+		a.aliased(arg, symbol, "passed by reference")
+	}
+}
+
+// aliased records that symbol can now be written through a reference, behind the back of the shadow
+// slot its ::prev reads, so the two cannot be combined
+func (a *Analyzer) aliased(node *ast.ASTNode, symbol *Symbol, how string) {
+	if symbol.Node != nil && symbol.Node.TracksPrevious {
+		a.errorf(node, "'%s::prev' is read, so '%s' cannot be %s", symbol.Name, symbol.Name, how)
+	}
+
+	if symbol.Aliased == nil {
+		symbol.Aliased = node
+	}
+}
+
+// pointerCast checks an explicit cast involving pointers. Anything beyond the implicit conversions
+// reinterprets memory and needs unsafe
+func (a *Analyzer) pointerCast(node *ast.ASTNode, from, to string) {
+	if pointerConvertible(from, to) {
+		return
+	}
+
+	a.requireUnsafe(node, fmt.Sprintf("Casting %s to %s", from, to))
+}
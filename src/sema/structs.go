@@ -0,0 +1,427 @@
+package sema
+
+import (
+	"fmt"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// Struct is a declared struct type. Fields are symbols so they carry their declaration for diagnostics
+type Struct struct {
+	Name       string
+	Fields     []*Symbol
+	Methods    map[string]*Symbol // Keyed by the method's own name, the function is named `Struct.method`
+	Interfaces []*Interface
+	Node       *ast.ASTNode
+}
+
+func (s *Struct) field(name string) *Symbol {
+	for _, field := range s.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// collectStructs registers every struct name before any type is resolved, so fields and signatures
+// can use structs declared later in the file
+func (a *Analyzer) collectStructs(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.StructDeclaration:
+			child.Name = a.qualify(child.Name)
+
+			if existing, ok := a.structs[child.Name]; ok {
+				a.errorf(child, "Struct '%s' is already declared on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			a.structs[child.Name] = &Struct{Name: child.Name, Methods: make(map[string]*Symbol), Node: child}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectStructs(child.Children) })
+		}
+	}
+}
+
+// analyzeStructs resolves the field types and the interfaces of every struct and declares its methods
+func (a *Analyzer) analyzeStructs(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.StructDeclaration:
+			s := a.structs[child.Name]
+			if s.Node != child {
+				continue
+			}
+
+			for _, supertype := range supertypes(child) {
+				if i := a.implementedInterface("Struct", s.Name, supertype, s.Interfaces); i != nil {
+					s.Interfaces = append(s.Interfaces, i)
+				} else if a.interfaces[supertype.Name] == nil {
+					a.errorf(supertype, "Struct '%s' can only implement interfaces, '%s' is not one", s.Name, supertype.Name)
+				}
+			}
+
+			for _, field := range child.Children {
+				if field.Type != ast.VariableDeclaration {
+					continue
+				}
+
+				if s.field(field.Name) != nil {
+					a.errorf(field, "Struct '%s' already has a field '%s'", s.Name, field.Name)
+					continue
+				}
+
+				if !a.resolveType(field.Children[0], false) {
+					a.errorf(field.Children[0], "Invalid type '%s' of field '%s'", field.Children[0].Name, field.Name)
+				} else if a.isReference(field.Children[0].Name) {
+					a.errorf(field.Children[0], "Field '%s' of '%s' cannot hold %s, structs are copied and only classes can hold references", field.Name, s.Name, field.Children[0].Name)
+				}
+
+				field.ResolvedType = field.Children[0].Name
+				s.Fields = append(s.Fields, &Symbol{Name: field.Name, Kind: Variable, Type: field.ResolvedType, Node: field})
+			}
+
+			for _, method := range child.Children {
+				if method.Type == ast.FunctionDeclaration {
+					a.declareStructMethod(s, method)
+				}
+			}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.analyzeStructs(child.Children) })
+		}
+	}
+}
+
+// declareStructMethod turns a method of a struct into a function named `Struct.method`, whose const
+// `this` is a copy of the value it is called on
+func (a *Analyzer) declareStructMethod(s *Struct, node *ast.ASTNode) {
+	switch {
+	case node.IsPrototype():
+		a.errorf(node, "Method '%s' of '%s' needs a body", node.Name, s.Name)
+	case s.Methods[node.Name] != nil:
+		a.errorf(node, "Struct '%s' already has a method '%s'", s.Name, node.Name)
+	case s.field(node.Name) != nil:
+		a.errorf(node, "Struct '%s' already has a field '%s'", s.Name, node.Name)
+	default:
+		name := node.Name
+		s.Methods[name] = a.methodFunction(s.Name, node)
+	}
+}
+
+// analyzeStruct checks that a struct implements its interfaces and checks the bodies of its methods
+func (a *Analyzer) analyzeStruct(node *ast.ASTNode) {
+	s := a.structs[node.Name]
+	if s == nil || s.Node != node {
+		return
+	}
+
+	a.checkImplementations("Struct", node, func(name string) *Symbol { return s.Methods[name] })
+
+	for _, member := range node.Children {
+		if fn := a.functions[member.Name]; member.Type == ast.FunctionDeclaration && fn != nil && fn.Node == member {
+			a.analyzeFunction(member)
+		}
+	}
+}
+
+// checkRecursion rejects structs and unions that contain themselves, which would have no finite size
+func (a *Analyzer) checkRecursion(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch {
+		case child.Type == ast.StructDeclaration && a.structs[child.Name].Node == child:
+			if field := a.recursiveField(child.Name, child.Name, make(map[string]bool)); field != nil {
+				a.errorf(field.Node, "Struct '%s' contains itself through field '%s'", child.Name, field.Name)
+			}
+		case child.Type == ast.UnionDeclaration && a.unions[child.Name] != nil && a.unions[child.Name].Node == child:
+			if field := a.recursiveField(child.Name, child.Name, make(map[string]bool)); field != nil {
+				a.errorf(field.Node, "Union '%s' contains itself through field '%s'", child.Name, field.Name)
+			}
+		case child.Type == ast.ImportDeclaration:
+			a.checkRecursion(child.Children)
+		}
+	}
+}
+
+// recursiveField finds the field of the struct or union name through which it contains the type target
+func (a *Analyzer) recursiveField(name, target string, seen map[string]bool) *Symbol {
+	seen[name] = true
+
+	for _, field := range a.fieldsOf(name) {
+		if field.Type == target {
+			return field
+		}
+
+		if !seen[field.Type] && a.recursiveField(field.Type, target, seen) != nil {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// fieldsOf lists the fields of a struct, or those of every variant of a union
+func (a *Analyzer) fieldsOf(name string) []*Symbol {
+	if s, ok := a.structs[name]; ok {
+		return s.Fields
+	}
+
+	var fields []*Symbol
+	if u, ok := a.unions[name]; ok {
+		for _, variant := range u.Variants {
+			fields = append(fields, variant.Fields...)
+		}
+	}
+
+	return fields
+}
+
+// lookupStruct resolves a struct type name
+func (a *Analyzer) lookupStruct(name string) *Struct {
+	if !a.visibleType(name) {
+		return nil
+	}
+
+	return a.structs[a.qualify(name)]
+}
+
+// visibleType reports whether a type name may be used here. A module sees its own types and those of
+// the modules it imports
+func (a *Analyzer) visibleType(name string) bool {
+	module, _, qualified := strings.Cut(name, ".")
+	return !qualified || module+"." == a.module || a.imports[a.module][module]
+}
+
+// analyzeValue checks an expression whose value is stored as expected, which gives `{1, 2}` its type
+func (a *Analyzer) analyzeValue(node *ast.ASTNode, expected string) string {
+	if node.Type == ast.StructLiteral && node.Name == "" {
+		// The type was already reported as invalid
+		if expected == "" {
+			return ""
+		}
+
+		if _, ok := a.structs[expected]; ok {
+			node.Name = expected
+		} else if isArray(expected) {
+			node.Type = ast.ArrayInitializer
+		}
+	}
+
+	// `{1, 2}` stored in an array allocates one holding the values
+	if node.Type == ast.ArrayInitializer {
+		if expected == "" {
+			return ""
+		}
+
+		node.Name = expected
+	}
+
+	return a.analyzeExpression(node)
+}
+
+// analyzeStructLiteral checks `Point{1, 2}` or `Point{.x = 1}`. Fields without a value start at zero
+func (a *Analyzer) analyzeStructLiteral(node *ast.ASTNode) string {
+	if node.Name == "" {
+		a.errorf(node, "A struct literal needs its type here, as in 'Point{...}'")
+		return ""
+	}
+
+	s := a.lookupStruct(node.Name)
+	if s == nil {
+		a.errorf(node, "Unknown struct '%s'", node.Name)
+		return ""
+	}
+
+	node.Name = s.Name
+	named, positional := false, false
+	given := make(map[string]bool)
+
+	for i, element := range node.Children {
+		var field *Symbol
+		value := element
+
+		if element.Type == ast.Assignment {
+			named, value = true, element.Children[1]
+
+			if field = s.field(element.Name); field == nil {
+				a.errorf(element, "Struct '%s' has no field '%s'", s.Name, element.Name)
+			} else if given[field.Name] {
+				a.errorf(element, "Field '%s' is given more than once", field.Name)
+			}
+		} else {
+			positional = true
+
+			if i < len(s.Fields) {
+				field = s.Fields[i]
+			} else if i == len(s.Fields) {
+				a.errorf(element, "Too many values for struct '%s', it has %d field(s)", s.Name, len(s.Fields))
+			}
+		}
+
+		if field == nil {
+			a.analyzeExpression(value)
+			continue
+		}
+
+		given[field.Name] = true
+		element.ResolvedType = field.Type
+		valueType := a.analyzeValue(value, field.Type)
+		a.checkConversion(value, valueType, field.Type, fmt.Sprintf("field '%s' of '%s'", field.Name, s.Name))
+	}
+
+	if named && positional {
+		a.errorf(node, "Struct literal mixes positional and named fields")
+	}
+
+	return s.Name
+}
+
+// analyzeFieldAccess checks `value.field` on a struct value, or on the struct a pointer points to. Instances
+// of classes have fields too, arrays only their `length`
+func (a *Analyzer) analyzeFieldAccess(node *ast.ASTNode) string {
+	baseType := a.analyzeExpression(node.Children[0])
+	if baseType == "" {
+		return ""
+	}
+
+	return a.analyzeMember(node, baseType)
+}
+
+// analyzeMember resolves the member node names of a value of baseType, which is already analyzed
+func (a *Analyzer) analyzeMember(node *ast.ASTNode, baseType string) string {
+	if c, ok := a.classes[baseType]; ok {
+		return a.analyzeClassMember(node, c)
+	}
+
+	if i, ok := a.interfaces[baseType]; ok {
+		if i.method(node.Name) != nil {
+			a.errorf(node, "Method '%s' of '%s' can only be called", node.Name, i.Name)
+		} else {
+			a.errorf(node, "Interface '%s' has no method '%s', and interfaces have no fields", i.Name, node.Name)
+		}
+		return ""
+	}
+
+	if isArray(baseType) {
+		if node.Name != "length" {
+			a.errorf(node, "Arrays have no member '%s', only 'length'", node.Name)
+			return ""
+		}
+
+		return "int"
+	}
+
+	if pointee, ok := ast.Pointee(baseType); ok && a.structs[pointee] != nil {
+		baseType = pointee
+	}
+
+	if _, isUnion := a.unions[baseType]; isUnion {
+		a.errorf(node, "Union '%s' has no members, its fields are bound by the patterns of a switch or match", baseType)
+		return ""
+	}
+
+	s, ok := a.structs[baseType]
+	if !ok {
+		a.errorf(node, "%s has no member '%s'", baseType, node.Name)
+		return ""
+	}
+
+	field := s.field(node.Name)
+	if field == nil && s.Methods[node.Name] != nil {
+		a.errorf(node, "Method '%s' of '%s' can only be called", node.Name, s.Name)
+		return ""
+	}
+
+	if field == nil {
+		a.errorf(node, "Struct '%s' has no field '%s'", s.Name, node.Name)
+		return ""
+	}
+
+	return field.Type
+}
+
+// analyzeStructComparison checks `==` and `!=` on structs, which compare field by field
+func (a *Analyzer) analyzeStructComparison(node *ast.ASTNode, left, right string) string {
+	if node.Name != "==" && node.Name != "!=" {
+		a.errorf(node, "Operator '%s' cannot be applied to %s", node.Name, left)
+		return ""
+	}
+
+	if left != right {
+		a.errorf(node, "Cannot compare %s with %s", left, right)
+		return ""
+	}
+
+	if path, fieldType := a.incomparableField(a.structs[left]); path != "" {
+		a.errorf(node, "Struct '%s' cannot be compared, its field '%s' has type %s", left, path, fieldType)
+		return ""
+	}
+
+	return "bool"
+}
+
+// incomparableField finds a field, possibly nested, that has no equality
+func (a *Analyzer) incomparableField(s *Struct) (string, string) {
+	for _, field := range s.Fields {
+		if inner, ok := a.structs[field.Type]; ok {
+			if path, fieldType := a.incomparableField(inner); path != "" {
+				return field.Name + "." + path, fieldType
+			}
+		} else if !isNumeric(field.Type) && a.enums[field.Type] == nil && !isPointer(field.Type) && !a.isReference(field.Type) {
+			return field.Name, field.Type
+		}
+	}
+
+	return "", ""
+}
+
+// constantLiteral reports why an initializer, possibly a struct literal, is not known at compile time
+func (a *Analyzer) constantLiteral(node *ast.ASTNode) error {
+	// The payload of a union is only laid out at runtime
+	if node.Type == ast.UnionLiteral {
+		if len(node.Children) > 0 {
+			return fmt.Errorf("'%s' has fields", node.Name)
+		}
+
+		return nil
+	}
+
+	if node.Type != ast.StructLiteral {
+		_, err := ast.EvaluateConstant(node, a.constantLookup)
+		return err
+	}
+
+	for _, element := range node.Children {
+		if element.Type == ast.Assignment {
+			element = element.Children[1]
+		}
+
+		if err := a.constantLiteral(element); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// targetName spells the variable or field a write goes to, for diagnostics
+func targetName(node *ast.ASTNode) string {
+	switch {
+	case node.Type == ast.MemberAccess:
+		return targetName(node.Children[0]) + "." + node.Name
+	case node.Type == ast.UnaryExpression && node.Name == "*":
+		return "*" + targetName(node.Children[0])
+	case node.Type == ast.ArrayAccess:
+		return targetName(node.Children[0]) + "[...]"
+	case node.Type == ast.FunctionCall:
+		return node.Name + "(...)"
+	case node.Type == ast.MethodCall:
+		return targetName(node.Children[0]) + "." + node.Name + "(...)"
+	case node.Type == ast.CallExpression:
+		return targetName(node.Children[0]) + "(...)"
+	}
+
+	return node.Name
+}
@@ -0,0 +1,209 @@
+package sema
+
+import (
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// isSwitchable reports whether a switch or match can branch on values of type name
+func (a *Analyzer) isSwitchable(name string) bool {
+	_, isInteger := integerBits[name]
+	_, isEnum := a.enums[name]
+	_, isUnion := a.unions[name]
+	return isInteger || isEnum || isUnion
+}
+
+// analyzeCases checks the labels of the cases of a switch or match, which are constants of the value's
+// type, or patterns for a union, that no other case handles. It returns whether every value is handled, and if not, the names of
+// the values that are missing when the type has few enough to list
+func (a *Analyzer) analyzeCases(node *ast.ASTNode, valueType string) (bool, []string) {
+	handled := make(map[int64]*ast.ASTNode)
+	var fallback *ast.ASTNode
+
+	for _, c := range node.Children[1:] {
+		if c.Name == "default" {
+			if fallback != nil {
+				a.errorf(c, "'default' is already given on line %d", fallback.Line)
+			}
+
+			fallback = c
+			continue
+		}
+
+		for _, label := range c.Children[1:] {
+			// Cases on a union are keyed by the tag of their variant
+			if u := a.unions[valueType]; u != nil {
+				tag := a.analyzePattern(label, u, len(c.Children) > 2)
+				if tag < 0 {
+					continue
+				}
+
+				if previous, ok := handled[int64(tag)]; ok {
+					a.errorf(label, "%s is already handled on line %d", label.Name, previous.Line)
+					continue
+				}
+
+				handled[int64(tag)] = label
+				continue
+			}
+
+			labelType := a.analyzeExpression(label)
+			if labelType == "" || valueType == "" {
+				continue
+			}
+
+			value, err := ast.EvaluateConstant(label, a.constantLookup)
+			if err != nil {
+				a.errorf(label, "Case must be a constant: %v", err)
+				continue
+			}
+
+			a.checkConversion(label, labelType, valueType, "case")
+			if labelType != valueType && (!isNumeric(labelType) || !isNumeric(valueType)) {
+				continue
+			}
+
+			key := value.Convert(a.storageType(valueType)).Int
+			if previous, ok := handled[key]; ok {
+				a.errorf(label, "%s is already handled on line %d", a.describeCase(valueType, key), previous.Line)
+				continue
+			}
+
+			handled[key] = label
+		}
+	}
+
+	if fallback != nil {
+		return true, nil
+	}
+
+	var missing []string
+	switch {
+	case a.enums[valueType] != nil:
+		for _, variant := range a.enums[valueType].Variants {
+			if _, ok := handled[variant.Value.Int]; !ok {
+				missing = append(missing, variant.Name)
+				handled[variant.Value.Int] = variant.Node
+			}
+		}
+	case a.unions[valueType] != nil:
+		for tag, variant := range a.unions[valueType].Variants {
+			if _, ok := handled[int64(tag)]; !ok {
+				missing = append(missing, variant.Name)
+			}
+		}
+	case valueType == "bool":
+		for i, name := range []string{"false", "true"} {
+			if _, ok := handled[int64(i)]; !ok {
+				missing = append(missing, name)
+			}
+		}
+	default:
+		return false, nil
+	}
+
+	return len(missing) == 0, missing
+}
+
+// analyzeSwitch checks a switch statement. Leaving out variants of an enum is allowed, but warned about
+func (a *Analyzer) analyzeSwitch(node *ast.ASTNode) {
+	valueType := a.analyzeExpression(node.Children[0])
+	if valueType != "" && !a.isSwitchable(valueType) {
+		a.errorf(node.Children[0], "Cannot switch on %s", valueType)
+		valueType = ""
+	}
+
+	if complete, missing := a.analyzeCases(node, valueType); !complete && len(missing) > 0 {
+		a.warnf(node, "Switch on %s does not handle %s", valueType, strings.Join(missing, ", "))
+	}
+
+	a.switchDepth++
+	for _, c := range node.Children[1:] {
+		a.pushScope()
+		a.declareBindings(node, c)
+		a.analyzeBlock(c.Children[0])
+		a.popScope()
+	}
+	a.switchDepth--
+}
+
+// analyzeMatch checks a match expression, which must handle every value. Arms of different numeric
+// types give the common type, as in arithmetic
+func (a *Analyzer) analyzeMatch(node *ast.ASTNode) string {
+	valueType := a.analyzeExpression(node.Children[0])
+	if valueType != "" && !a.isSwitchable(valueType) {
+		a.errorf(node.Children[0], "Cannot match on %s", valueType)
+		valueType = ""
+	}
+
+	complete, missing := a.analyzeCases(node, valueType)
+
+	switch {
+	case complete || valueType == "":
+	case len(missing) > 0:
+		a.errorf(node, "Match on %s does not handle %s, add them or a default arm", valueType, strings.Join(missing, ", "))
+	default:
+		a.errorf(node, "Match on %s needs a default arm", valueType)
+	}
+
+	result, failed := "", false
+	for _, arm := range node.Children[1:] {
+		a.pushScope()
+		a.declareBindings(node, arm)
+		armType := a.analyzeExpression(arm.Children[0])
+		a.popScope()
+
+		switch {
+		case armType == "":
+			failed = true
+		case result == "" || result == armType:
+			result = armType
+		case isNumeric(result) && isNumeric(armType):
+			result = arithmeticType(result, armType)
+		default:
+			a.errorf(arm.Children[0], "Match arm gives %s, but the arms before it give %s", armType, result)
+			failed = true
+		}
+	}
+
+	if failed {
+		return ""
+	}
+
+	return result
+}
+
+// breaksOut reports whether a break in the statements of node leaves the switch they are in. Breaks in
+// nested loops and switches do not
+func breaksOut(node *ast.ASTNode) bool {
+	for _, child := range node.Children {
+		switch {
+		case child.Type == ast.Statement && child.Name == "break":
+			return true
+		case child.Type == ast.Statement && (child.Name == "if" || child.Name == "unsafe"):
+			if breaksOut(child) {
+				return true
+			}
+		case child.Type == ast.Block:
+			if breaksOut(child) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// describeCase spells a case value for diagnostics, by name for enums
+func (a *Analyzer) describeCase(valueType string, value int64) string {
+	if e, ok := a.enums[valueType]; ok {
+		for _, variant := range e.Variants {
+			if variant.Value.Int == value {
+				return variant.Name
+			}
+		}
+	}
+
+	return "Value " + ast.ConstantValue{Type: a.storageType(valueType), Int: value}.String()
+}
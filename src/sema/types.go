@@ -0,0 +1,162 @@
+package sema
+
+import (
+	"strconv"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// Integer widths in bits, mirroring the LLVM types chosen by the builder
+var integerBits map[string]int = map[string]int{
+	"bool": 1,
+	"char": 8,
+	"int":  32,
+}
+
+// resolveType checks the type named by node and rewrites it to its canonical spelling, with struct
+// names qualified by the module declaring them
+func (a *Analyzer) resolveType(node *ast.ASTNode, allowVoid bool) bool {
+	a.site = node
+	name, ok := a.canonicalType(node.Name, allowVoid)
+	if ok {
+		node.Name = name
+	}
+
+	return ok
+}
+
+func (a *Analyzer) canonicalType(name string, allowVoid bool) (string, bool) {
+	// Inside an instance of a generic, its type parameters stand for the type arguments
+	if a.instance != nil {
+		if arg, ok := a.instance.arguments[name]; ok {
+			return arg, true
+		}
+	}
+
+	switch name {
+	case "int", "float", "char", "bool":
+		return name, true
+	case "void":
+		return name, allowVoid
+	}
+
+	// Pointers may point to anything, void* to memory of any type
+	if pointee, ok := ast.Pointee(name); ok {
+		pointee, ok = a.canonicalType(pointee, true)
+		return ast.PointerType(pointee), ok
+	}
+
+	// Arrays hold values of any type but void
+	if element, ok := ast.Element(name); ok {
+		element, ok = a.canonicalType(element, false)
+		return ast.ArrayType(element), ok
+	}
+
+	// Function types may return void, their parameters follow the usual rules
+	if ret, params, ok := ast.ParseFunctionType(name); ok {
+		for i, param := range params {
+			if params[i], ok = a.canonicalType(param, false); !ok {
+				return "", false
+			}
+		}
+
+		ret, ok = a.canonicalType(ret, true)
+		return ast.FunctionType(ret, params), ok
+	}
+
+	if generic, args, ok := ast.ParseGenericType(name); ok {
+		if a.lookupGeneric(generic) != nil || a.instance == nil {
+			return a.instantiateClass(generic, args)
+		}
+
+		return a.instanceType(name)
+	}
+
+	if g := a.lookupGeneric(name); g != nil && g.Node.Type == ast.ClassDeclaration {
+		if a.instance != nil && a.instance.generic == g {
+			return a.instance.node.Name, true
+		}
+
+		a.errorf(a.typeSite(g), "Generic class '%s' needs type arguments, as in '%s<...>'", g.Name, name)
+		return "", false
+	}
+
+	if s := a.lookupStruct(name); s != nil {
+		return s.Name, true
+	}
+
+	if e := a.lookupEnum(name); e != nil {
+		return e.Name, true
+	}
+
+	if u := a.lookupUnion(name); u != nil {
+		return u.Name, true
+	}
+
+	if c := a.declaredClass(name); c != nil {
+		return c.Name, true
+	}
+
+	if i := a.lookupInterface(name); i != nil {
+		return i.Name, true
+	}
+
+	if a.instance != nil {
+		return a.instanceType(name)
+	}
+
+	return "", false
+}
+
+func isNumeric(name string) bool {
+	_, isInteger := integerBits[name]
+	return isInteger || name == "float"
+}
+
+// Usual arithmetic conversions: float wins, otherwise integers are promoted to int
+func arithmeticType(left, right string) string {
+	if left == "float" || right == "float" {
+		return "float"
+	}
+
+	return "int"
+}
+
+// isLossy reports whether converting the value of node from one numeric type to another can change it
+func isLossy(node *ast.ASTNode, from, to string) bool {
+	if to == "float" {
+		return false
+	}
+
+	if from == "float" {
+		if node.Type == ast.Literal {
+			f, err := strconv.ParseFloat(node.Name, 64)
+			return err != nil || f != float64(int64(f)) || !fitsInteger(int64(f), to)
+		}
+
+		return true
+	}
+
+	if integerBits[to] >= integerBits[from] {
+		return false
+	}
+
+	// Literals that fit the narrower type convert exactly
+	if node.Type == ast.Literal {
+		v, err := strconv.ParseInt(node.Name, 10, 64)
+		return err != nil || !fitsInteger(v, to)
+	}
+
+	return true
+}
+
+func fitsInteger(v int64, to string) bool {
+	bits := integerBits[to]
+
+	if bits == 1 {
+		return v == 0 || v == 1
+	}
+
+	limit := int64(1) << (bits - 1)
+	return v >= -limit && v < limit
+}
@@ -0,0 +1,274 @@
+package sema
+
+import (
+	"fmt"
+	"strings"
+
+	"velox.eparker.dev/src/ast"
+)
+
+// Union is a declared tagged union. A value holds one of the variants, with that variant's fields
+type Union struct {
+	Name     string
+	Variants []*Variant
+	Node     *ast.ASTNode
+}
+
+// Variant is one alternative of a union. Fields are symbols so they carry their declaration
+type Variant struct {
+	Name   string // Qualified by the union, `Shape.Circle`
+	Fields []*Symbol
+	Node   *ast.ASTNode
+}
+
+func (u *Union) variant(name string) *Variant {
+	if i := u.index(name); i >= 0 {
+		return u.Variants[i]
+	}
+
+	return nil
+}
+
+// index returns the position of a variant, which is also its tag
+func (u *Union) index(name string) int {
+	for i, variant := range u.Variants {
+		if variant.Name == u.Name+"."+name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// collectUnions registers every union name, so fields and signatures can use unions declared later
+func (a *Analyzer) collectUnions(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.UnionDeclaration:
+			child.Name = a.qualify(child.Name)
+
+			if existing, ok := a.unions[child.Name]; ok {
+				a.errorf(child, "Union '%s' is already declared on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if existing, ok := a.structs[child.Name]; ok {
+				a.errorf(child, "'%s' is already declared as a struct on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			if existing, ok := a.enums[child.Name]; ok {
+				a.errorf(child, "'%s' is already declared as an enum on line %d", child.Name, existing.Node.Line)
+				continue
+			}
+
+			a.unions[child.Name] = &Union{Name: child.Name, Node: child}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.collectUnions(child.Children) })
+		}
+	}
+}
+
+// analyzeUnions resolves the field types of every variant
+func (a *Analyzer) analyzeUnions(declarations []*ast.ASTNode) {
+	for _, child := range declarations {
+		switch child.Type {
+		case ast.UnionDeclaration:
+			u := a.unions[child.Name]
+			if u == nil || u.Node != child {
+				continue
+			}
+
+			for _, variant := range child.Children {
+				if u.variant(variant.Name) != nil {
+					a.errorf(variant, "Union '%s' already has a variant '%s'", u.Name, variant.Name)
+					continue
+				}
+
+				v := &Variant{Name: u.Name + "." + variant.Name, Node: variant}
+				for _, field := range variant.Children {
+					for _, modifier := range []string{"ref", "weak"} {
+						if field.HasModifier(modifier) {
+							a.errorf(field, "Field '%s' of '%s' cannot be %s", field.Name, v.Name, modifier)
+						}
+					}
+
+					if !a.resolveType(field.Children[0], false) {
+						a.errorf(field.Children[0], "Invalid type '%s' of field '%s' of '%s'", field.Children[0].Name, field.Name, v.Name)
+					} else if a.isReference(field.Children[0].Name) {
+						a.errorf(field.Children[0], "Field '%s' of '%s' cannot hold %s, unions are copied and only classes can hold references", field.Name, v.Name, field.Children[0].Name)
+					}
+
+					field.ResolvedType = field.Children[0].Name
+					v.Fields = append(v.Fields, &Symbol{Name: field.Name, Kind: Variable, Type: field.ResolvedType, Node: field})
+				}
+
+				u.Variants = append(u.Variants, v)
+			}
+
+			if len(u.Variants) == 0 {
+				a.errorf(child, "Union '%s' has no variants", u.Name)
+			}
+		case ast.ImportDeclaration:
+			a.inModule(child, func() { a.analyzeUnions(child.Children) })
+		}
+	}
+}
+
+// lookupUnion resolves a union type name, with the same visibility as structs
+func (a *Analyzer) lookupUnion(name string) *Union {
+	if !a.visibleType(name) {
+		return nil
+	}
+
+	return a.unions[a.qualify(name)]
+}
+
+// unionVariant resolves `Shape.Circle` or `module.Shape.Circle`. Variables hide unions
+func (a *Analyzer) unionVariant(name string) (*Union, *Variant) {
+	typeName, variantName, ok := cutLast(name, ".")
+	if !ok {
+		return nil, nil
+	}
+
+	root, _, _ := strings.Cut(name, ".")
+	if a.lookup(root) != nil {
+		return nil, nil
+	}
+
+	u := a.lookupUnion(typeName)
+	if u == nil {
+		return nil, nil
+	}
+
+	return u, u.variant(variantName)
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
+
+// analyzeUnionLiteral checks `Shape.Circle(1.5)`, or `Shape.Empty` for a variant without fields, and
+// rewrites the node into a union literal named by the variant
+func (a *Analyzer) analyzeUnionLiteral(node *ast.ASTNode, u *Union, v *Variant) string {
+	if v == nil {
+		_, variantName, _ := cutLast(node.Name, ".")
+		a.errorf(node, "Union '%s' has no variant '%s'", u.Name, variantName)
+		return ""
+	}
+
+	if node.Type == ast.MemberAccess {
+		if len(v.Fields) > 0 {
+			a.errorf(node, "'%s' has fields, build it with %s(...)", v.Name, v.Name)
+			return ""
+		}
+
+		node.Children = nil
+	}
+
+	if len(node.Children) != len(v.Fields) {
+		a.errorf(node, "'%s' has %d field(s), got %d", v.Name, len(v.Fields), len(node.Children))
+	}
+
+	for i, arg := range node.Children {
+		if i >= len(v.Fields) {
+			a.analyzeExpression(arg)
+			continue
+		}
+
+		argType := a.analyzeValue(arg, v.Fields[i].Type)
+		a.checkConversion(arg, argType, v.Fields[i].Type, fmt.Sprintf("field '%s' of '%s'", v.Fields[i].Name, v.Name))
+	}
+
+	node.Type = ast.UnionLiteral
+	node.Name = v.Name
+	return u.Name
+}
+
+// analyzePattern checks the pattern of a case on a union, which names a variant and may bind its fields,
+// `Shape.Rect(w, h)` with `_` skipping a field. It returns the variant's tag, or -1 if the pattern is
+// invalid. Only a case with a single pattern can bind fields
+func (a *Analyzer) analyzePattern(pattern *ast.ASTNode, u *Union, shared bool) int {
+	name, ok := "", false
+	switch pattern.Type {
+	case ast.FunctionCall:
+		name, ok = pattern.Name, true
+	case ast.MemberAccess:
+		if base, isName := dottedName(pattern.Children[0]); isName {
+			name, ok = base+"."+pattern.Name, true
+			pattern.Children = nil
+		}
+	}
+
+	patternUnion, v := a.unionVariant(name)
+	if !ok || patternUnion != u {
+		a.errorf(pattern, "Case must be a variant of '%s'", u.Name)
+		return -1
+	}
+
+	if v == nil {
+		_, variantName, _ := cutLast(name, ".")
+		a.errorf(pattern, "Union '%s' has no variant '%s'", u.Name, variantName)
+		return -1
+	}
+
+	// The variant counts as handled even if its bindings are wrong, which would only add more errors
+	switch {
+	case pattern.Type != ast.FunctionCall:
+	case len(pattern.Children) != len(v.Fields):
+		a.errorf(pattern, "'%s' has %d field(s), the pattern binds %d", v.Name, len(v.Fields), len(pattern.Children))
+	case shared && len(pattern.Children) > 0:
+		a.errorf(pattern, "A case with several patterns cannot bind fields")
+	}
+
+	for i, binding := range pattern.Children {
+		if binding.Type != ast.Identifier {
+			a.errorf(binding, "Patterns bind fields to names, use '_' to skip one")
+			pattern.Children = nil
+			break
+		}
+
+		if i < len(v.Fields) {
+			binding.ResolvedType = v.Fields[i].Type
+		}
+	}
+
+	pattern.Type = ast.UnionLiteral
+	pattern.Name = v.Name
+	pattern.ResolvedType = u.Name
+	_, variantName, _ := cutLast(v.Name, ".")
+	return u.index(variantName)
+}
+
+// dottedName spells a chain of names such as `geo.Shape`
+func dottedName(node *ast.ASTNode) (string, bool) {
+	switch node.Type {
+	case ast.Identifier:
+		return node.Name, true
+	case ast.MemberAccess:
+		if base, ok := dottedName(node.Children[0]); ok {
+			return base + "." + node.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// declareBindings makes the fields bound by the pattern of a case of node visible in its body. They are
+// copies of the fields and cannot be assigned
+func (a *Analyzer) declareBindings(node, c *ast.ASTNode) {
+	if len(c.Children) != 2 || c.Children[1].Type != ast.UnionLiteral {
+		return
+	}
+
+	from := a.sources(node.Children[0])
+	for _, binding := range c.Children[1].Children {
+		if binding.Name != "_" {
+			a.declare(&Symbol{Name: binding.Name, Kind: Variable, Type: binding.ResolvedType, Const: true, Node: binding, Flows: from})
+		}
+	}
+}
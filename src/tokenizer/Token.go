@@ -1,5 +1,7 @@
 package tokenizer
 
+import "fmt"
+
 type TokenType int
 
 const (
@@ -34,8 +36,43 @@ type Token struct {
 	Type         TokenType
 	Value        string
 	Line, Column int
+	File         string     // Source file the token was read from, empty for generated tokens
+	Expansion    *Expansion // Set on tokens produced by a function-like macro
+}
+
+// Expansion records the macro a token was produced by. Expanded tokens take the position of the
+// macro's use site, the expansion remembers where the macro itself is defined
+type Expansion struct {
+	Macro        string
+	Line, Column int
+	File         string
+	Parent       *Expansion // Set when the macro was used inside another macro
+}
+
+// Notes describes the chain of macro expansions, innermost first
+func (e *Expansion) Notes() []string {
+	var notes []string
+
+	for ; e != nil; e = e.Parent {
+		notes = append(notes, fmt.Sprintf("%s: note: expanded from macro '%s'", FormatPosition(e.File, e.Line, e.Column), e.Macro))
+	}
+
+	return notes
 }
 
 func (token Token) String() string {
 	return TokenTypeNames[token.Type] + "(" + token.Value + ")"
 }
+
+// Position formats where the token came from for error messages
+func (token Token) Position() string {
+	return FormatPosition(token.File, token.Line, token.Column)
+}
+
+func FormatPosition(file string, line, column int) string {
+	if file == "" {
+		return fmt.Sprintf("Line %d, Column %d", line, column)
+	}
+
+	return fmt.Sprintf("%s: Line %d, Column %d", file, line, column)
+}
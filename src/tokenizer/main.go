@@ -42,7 +42,7 @@ func Tokenize(code string, significantOnly bool) []Token {
 
 			if len(match) > 0 {
 				if !significantOnly || (tokenType != Whitespace && tokenType != Comment) {
-					tokens = append(tokens, Token{tokenType, match, line, col})
+					tokens = append(tokens, Token{Type: tokenType, Value: match, Line: line, Column: col})
 				}
 				position += len(match)
 				matched = true
@@ -58,3 +58,18 @@ func Tokenize(code string, significantOnly bool) []Token {
 
 	return tokens
 }
+
+// TokenizeFile reads and tokenizes a source file, tagging every token with its path
+func TokenizeFile(path string) ([]Token, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := Tokenize(string(code), true)
+	for i := range tokens {
+		tokens[i].File = path
+	}
+
+	return tokens, nil
+}
@@ -13,13 +13,15 @@ func GetRegexExpression(tokenType TokenType) string {
 	case Number:
 		return `^\d+(\.\d*)?`
 	case String:
-		return `^"[^"]*"`
+		// Double-quoted strings and single-quoted character literals, both with backslash escapes
+		return `^"(\\.|[^"\\])*"|^'(\\.|[^'\\])'`
 	case Keyword:
-		return `^(int|float|char|void|class|return|while|continue|break|if|else|New)\b`
+		return `^(int|float|char|bool|void|class|struct|interface|enum|union|return|while|continue|break|if|else|switch|case|default|match|New|delete|as|const|ref|weak|virtual|override|super|unsafe|import|true|false|maybe|null|oops)\b`
 	case Macro:
 		return `^::`
 	case Operator:
-		return `^([+\-*/=<>!%&|^]=?|\+\+|--|&&|\|\||\*=|<<=?|>>=?|~|\?|:)`
+		// Multi-character operators come first so `++` is not read as two `+`
+		return `^(\+\+|--|&&|\|\||=>|<<=?|>>=?|[+\-*/=<>!%&|^]=?|~|\?|:)`
 	case Punctuation:
 		return `^[{}()\[\];,.]`
 	case Identifier: